@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// commandAliases maps synonyms to the canonical command they run
+var commandAliases = map[string]string{
+	"add":    "import",
+	"rm":     "delete",
+	"ls":     "list",
+	"vacuum": "maintenance",
+}
+
+// aliases are registered in commands after it is initialized, as shell is
+func init() {
+	for alias, name := range commandAliases {
+		commands[alias] = commands[name]
+	}
+}
+
+// canonicalCommand returns the command an alias stands for, other names are
+// returned unchanged
+func canonicalCommand(name string) string {
+	if canonical, ok := commandAliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// printAliases lists the canonical commands having aliases, with them
+func printAliases() {
+	byCommand := make(map[string][]string)
+	for alias, name := range commandAliases {
+		byCommand[name] = append(byCommand[name], alias)
+	}
+	names := make([]string, 0, len(byCommand))
+	for name := range byCommand {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	fmt.Println("aliases:")
+	for _, name := range names {
+		slices.Sort(byCommand[name])
+		fmt.Printf("\t%-27s # %s\n", name, strings.Join(byCommand[name], ", "))
+	}
+}
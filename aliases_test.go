@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCommandAliases(t *testing.T) {
+	for alias, name := range commandAliases {
+		fn, ok := commands[alias]
+		if !ok {
+			t.Errorf("alias %s is not registered", alias)
+			continue
+		}
+		if reflect.ValueOf(fn).Pointer() != reflect.ValueOf(commands[name]).Pointer() {
+			t.Errorf("alias %s does not run %s", alias, name)
+		}
+		if got := canonicalCommand(alias); got != name {
+			t.Errorf("%s: got %s, expected %s", alias, got, name)
+		}
+	}
+	if got := canonicalCommand("challenge"); got != "challenge" {
+		t.Errorf("got %s, expected challenge unchanged", got)
+	}
+
+	useTestDB(t)
+	if err := commands["add"]([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := commands["rm"]([]string{ecKey.GetFingerprint()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v, expected %v", err, ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const backupVersion = 1
+
+var ErrBackupVersion = errors.New("unsupported backup version")
+
+// backup is the on-disk format, independent from the database schema
+type backup struct {
+	Version    int               `json:"version"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Keys       []backupKey       `json:"keys"`
+	TOTP       []backupTOTP      `json:"totp,omitempty"`
+	Challenges []backupChallenge `json:"challenges,omitempty"`
+}
+
+type backupKey struct {
+	Fingerprint string    `json:"fingerprint"`
+	PubKey      string    `json:"pub_key"` // armored
+	CreatedAt   time.Time `json:"created_at"`
+	Label       string    `json:"label,omitempty"`
+	Card        bool      `json:"card,omitempty"`
+	Expired     bool      `json:"expired,omitempty"`
+	Trust       string    `json:"trust,omitempty"`
+	Revoked     bool      `json:"revoked,omitempty"`
+	// ArmorHeaders are the kept armor header lines, see import --preserve-headers
+	ArmorHeaders string `json:"armor_headers,omitempty"`
+	Origin       string `json:"origin,omitempty"`
+
+	Defaults *ChallengeDefaults `json:"challenge_defaults,omitempty"`
+}
+
+type backupTOTP struct {
+	Fingerprint string    `json:"fingerprint"`
+	Secret      []byte    `json:"secret"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastCounter int64     `json:"last_counter,omitempty"`
+}
+
+// backupChallenge is an issued challenge, without solution like the
+// challenges table
+type backupChallenge struct {
+	ID          string     `json:"id"`
+	Fingerprint string     `json:"fingerprint"`
+	IssuedAt    time.Time  `json:"issued_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	SolvedAt    *time.Time `json:"solved_at,omitempty"`
+}
+
+func backupDB(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	overwrite := fs.Bool("allow-overwrite", false, "replace the backup file if it exists")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa backup [--allow-overwrite] <file>")
+	}
+	b := backup{Version: backupVersion, CreatedAt: time.Now()}
+
+	stored, err := store.List(KeyQuery{})
+	if err != nil {
+		return err
+	}
+	// Stored ids may be hashes, the backup holds the actual fingerprints
+	fingerprints := make(map[string]string, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- { // oldest first
+		key, err := parseKey(bytes.NewReader(stored[i].PubKey))
+		if err != nil {
+			return err
+		}
+		fingerprints[stored[i].Fingerprint] = key.GetFingerprint()
+		armored, err := key.GetArmoredPublicKey()
+		if err != nil {
+			return parseError("%w: %w", ErrPubKeyFail, err)
+		}
+		b.Keys = append(b.Keys, backupKey{
+			Fingerprint:  key.GetFingerprint(),
+			PubKey:       armored,
+			CreatedAt:    stored[i].CreatedAt,
+			Label:        stored[i].Label,
+			Card:         stored[i].Card,
+			Expired:      stored[i].Expired,
+			Trust:        stored[i].Trust,
+			Revoked:      stored[i].Revoked,
+			ArmorHeaders: stored[i].ArmorHeaders,
+			Origin:       stored[i].Origin,
+		})
+		if stored[i].Defaults != (ChallengeDefaults{}) {
+			b.Keys[len(b.Keys)-1].Defaults = &stored[i].Defaults
+		}
+	}
+
+	totpRows, err := db.Query(`SELECT fingerprint, secret, created_at, last_counter FROM totp WHERE tenant = ? ORDER BY created_at`, activeTenant)
+	if err != nil {
+		return dbError("failed to query totp secrets: %w", err)
+	}
+	defer totpRows.Close()
+	for totpRows.Next() {
+		var t backupTOTP
+		if err := totpRows.Scan(&t.Fingerprint, &t.Secret, &t.CreatedAt, &t.LastCounter); err != nil {
+			return dbError("failed to scan row: %w", err)
+		}
+		if fingerprint, ok := fingerprints[t.Fingerprint]; ok {
+			t.Fingerprint = fingerprint
+		}
+		b.TOTP = append(b.TOTP, t)
+	}
+	if err := totpRows.Err(); err != nil {
+		return dbError("failed to iterate totp secrets: %w", err)
+	}
+
+	challengeRows, err := db.Query(`SELECT id, fingerprint, issued_at, expires_at, solved_at FROM challenges WHERE tenant = ? ORDER BY issued_at`, activeTenant)
+	if err != nil {
+		return dbError("failed to query challenges: %w", err)
+	}
+	defer challengeRows.Close()
+	for challengeRows.Next() {
+		var c challengeRecord
+		if err := challengeRows.Scan(&c.ID, &c.Fingerprint, &c.IssuedAt, &c.ExpiresAt, &c.SolvedAt); err != nil {
+			return dbError("failed to scan challenge: %w", err)
+		}
+		if fingerprint, ok := fingerprints[c.Fingerprint]; ok {
+			c.Fingerprint = fingerprint
+		}
+		bc := backupChallenge{ID: c.ID, Fingerprint: c.Fingerprint, IssuedAt: c.IssuedAt, ExpiresAt: c.ExpiresAt}
+		if c.SolvedAt.Valid {
+			bc.SolvedAt = &c.SolvedAt.Time
+		}
+		b.Challenges = append(b.Challenges, bc)
+	}
+	if err := challengeRows.Err(); err != nil {
+		return dbError("failed to iterate challenges: %w", err)
+	}
+
+	// The backup holds totp secrets, keep it private
+	file, err := createOutputFile(args[0], *overwrite)
+	if errors.Is(err, ErrOutputExists) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(b); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	slog.Info("backup written", "event", "backup", "keys", len(b.Keys), "totp_secrets", len(b.TOTP), "challenges", len(b.Challenges), "file", args[0])
+	return nil
+}
+
+func restoreDB(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa restore <file>")
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+	var b backup
+	if err := json.NewDecoder(file).Decode(&b); err != nil {
+		return parseError("failed to parse backup: %w", err)
+	}
+	if b.Version != backupVersion {
+		return parseError("%w: %d", ErrBackupVersion, b.Version)
+	}
+
+	var restored, skipped int
+	for _, k := range b.Keys {
+		// Go through the same checks as a regular import
+		key, err := parseKey(strings.NewReader(k.PubKey))
+		if err == nil {
+			err = validateKey(key)
+			if k.Expired && errors.Is(err, ErrKeyExp) { // knowingly imported expired
+				err = nil
+			}
+		}
+		if err == nil {
+			k.Trust, err = parseTrust(k.Trust)
+		}
+		var defaults ChallengeDefaults
+		if err == nil && k.Defaults != nil {
+			defaults = *k.Defaults
+			err = defaults.validate()
+		}
+		if err != nil {
+			slog.Warn("skipping invalid key", "event", "restore", "fingerprint", k.Fingerprint, "error", err)
+			skipped++
+			continue
+		}
+		err = store.Import(key, KeyInfo{CreatedAt: k.CreatedAt, Label: k.Label, Card: k.Card, Expired: k.Expired, Trust: k.Trust, Revoked: k.Revoked, Defaults: defaults, ArmorHeaders: k.ArmorHeaders, Origin: k.Origin})
+		if errors.Is(err, ErrAlreadyImported) {
+			slog.Info("skipping key already imported", "event", "restore", "fingerprint", key.GetFingerprint())
+			skipped++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		restored++
+	}
+
+	for _, t := range b.TOTP {
+		if _, err := store.Get(t.Fingerprint); err != nil {
+			slog.Warn("skipping totp secret", "event", "restore", "fingerprint", t.Fingerprint, "error", err)
+			continue
+		}
+		_, err := db.Exec(`INSERT OR IGNORE INTO totp (tenant, fingerprint, secret, created_at, last_counter) VALUES (?, ?, ?, ?, ?)`,
+			activeTenant,
+			fingerprintID(t.Fingerprint),
+			t.Secret,
+			t.CreatedAt,
+			t.LastCounter,
+		)
+		if err != nil {
+			return dbError("totp restore error: %w", err)
+		}
+	}
+
+	for _, c := range b.Challenges {
+		if _, err := store.Get(c.Fingerprint); err != nil {
+			slog.Warn("skipping challenge", "event", "restore", "id", c.ID, "fingerprint", c.Fingerprint, "error", err)
+			continue
+		}
+		var solvedAt sql.NullTime
+		if c.SolvedAt != nil {
+			solvedAt = sql.NullTime{Time: c.SolvedAt.UTC(), Valid: true}
+		}
+		_, err := db.Exec(`INSERT OR IGNORE INTO challenges (tenant, id, fingerprint, issued_at, expires_at, solved_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			activeTenant,
+			c.ID,
+			fingerprintID(c.Fingerprint),
+			c.IssuedAt.UTC(),
+			c.ExpiresAt.UTC(),
+			solvedAt,
+		)
+		if err != nil {
+			return dbError("challenge restore error: %w", err)
+		}
+	}
+	slog.Info("backup restored", "event", "restore", "restored", restored, "skipped", skipped)
+	return nil
+}
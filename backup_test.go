@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+func TestBackupRestore(t *testing.T) {
+	useTestDB(t)
+	for _, key := range []*crypto.Key{ecKey, rsa3072Key} {
+		if err := importKey([]string{writeKeyFile(t, key, false)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := totpEnroll([]string{ecKey.GetFingerprint()}); err != nil {
+		t.Fatal(err)
+	}
+	const headers = "Comment: generated by test"
+	if err := store.Update(ecKey.GetFingerprint(), func(info *KeyInfo) { info.ArmorHeaders = headers }); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := getTOTPSecret(ecKey.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	for _, id := range []string{"solved", "pending"} {
+		issued := &IssuedChallenge{ID: id, IssuedAt: now, ExpiresAt: now.Add(time.Minute)}
+		if err := recordChallenge(ecKey.GetFingerprint(), issued, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := markChallengeSolved("solved", now); err != nil {
+		t.Fatal(err)
+	}
+	backupFile := filepath.Join(t.TempDir(), "backup.json")
+	if err := backupDB([]string{backupFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backupDB([]string{backupFile}); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("existing backup file: got %v, expected %v", err, ErrOutputExists)
+	}
+	if err := backupDB([]string{"--allow-overwrite", backupFile}); err != nil {
+		t.Errorf("overwrite refused: %v", err)
+	}
+
+	// Restore into an empty database
+	useTestDB(t)
+	if err := restoreDB([]string{backupFile}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []*crypto.Key{ecKey, rsa3072Key} {
+		_, restored, err := getKey(key.GetFingerprint(), KeyQuery{})
+		if err != nil {
+			t.Fatalf("key %s not restored: %v", key.GetFingerprint(), err)
+		}
+		if restored.GetFingerprint() != key.GetFingerprint() {
+			t.Errorf("got %s, expected %s", restored.GetFingerprint(), key.GetFingerprint())
+		}
+	}
+	if stored, err := store.Get(ecKey.GetFingerprint()); err != nil || stored.ArmorHeaders != headers {
+		t.Errorf("armor headers: got %q (%v), expected %q", stored.ArmorHeaders, err, headers)
+	}
+	restoredSecret, err := getTOTPSecret(ecKey.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(restoredSecret) != string(secret) {
+		t.Error("totp secret changed across backup / restore")
+	}
+	records, err := listChallenges(ecKey.GetFingerprint(), true, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]string)
+	for _, c := range records {
+		statuses[c.ID] = c.status(now)
+		if !c.IssuedAt.Equal(now) || !c.ExpiresAt.Equal(now.Add(time.Minute)) {
+			t.Errorf("challenge %s: issued %s expires %s, expected %s and %s", c.ID, c.IssuedAt, c.ExpiresAt, now, now.Add(time.Minute))
+		}
+	}
+	if len(statuses) != 2 || statuses["solved"] != challengeSolved || statuses["pending"] != challengePending {
+		t.Errorf("restored challenges: got %v, expected one solved and one pending", statuses)
+	}
+
+	// Restoring twice skips duplicates instead of failing
+	if err := restoreDB([]string{backupFile}); err != nil {
+		t.Errorf("second restore failed: %v", err)
+	}
+}
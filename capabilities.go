@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// keyAlgorithms are the OpenPGP public key algorithms the OpenPGP library
+// parses, whether they can receive challenges is asked to it
+var keyAlgorithms = []struct {
+	name string
+	algo packet.PublicKeyAlgorithm
+}{
+	{"rsa", packet.PubKeyAlgoRSA},
+	{"elgamal", packet.PubKeyAlgoElGamal},
+	{"ecdh", packet.PubKeyAlgoECDH},
+	{"x25519", packet.PubKeyAlgoX25519},
+	{"x448", packet.PubKeyAlgoX448},
+	{"dsa", packet.PubKeyAlgoDSA},
+	{"ecdsa", packet.PubKeyAlgoECDSA},
+	{"eddsa", packet.PubKeyAlgoEdDSA},
+	{"ed25519", packet.PubKeyAlgoEd25519},
+	{"ed448", packet.PubKeyAlgoEd448},
+}
+
+// ecdhCurves are the curves of ecdh encryption keys
+var ecdhCurves = []packet.Curve{
+	packet.Curve25519,
+	packet.Curve448,
+	packet.CurveNistP256,
+	packet.CurveNistP384,
+	packet.CurveNistP521,
+	packet.CurveBrainpoolP256,
+	packet.CurveBrainpoolP384,
+	packet.CurveBrainpoolP512,
+}
+
+// challengeModes are the challenge flags changing what is encrypted or how
+// it is solved
+var challengeModes = [][]string{
+	{"--wrap", "the plaintext reads <prefix>{challenge}, solutions with or without it"},
+	{"--structured", "the plaintext is a json object, solved with its nonce"},
+	{"--case-insensitive", "solutions in any case, fewer bits of entropy"},
+	{"--checksum", "a check character tells mistyped solutions apart"},
+	{"--symmetric-password", "the plaintext is also encrypted with a password"},
+	{"--sign-with", "the challenge is signed"},
+	{"--allow-totp", "the key's totp code is also a solution"},
+}
+
+// capabilityRows lists what this build supports: key algorithms, curves,
+// profiles, charsets, challenge sizes and modes, as category/name/detail
+func capabilityRows(profiles []string) [][]string {
+	var rows [][]string
+	for _, a := range keyAlgorithms {
+		detail := "signing only, challenges need an encryption subkey"
+		if a.algo.CanEncrypt() {
+			detail = "encryption"
+		}
+		rows = append(rows, []string{"key-algorithm", a.name, detail})
+	}
+	for _, curve := range ecdhCurves {
+		detail := "supported"
+		if slices.Contains(nistCurves, curve) {
+			detail = "NIST, subject to import --nist-curves"
+		}
+		rows = append(rows, []string{"ecdh-curve", strings.ToLower(string(curve)), detail})
+	}
+	for _, name := range profiles {
+		detail := ""
+		if name == activeProfile {
+			detail = "active"
+		}
+		rows = append(rows, []string{"profile", name, detail})
+	}
+	rows = append(rows,
+		[]string{"charset", "default", fmt.Sprintf("%d characters: %s", len(challengeCharset), challengeCharset)},
+		[]string{"charset", "case-insensitive", fmt.Sprintf("%d characters: %s", len(foldCase(challengeCharset)), foldCase(challengeCharset))},
+	)
+	sizes := make([]string, 0, len(challengeSizes))
+	for name := range challengeSizes {
+		sizes = append(sizes, name)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return challengeSizes[sizes[i]] < challengeSizes[sizes[j]] })
+	for _, name := range sizes {
+		rows = append(rows, []string{"challenge-size", name, fmt.Sprint(challengeSizes[name])})
+	}
+	rows = append(rows, []string{"challenge-length", fmt.Sprintf("%d-%d", MinChallengeLength, MaxChallengeLength), "powers of two"})
+	for _, mode := range challengeModes {
+		rows = append(rows, []string{"challenge-mode", mode[0], mode[1]})
+	}
+	for _, action := range []string{timeoutActionError, timeoutActionReissue} {
+		rows = append(rows, []string{"timeout-action", action, ""})
+	}
+	for _, level := range trustLevels {
+		rows = append(rows, []string{"trust-level", level, ""})
+	}
+	return rows
+}
+
+// capabilities prints what the tool supports, to craft valid invocations
+func capabilities(args []string) error {
+	fs := flag.NewFlagSet("capabilities", flag.ContinueOnError)
+	format := fs.String("output-format", "", "table, csv or json")
+	asJSON := fs.Bool("json", false, "shorthand for --output-format json")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa capabilities [--output-format table|csv|json] [--json]")
+	}
+	if *asJSON {
+		*format = formatJSON
+	}
+	f, err := outputFormat(*format)
+	if err != nil {
+		return err
+	}
+	profiles, err := listProfileNames()
+	if err != nil {
+		return err
+	}
+	return writeRecords(os.Stdout, f, []string{"category", "name", "detail"}, capabilityRows(profiles))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCapabilityRows(t *testing.T) {
+	rows := capabilityRows([]string{defaultProfile, "work"})
+	for _, expected := range [][]string{
+		{"key-algorithm", "rsa", "encryption"},
+		{"key-algorithm", "x25519", "encryption"},
+		{"key-algorithm", "ed25519", "signing only, challenges need an encryption subkey"},
+		{"ecdh-curve", "curve25519", "supported"},
+		{"ecdh-curve", "p256", "NIST, subject to import --nist-curves"},
+		{"profile", defaultProfile, "active"},
+		{"profile", "work", ""},
+		{"challenge-size", "medium", "32"},
+		{"challenge-mode", "--checksum", "a check character tells mistyped solutions apart"},
+		{"timeout-action", timeoutActionReissue, ""},
+	} {
+		if !slices.ContainsFunc(rows, func(row []string) bool { return slices.Equal(row, expected) }) {
+			t.Errorf("missing %v", expected)
+		}
+	}
+	if err := capabilities([]string{"--json"}); err != nil {
+		t.Error(err)
+	}
+	if err := capabilities([]string{"extra"}); err == nil {
+		t.Error("expected a usage error")
+	}
+}
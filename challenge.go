@@ -0,0 +1,392 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// ChallengeConfig holds everything a challenge issuance depends on, passing it
+// explicitly lets concurrent issuances use different settings
+type ChallengeConfig struct {
+	Length     int             // power of two between 1 and 512
+	SolveTime  time.Duration   // time left to solve the challenge once issued
+	Charset    string          // characters the challenge is drawn from
+	SigningKey *crypto.Key     // optional, signs the encrypted challenge
+	Comment    string          // optional armor header comment
+	Wrap       string          // optional prefix, the plaintext becomes <Wrap>{<challenge>}
+	Structured bool            // the plaintext is a structuredChallenge json object
+	Encryptors *encryptorCache // optional, reuses the encryption handle of each key
+	Password   []byte          // optional, solving also requires it, see passwordLayer
+	Rand       io.Reader       // optional, tests only: the challenge randomness source
+	MinLength  int             // policy bounds of Length, within the hard 1-512 range
+	MaxLength  int
+
+	// CaseInsensitive accepts solutions whatever the case of their letters,
+	// lowering the entropy to the one of foldCase(Charset)
+	CaseInsensitive bool
+	// Checksum appends a check character to the challenge, solutions are
+	// entered with it so that typos are told apart from wrong solutions
+	Checksum bool
+}
+
+// solutionCharset is the charset solutions are effectively drawn from, the
+// one the entropy is computed for
+func (c ChallengeConfig) solutionCharset() string {
+	if c.CaseInsensitive {
+		return foldCase(c.Charset)
+	}
+	return c.Charset
+}
+
+// challengeRand is the randomness source of challenges, only builds with the
+// unsafe_seed tag may replace it, see seed_unsafe.go
+var challengeRand io.Reader = rand.Reader
+
+// Challenge length policy, set by the global --min-length and --max-length
+var (
+	MinChallengeLength = 1
+	MaxChallengeLength = 512
+)
+
+var ErrLengthPolicy = errors.New("challenge length is outside of the allowed range")
+
+// validateLengthPolicy checks the policy bounds, they cannot loosen the hard
+// 1-512 range
+func validateLengthPolicy(minLength, maxLength int) error {
+	if minLength < 1 || maxLength > 512 || minLength > maxLength {
+		return parseError("invalid challenge length policy %d-%d, the bounds must be within 1-512", minLength, maxLength)
+	}
+	return nil
+}
+
+func defaultChallengeConfig(length int) ChallengeConfig {
+	return ChallengeConfig{
+		Length:    length,
+		SolveTime: ChallengeSolveTime,
+		Charset:   challengeCharset,
+		MinLength: MinChallengeLength,
+		MaxLength: MaxChallengeLength,
+	}
+}
+
+func (c ChallengeConfig) validate() error {
+	if c.Length <= 0 || c.Length > 512 {
+		return policyError("%w", ErrChallengeLength)
+	}
+	if (c.Length & (c.Length - 1)) != 0 {
+		return policyError("%w", ErrChallengePow)
+	}
+	if c.Length < c.MinLength || c.MaxLength != 0 && c.Length > c.MaxLength {
+		return policyError("%w: %d-%d", ErrLengthPolicy, c.MinLength, c.MaxLength)
+	}
+	if c.SolveTime <= 0 {
+		return parseError("the challenge timeout must be positive")
+	}
+	if c.Structured && c.Wrap != "" {
+		return parseError("a structured challenge cannot be wrapped")
+	}
+	if c.Structured && c.Checksum {
+		return parseError("a structured challenge is pasted, it cannot have a checksum")
+	}
+	return nil
+}
+
+// IssuedChallenge is a challenge encrypted to its recipient, waiting for its
+// solution
+type IssuedChallenge struct {
+	ID        string // random, identifies the challenge in logs and receipts
+	Solution  []byte
+	Encrypted []byte
+	Armored   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// issueChallenge generates a challenge following cfg and encrypts it to key,
+// it expires cfg.SolveTime after now. It is safe for concurrent use as long as
+// keys are not shared between goroutines: go-crypto caches signature
+// verifications in the key itself.
+func issueChallenge(key *crypto.Key, cfg ChallengeConfig, now time.Time) (*IssuedChallenge, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	solution, err := generateChallenge(cmp.Or(cfg.Rand, challengeRand), cfg.Length, cfg.Charset)
+	if err != nil {
+		return nil, err
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge id: %w", err)
+	}
+	id, expiresAt := hex.EncodeToString(idBytes), now.Add(cfg.SolveTime)
+	plaintext := wrapChallenge(cfg.appendChecksum(solution), cfg.Wrap)
+	if cfg.Structured {
+		if plaintext, err = json.Marshal(structuredChallenge{Nonce: string(solution), Exp: expiresAt.UTC(), ID: id}); err != nil {
+			return nil, fmt.Errorf("failed to encode structured challenge: %w", err)
+		}
+	}
+	if cfg.Password != nil {
+		if plaintext, err = passwordLayer(plaintext, cfg.Password); err != nil {
+			return nil, err
+		}
+	}
+	var encrypted []byte
+	var armored string
+	if cfg.Encryptors != nil {
+		encrypted, armored, err = cfg.Encryptors.encrypt(key, plaintext, cfg.SigningKey, cfg.Comment)
+	} else {
+		encrypted, armored, err = encryptChallenge(key, plaintext, cfg.SigningKey, cfg.Comment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedChallenge{
+		ID:        id,
+		Solution:  solution,
+		Encrypted: encrypted,
+		Armored:   armored,
+		IssuedAt:  now,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// slowEncryptionBits and slowEncryptionLength are the RSA key size and the
+// challenge length from which encrypting is slow enough, on slow machines,
+// to look like a hang, see the RSA benchmarks
+const (
+	slowEncryptionBits   = 4096
+	slowEncryptionLength = 128
+)
+
+// slowEncryption guesses from the algorithm and size of the encryption key
+// of key, and from the challenge length, whether encrypting takes long
+// enough to deserve a status
+func slowEncryption(key *crypto.Key, length int) bool {
+	encryptionKey, ok := key.GetEntity().EncryptionKey(time.Now(), nil)
+	if !ok || length < slowEncryptionLength {
+		return false
+	}
+	switch encryptionKey.PublicKey.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly:
+		bits, err := encryptionKey.PublicKey.BitLength()
+		return err == nil && int(bits) >= slowEncryptionBits
+	}
+	return false
+}
+
+// passwordLayer encrypts the challenge with password, the armored result is
+// then encrypted to the key. A single message with both a key and a password
+// recipient could be decrypted with either of them, nesting the messages
+// requires both.
+func passwordLayer(plaintext, password []byte) ([]byte, error) {
+	handle, err := crypto.PGP().Encryption().Password(password).New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pgp context: %w", err)
+	}
+	encrypted, err := handle.Encrypt(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt challenge with the password: %w", err)
+	}
+	armored, err := encrypted.Armor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor challenge: %w", err)
+	}
+	return []byte(armored), nil
+}
+
+const (
+	// challengeFilePrefix names the files challenges are written to
+	challengeFilePrefix = "pgp-mfa-challenge-"
+
+	// defaultSolveHint is the command printed to solve a challenge file
+	defaultSolveHint = "gpg -dq --batch < {file}"
+
+	// passwordSolveHint also decrypts the password layer, gpg prompts for it
+	passwordSolveHint = "gpg -dq --batch < {file} | gpg -dq"
+)
+
+// encryptorCache keeps an encryption handle per recipient and signing key
+// pair, sparing the handle setup when issuing many challenges to the same
+// keys. A handle shares its keys' go-crypto caches, so each one is only used
+// by one goroutine at a time. Handles are replaced once the public keys
+// change, e.g. a refresh added a subkey.
+type encryptorCache struct {
+	mu      sync.Mutex
+	handles map[string]*cachedEncryptor
+}
+
+type cachedEncryptor struct {
+	mu     sync.Mutex
+	digest [sha256.Size]byte // of the public keys the handle was made with
+	handle crypto.PGPEncryption
+}
+
+// publicKeysDigest hashes the serialized public keys, nil ones skipped
+func publicKeysDigest(keys ...*crypto.Key) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	for _, key := range keys {
+		if key == nil {
+			continue
+		}
+		pub, err := key.GetPublicKey()
+		if err != nil {
+			return [sha256.Size]byte{}, parseError("%w: %w", ErrPubKeyFail, err)
+		}
+		h.Write(pub)
+	}
+	return [sha256.Size]byte(h.Sum(nil)), nil
+}
+
+func newEncryptorCache() *encryptorCache {
+	return &encryptorCache{handles: make(map[string]*cachedEncryptor)}
+}
+
+func (c *encryptorCache) encrypt(key *crypto.Key, challenge []byte, signingKey *crypto.Key, comment string) ([]byte, string, error) {
+	id := key.GetFingerprint()
+	if signingKey != nil {
+		id += "/" + signingKey.GetFingerprint()
+	}
+	digest, err := publicKeysDigest(key, signingKey)
+	if err != nil {
+		return nil, "", err
+	}
+	c.mu.Lock()
+	cached, ok := c.handles[id]
+	if !ok || cached.digest != digest {
+		handle, err := newEncryptionHandle(key, signingKey)
+		if err != nil {
+			c.mu.Unlock()
+			return nil, "", err
+		}
+		cached = &cachedEncryptor{digest: digest, handle: handle}
+		c.handles[id] = cached
+	}
+	c.mu.Unlock()
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	return encryptWith(cached.handle, challenge, comment)
+}
+
+// wrapChallenge delimits the challenge as <prefix>{<challenge>} so its
+// boundaries stand out, an empty prefix leaves it as is
+func wrapChallenge(challenge []byte, prefix string) []byte {
+	if prefix == "" {
+		return challenge
+	}
+	return []byte(prefix + "{" + string(challenge) + "}")
+}
+
+// unwrapSolution strips the wrapChallenge delimiters from a solution, which
+// may be entered with or without them
+func unwrapSolution(solution, prefix string) string {
+	if prefix == "" {
+		return solution
+	}
+	if inner, ok := strings.CutPrefix(solution, prefix+"{"); ok {
+		if inner, ok := strings.CutSuffix(inner, "}"); ok {
+			return inner
+		}
+	}
+	return solution
+}
+
+// structuredChallenge is the plaintext of --structured challenges, letting
+// the solver's tooling check the expiry and answer with the nonce
+type structuredChallenge struct {
+	Nonce string    `json:"nonce"`
+	Exp   time.Time `json:"exp"`
+	ID    string    `json:"id"`
+}
+
+// structuredNonce returns the nonce of a pasted structured challenge, other
+// solutions are returned as is. Only --structured challenges go through it
+func structuredNonce(solution string) string {
+	var structured structuredChallenge
+	if !strings.HasPrefix(solution, "{") || json.Unmarshal([]byte(solution), &structured) != nil || structured.Nonce == "" {
+		return solution
+	}
+	return structured.Nonce
+}
+
+var ErrBinaryTerminal = errors.New("refusing to write binary output to a terminal")
+
+// checkBinaryOutput refuses to dump a binary challenge or keyring on a
+// terminal unless forced, redirected or piped stdout is fine
+func checkBinaryOutput(isTerminal, force bool) error {
+	if isTerminal && !force {
+		return policyError("%w, redirect stdout, drop --binary for armored output or use --force", ErrBinaryTerminal)
+	}
+	return nil
+}
+
+// solveHint renders the solve command template for the challenge file path
+func solveHint(template, path string) string {
+	return strings.ReplaceAll(template, "{file}", path)
+}
+
+// challengeDir is the default directory for challenge files: the per-user
+// runtime directory when there is one, a private cache directory otherwise
+func challengeDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir, nil
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find a private directory for challenge files: %w", err)
+	}
+	dir := filepath.Join(cache, "pgp-mfa")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create challenge directory: %w", err)
+	}
+	return dir, nil
+}
+
+// createChallengeFile creates a new file only readable by the current user
+// in dir, the default challenge directory when empty
+func createChallengeFile(dir string) (*os.File, error) {
+	if dir == "" {
+		var err error
+		if dir, err = challengeDir(); err != nil {
+			return nil, err
+		}
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to name challenge file: %w", err)
+	}
+	path := filepath.Join(dir, challengeFilePrefix+hex.EncodeToString(suffix))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create challenge file: %w", err)
+	}
+	return file, nil
+}
+
+// decryptChallenge recovers the solution of an encrypted challenge with the
+// recipient's private key, what the user does with gpg -d
+func decryptChallenge(encrypted []byte, key *crypto.Key) ([]byte, error) {
+	decHandle, err := crypto.PGP().Decryption().DecryptionKey(key).New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decryption context: %w", err)
+	}
+	decrypted, err := decHandle.Decrypt(encrypted, crypto.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt challenge: %w", err)
+	}
+	return decrypted.Bytes(), nil
+}
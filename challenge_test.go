@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	mathrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// TestConcurrentIssuance issues challenges with different settings from many
+// goroutines, each with its own copy of the key as if freshly loaded from the
+// store, run it with -race to catch shared state
+func TestConcurrentIssuance(t *testing.T) {
+	now := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := range 32 {
+		key, err := ecKey.Copy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg := defaultChallengeConfig(16 << (i % 3))
+			cfg.SolveTime = time.Duration(i+1) * time.Second
+			cfg.Charset = "ab"[:1+i%2]
+			issued, err := issueChallenge(key, cfg, now)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !issued.ExpiresAt.Equal(now.Add(cfg.SolveTime)) {
+				errs <- errors.New("expiry not taken from the config")
+			}
+			if len(issued.Solution) != cfg.Length {
+				errs <- errors.New("length not taken from the config")
+			}
+			for _, c := range issued.Solution {
+				if c != 'a' && (c != 'b' || cfg.Charset != "ab") {
+					errs <- errors.New("charset not taken from the config")
+					break
+				}
+			}
+			decHandle, err := crypto.PGP().Decryption().DecryptionKey(key).New()
+			if err != nil {
+				errs <- err
+				return
+			}
+			decrypted, err := decHandle.Decrypt(issued.Encrypted, crypto.Bytes)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(decrypted.Bytes()) != string(issued.Solution) {
+				errs <- errors.New("decrypted challenge differs from its solution")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestChallengeConfigValidate(t *testing.T) {
+	for length, expected := range map[int]error{16: nil, 512: nil, 0: ErrChallengeLength, 1024: ErrChallengeLength, 24: ErrChallengePow} {
+		err := defaultChallengeConfig(length).validate()
+		if !errors.Is(err, expected) || (expected != nil && !errors.Is(err, ErrPolicy)) {
+			t.Errorf("length %d: got %v, expected %v", length, err, expected)
+		}
+	}
+
+	// Policy bounds layered on the hard range
+	for length, expected := range map[int]error{16: ErrLengthPolicy, 32: nil, 64: nil, 128: ErrLengthPolicy, 1024: ErrChallengeLength} {
+		cfg := defaultChallengeConfig(length)
+		cfg.MinLength, cfg.MaxLength = 32, 64
+		if err := cfg.validate(); !errors.Is(err, expected) {
+			t.Errorf("policy 32-64, length %d: got %v, expected %v", length, err, expected)
+		}
+	}
+	for _, bounds := range [][2]int{{0, 64}, {32, 1024}, {64, 32}} {
+		if err := validateLengthPolicy(bounds[0], bounds[1]); !errors.Is(err, ErrParse) {
+			t.Errorf("policy %v: got %v, expected a parse error", bounds, err)
+		}
+	}
+}
+
+func BenchmarkConcurrentIssuance(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		key, err := ecKey.Copy()
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		cfg := defaultChallengeConfig(32)
+		for pb.Next() {
+			if _, err := issueChallenge(key, cfg, time.Now()); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestEncryptorCache(t *testing.T) {
+	cache := newEncryptorCache()
+	for range 2 {
+		encrypted, _, err := cache.encrypt(ecKey, []byte("challenge"), nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := decryptChallenge(encrypted, ecKey)
+		if err != nil || string(decrypted) != "challenge" {
+			t.Errorf("got %q, %v", decrypted, err)
+		}
+	}
+	if _, _, err := cache.encrypt(rsa3072Key, []byte("challenge"), nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.handles) != 2 {
+		t.Errorf("got %d cached handles, expected one per key", len(cache.handles))
+	}
+
+	// A key given a new subkey, e.g. by a refresh, is no longer encrypted to
+	// its old one
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEd25519}
+	entity, err := openpgp.NewEntity("Test User", "", "test@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		// Parsed anew like stored keys, not sharing the entity
+		var buf bytes.Buffer
+		if err := entity.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		key, err := crypto.NewKey(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, _, err := cache.encrypt(key, []byte("challenge"), nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		newest := entity.Subkeys[len(entity.Subkeys)-1].PublicKey.KeyId
+		if ids, _ := crypto.NewPGPMessage(encrypted).EncryptionKeyIDs(); len(ids) != 1 || ids[0] != newest {
+			t.Errorf("expected message encrypted to %x, got %x", newest, ids)
+		}
+		if err := entity.AddEncryptionSubkey(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cache.handles) != 3 {
+		t.Errorf("got %d cached handles, expected the changed key's to be replaced", len(cache.handles))
+	}
+}
+
+// Issuing to the same rsa key, with the encryption handle rebuilt for every
+// challenge or reused
+func BenchmarkIssuanceHandleRebuilt(b *testing.B) {
+	cfg := defaultChallengeConfig(32)
+	for i := 0; i < b.N; i++ {
+		if _, err := issueChallenge(rsa3072Key, cfg, time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIssuanceHandleCached(b *testing.B) {
+	cfg := defaultChallengeConfig(32)
+	cfg.Encryptors = newEncryptorCache()
+	for i := 0; i < b.N; i++ {
+		if _, err := issueChallenge(rsa3072Key, cfg, time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCreateChallengeFile(t *testing.T) {
+	dir := t.TempDir()
+	file, err := createChallengeFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if filepath.Dir(file.Name()) != dir || !strings.HasPrefix(filepath.Base(file.Name()), challengeFilePrefix) {
+		t.Errorf("unexpected challenge file path %s", file.Name())
+	}
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("got mode %v, expected 0600", info.Mode().Perm())
+	}
+
+	// The default directory is private too
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	file, err = createChallengeFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err = os.Stat(filepath.Dir(file.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Errorf("got directory mode %v, expected 0700", info.Mode().Perm())
+	}
+}
+
+func TestSolveHint(t *testing.T) {
+	if got := solveHint(defaultSolveHint, "/run/c"); got != "gpg -dq --batch < /run/c" {
+		t.Errorf("default hint: got %q", got)
+	}
+	if got := solveHint("gpg2 --homedir ~/.gnupg-mfa -d {file}", "/run/c"); got != "gpg2 --homedir ~/.gnupg-mfa -d /run/c" {
+		t.Errorf("custom hint: got %q", got)
+	}
+}
+
+func TestWrapChallenge(t *testing.T) {
+	if got := string(wrapChallenge([]byte("a{b}c"), "PGPMFA")); got != "PGPMFA{a{b}c}" {
+		t.Errorf("got %q", got)
+	}
+	if got := string(wrapChallenge([]byte("abc"), "")); got != "abc" {
+		t.Errorf("got %q without prefix", got)
+	}
+	for solution, expected := range map[string]string{
+		"PGPMFA{a{b}c}": "a{b}c",
+		"a{b}c":         "a{b}c",
+		"PGPMFA{abc":    "PGPMFA{abc",
+		"OTHER{abc}":    "OTHER{abc}",
+	} {
+		if got := unwrapSolution(solution, "PGPMFA"); got != expected {
+			t.Errorf("%q: got %q, expected %q", solution, got, expected)
+		}
+	}
+}
+
+func TestStructuredChallenge(t *testing.T) {
+	now := time.Now()
+	cfg := defaultChallengeConfig(32)
+	cfg.Structured = true
+	issued, err := issueChallenge(ecKey, cfg, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := decryptChallenge(issued.Encrypted, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var structured structuredChallenge
+	if err := json.Unmarshal(plaintext, &structured); err != nil {
+		t.Fatalf("plaintext %q is not a structured challenge: %v", plaintext, err)
+	}
+	if structured.Nonce != string(issued.Solution) || structured.ID != issued.ID || !structured.Exp.Equal(issued.ExpiresAt) {
+		t.Errorf("got %+v, expected nonce %q, id %s, exp %s", structured, issued.Solution, issued.ID, issued.ExpiresAt)
+	}
+
+	// The solver answers with the nonce or the whole object
+	for _, input := range []string{string(issued.Solution), string(plaintext)} {
+		err := solveChallenge(strings.NewReader(input+"\n"), io.Discard, false, issued.Solution, nil, issued.ExpiresAt, cfg, nil, nil, nil)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+		}
+	}
+	forged, _ := json.Marshal(structuredChallenge{Nonce: "forged", Exp: structured.Exp, ID: structured.ID})
+	if err := solveChallenge(bytes.NewReader(append(forged, '\n')), io.Discard, false, issued.Solution, nil, issued.ExpiresAt, cfg, nil, nil, nil); !errors.Is(err, ErrIncorrectSolution) {
+		t.Errorf("forged nonce: got %v, expected %v", err, ErrIncorrectSolution)
+	}
+	// A plain challenge takes the solution as typed, not a json object
+	if err := solveChallenge(strings.NewReader(string(plaintext)+"\n"), io.Discard, false, issued.Solution, nil, issued.ExpiresAt, ChallengeConfig{}, nil, nil, nil); !errors.Is(err, ErrIncorrectSolution) {
+		t.Errorf("structured solution to a plain challenge: got %v, expected %v", err, ErrIncorrectSolution)
+	}
+
+	cfg.Wrap = "PGPMFA"
+	if err := cfg.validate(); !errors.Is(err, ErrParse) {
+		t.Errorf("wrapped structured challenge: got %v, expected a parse error", err)
+	}
+}
+
+func TestPasswordLayer(t *testing.T) {
+	cfg := defaultChallengeConfig(16)
+	cfg.Password = []byte("shared secret")
+	issued, err := issueChallenge(ecKey, cfg, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The key alone only reveals the password encrypted challenge
+	inner, err := decryptChallenge(issued.Encrypted, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(inner), string(issued.Solution)) {
+		t.Fatal("challenge readable without the password")
+	}
+	decrypt := func(password string) ([]byte, error) {
+		handle, err := crypto.PGP().Decryption().Password([]byte(password)).New()
+		if err != nil {
+			return nil, err
+		}
+		decrypted, err := handle.Decrypt(inner, crypto.Armor)
+		if err != nil {
+			return nil, err
+		}
+		return decrypted.Bytes(), nil
+	}
+	if _, err := decrypt("wrong"); err == nil {
+		t.Error("inner layer decrypted with a wrong password")
+	}
+	solution, err := decrypt("shared secret")
+	if err != nil || string(solution) != string(issued.Solution) {
+		t.Errorf("got %q, %v, expected %q", solution, err, issued.Solution)
+	}
+	// Nor does the password alone open the outer layer
+	handle, err := crypto.PGP().Decryption().Password([]byte("shared secret")).New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handle.Decrypt(issued.Encrypted, crypto.Bytes); err == nil {
+		t.Error("outer layer decrypted with the password")
+	}
+}
+
+func TestDeterministicChallenge(t *testing.T) {
+	issue := func() []byte {
+		cfg := defaultChallengeConfig(32)
+		cfg.Rand = mathrand.NewChaCha8([32]byte{1})
+		issued, err := issueChallenge(ecKey, cfg, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return issued.Solution
+	}
+	if first, second := issue(), issue(); string(first) != string(second) {
+		t.Errorf("same seed, different challenges: %q, %q", first, second)
+	}
+	// The default source is crypto/rand
+	if challengeRand != rand.Reader {
+		t.Error("challenges are not drawn from crypto/rand")
+	}
+}
+
+func TestSlowEncryption(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      *crypto.Key
+		length   int
+		expected bool
+	}{
+		{"rsa4096 large", rsa4092Key, 512, true},
+		{"rsa4096 short", rsa4092Key, 16, false},
+		{"rsa3072 large", rsa3072Key, 512, false},
+		{"ed25519 large", ecKey, 512, false},
+	}
+	for _, tt := range tests {
+		if got := slowEncryption(tt.key, tt.length); got != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestCheckBinaryOutput(t *testing.T) {
+	if err := checkBinaryOutput(true, false); !errors.Is(err, ErrBinaryTerminal) {
+		t.Errorf("terminal: got %v, expected %v", err, ErrBinaryTerminal)
+	}
+	if err := checkBinaryOutput(true, true); err != nil {
+		t.Errorf("forced: %v", err)
+	}
+	if err := checkBinaryOutput(false, false); err != nil {
+		t.Errorf("redirected: %v", err)
+	}
+}
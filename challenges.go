@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Challenge statuses, as listed by challenges list
+const (
+	challengePending = "pending"
+	challengeSolved  = "solved"
+	challengeExpired = "expired"
+)
+
+// challengeRecord is an issued challenge as kept in the challenges table,
+// which never holds solutions
+type challengeRecord struct {
+	ID          string
+	Fingerprint string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+	SolvedAt    sql.NullTime
+}
+
+func (c challengeRecord) status(now time.Time) string {
+	switch {
+	case c.SolvedAt.Valid:
+		return challengeSolved
+	case c.ExpiresAt.Before(now):
+		return challengeExpired
+	default:
+		return challengePending
+	}
+}
+
+var ErrChallengeSuperseded = errors.New("challenge was superseded by newer ones of the key")
+
+// recordChallenge keeps track of an issued challenge for fingerprint, read-only
+// databases still issue challenges without tracking them. With a keep above
+// 0, only the keep most recent unsolved challenges of the key are kept, the
+// older ones can no longer be solved.
+func recordChallenge(fingerprint string, issued *IssuedChallenge, keep int) error {
+	if readOnlyDB != "" {
+		return nil
+	}
+	id := fingerprintID(fingerprint)
+	tx, err := db.BeginTx(commandCtx, nil)
+	if err != nil {
+		return dbError("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO challenges (tenant, id, fingerprint, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		activeTenant,
+		issued.ID,
+		id,
+		issued.IssuedAt.UTC(),
+		issued.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return dbError("failed to record challenge: %w", err)
+	}
+	if keep > 0 {
+		res, err := tx.Exec(`DELETE FROM challenges WHERE tenant = ? AND fingerprint = ? AND solved_at IS NULL AND id NOT IN (
+			SELECT id FROM challenges WHERE tenant = ? AND fingerprint = ? AND solved_at IS NULL ORDER BY issued_at DESC, rowid DESC LIMIT ?
+		)`, activeTenant, id, activeTenant, id, keep)
+		if err != nil {
+			return dbError("failed to prune challenges: %w", err)
+		}
+		if pruned, _ := res.RowsAffected(); pruned > 0 {
+			slog.Debug("superseded challenges pruned", "event", "challenges_pruned", "fingerprint", fingerprint, "count", pruned, "keep", keep)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return dbError("failed to commit challenge: %w", err)
+	}
+	return nil
+}
+
+// markChallengeSolved records the time a challenge was solved at, it fails
+// with ErrChallengeSuperseded when the challenge was pruned meanwhile
+func markChallengeSolved(id string, now time.Time) error {
+	if readOnlyDB != "" {
+		return nil
+	}
+	res, err := db.Exec(`UPDATE challenges SET solved_at = ? WHERE tenant = ? AND id = ? AND solved_at IS NULL`, now.UTC(), activeTenant, id)
+	if err != nil {
+		return dbError("failed to record solved challenge: %w", err)
+	}
+	if updated, err := res.RowsAffected(); err == nil && updated == 0 {
+		return policyError("%w: %s", ErrChallengeSuperseded, id)
+	}
+	return nil
+}
+
+// listChallenges returns the challenges issued for fingerprint, newest
+// first, only the pending ones unless all is set
+func listChallenges(fingerprint string, all bool, now time.Time) ([]challengeRecord, error) {
+	query := `SELECT id, fingerprint, issued_at, expires_at, solved_at FROM challenges WHERE tenant = ? AND fingerprint IN (?, ?)`
+	args := activeTenantIDs(normalizeFingerprint(fingerprint))
+	if !all {
+		query += ` AND solved_at IS NULL AND expires_at >= ?`
+		args = append(args, now.UTC())
+	}
+	rows, err := db.Query(query+` ORDER BY issued_at DESC`, args...)
+	if err != nil {
+		return nil, dbError("failed to query challenges: %w", err)
+	}
+	defer rows.Close()
+	var records []challengeRecord
+	for rows.Next() {
+		var c challengeRecord
+		if err := rows.Scan(&c.ID, &c.Fingerprint, &c.IssuedAt, &c.ExpiresAt, &c.SolvedAt); err != nil {
+			return nil, dbError("failed to scan challenge: %w", err)
+		}
+		records = append(records, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("failed to iterate challenges: %w", err)
+	}
+	return records, nil
+}
+
+func challenges(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: pgp-mfa challenges list [--all] [--output-format table|csv|json] [--json] <fingerprint>")
+	}
+	switch args[0] {
+	case "list":
+		return challengesList(args[1:])
+	default:
+		return fmt.Errorf("unknown challenges command '%s'", args[0])
+	}
+}
+
+// challengesList shows the pending challenges of a key, for operators to
+// see the outstanding MFA prompts
+func challengesList(args []string) error {
+	fs := flag.NewFlagSet("challenges list", flag.ContinueOnError)
+	all := fs.Bool("all", false, "also list solved and expired challenges")
+	format := fs.String("output-format", "", "table, csv or json")
+	asJSON := fs.Bool("json", false, "shorthand for --output-format json")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa challenges list [--all] [--output-format table|csv|json] [--json] <fingerprint>")
+	}
+	if *asJSON {
+		*format = formatJSON
+	}
+	f, err := outputFormat(*format)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	records, err := listChallenges(args[0], *all, now)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(records))
+	for _, c := range records {
+		solvedAt := ""
+		if c.SolvedAt.Valid {
+			solvedAt = c.SolvedAt.Time.Local().Format(time.RFC3339)
+		}
+		rows = append(rows, []string{c.ID, c.status(now), c.IssuedAt.Local().Format(time.RFC3339), c.ExpiresAt.Local().Format(time.RFC3339), solvedAt})
+	}
+	return writeRecords(os.Stdout, f, []string{"id", "status", "issued_at", "expires_at", "solved_at"}, rows)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListChallenges(t *testing.T) {
+	useTestDB(t)
+	fingerprint := ecKey.GetFingerprint()
+	now := time.Now()
+	issue := func(issuedAt time.Time) *IssuedChallenge {
+		issued, err := issueChallenge(ecKey, defaultChallengeConfig(16), issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recordChallenge(fingerprint, issued, 0); err != nil {
+			t.Fatal(err)
+		}
+		return issued
+	}
+	expired := issue(now.Add(-2 * ChallengeSolveTime))
+	solved := issue(now.Add(-time.Second))
+	if err := markChallengeSolved(solved.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	pending := issue(now)
+
+	statuses := func(all bool) map[string]string {
+		records, err := listChallenges(fingerprint, all, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, c := range records {
+			got[c.ID] = c.status(now)
+		}
+		return got
+	}
+	if got := statuses(false); len(got) != 1 || got[pending.ID] != challengePending {
+		t.Errorf("outstanding challenges: got %v, expected only %s", got, pending.ID)
+	}
+	got := statuses(true)
+	for id, expected := range map[string]string{pending.ID: challengePending, solved.ID: challengeSolved, expired.ID: challengeExpired} {
+		if got[id] != expected {
+			t.Errorf("%s: got status %q, expected %q", id, got[id], expected)
+		}
+	}
+	if records, err := listChallenges(rsa3072Key.GetFingerprint(), true, now); err != nil || len(records) != 0 {
+		t.Errorf("other key: got %v (%v), expected no challenge", records, err)
+	}
+	for _, args := range [][]string{{"list", "--json", fingerprint}, {"list", "--all", "--output-format", "csv", fingerprint}} {
+		if err := challenges(args); err != nil {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+	if err := challenges([]string{"list"}); err == nil {
+		t.Error("expected a usage error without fingerprint")
+	}
+}
+
+func TestChallengeRecorded(t *testing.T) {
+	useTestDB(t)
+	fingerprint := ecKey.GetFingerprint()
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", writeKeyFile(t, ecKey, true), "16", fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := listChallenges(fingerprint, true, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].status(time.Now()) != challengeSolved {
+		t.Errorf("got %+v, expected one solved challenge", records)
+	}
+}
+
+func TestKeepChallenges(t *testing.T) {
+	useTestDB(t)
+	fingerprint := ecKey.GetFingerprint()
+	now := time.Now()
+	var issued []*IssuedChallenge
+	for i := range 4 {
+		c, err := issueChallenge(ecKey, defaultChallengeConfig(16), now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recordChallenge(fingerprint, c, 0); err != nil {
+			t.Fatal(err)
+		}
+		issued = append(issued, c)
+	}
+	if err := markChallengeSolved(issued[0].ID, now); err != nil {
+		t.Fatal(err)
+	}
+	// Solved challenges are not pruned, nor counted
+	c, err := issueChallenge(ecKey, defaultChallengeConfig(16), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordChallenge(fingerprint, c, 2); err != nil {
+		t.Fatal(err)
+	}
+	records, err := listChallenges(fingerprint, true, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+	if expected := []string{c.ID, issued[3].ID, issued[0].ID}; !slices.Equal(ids, expected) {
+		t.Errorf("got %v, expected %v", ids, expected)
+	}
+	if err := markChallengeSolved(issued[1].ID, now); !errors.Is(err, ErrChallengeSuperseded) {
+		t.Errorf("pruned challenge: got %v, expected %v", err, ErrChallengeSuperseded)
+	}
+}
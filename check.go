@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+var (
+	ErrKeySelfSig     = errors.New("key has no valid self-signature, it is corrupt or was tampered with")
+	ErrKeySelfRevoked = errors.New("key has been revoked by its owner")
+	ErrKeyNoEncrypt   = errors.New("key has no valid encryption key, challenges cannot be encrypted to it")
+	ErrCheckFailed    = errors.New("key checks failed")
+)
+
+// keyCheck is a named validation of a key
+type keyCheck struct {
+	name  string
+	check func(key *crypto.Key, now time.Time) error
+}
+
+// importChecks are the checks import enforces, see validateKey
+var importChecks = []keyCheck{
+	{"public", func(key *crypto.Key, _ time.Time) error {
+		if key.IsPrivate() {
+			return policyError("%w", ErrKeyPriv)
+		}
+		return nil
+	}},
+	// Before the expiry check, which a key without valid self-signature fails
+	// too, misleadingly
+	{"self-signature", func(key *crypto.Key, _ time.Time) error {
+		if _, err := key.GetEntity().PrimarySelfSignature(time.Time{}, &packet.Config{}); err != nil {
+			return policyError("%w: %w", ErrKeySelfSig, err)
+		}
+		return nil
+	}},
+	{"not expired", func(key *crypto.Key, now time.Time) error {
+		if key.IsExpired(now.Unix()) {
+			return policyError("%w", ErrKeyExp)
+		}
+		return nil
+	}},
+}
+
+// challengeChecks are the checks a key fails later, when challenged
+var challengeChecks = []keyCheck{
+	{"not revoked", func(key *crypto.Key, now time.Time) error {
+		if key.IsRevoked(now.Unix()) {
+			return policyError("%w", ErrKeySelfRevoked)
+		}
+		return nil
+	}},
+	{"can encrypt", func(key *crypto.Key, now time.Time) error {
+		if !key.CanEncrypt(now.Unix()) {
+			return policyError("%w", ErrKeyNoEncrypt)
+		}
+		return nil
+	}},
+}
+
+// checkKeyFile runs the import and challenge checks on the key in path and
+// returns one report row per check: check, result and reason. The checks
+// stop at the first one when the key cannot be read.
+func checkKeyFile(path string, publicOnly bool, curvePolicy string, revokerPaths []string) [][]string {
+	row := func(name string, err error) []string {
+		if err != nil {
+			return []string{path, name, "fail", err.Error()}
+		}
+		return []string{path, name, "pass", ""}
+	}
+	keyFile, err := openKey(path)
+	if err != nil {
+		return [][]string{row("parse", fmt.Errorf("%w: %w", ErrOpenFailed, err))}
+	}
+	key, err := parseKey(keyFile)
+	keyFile.Close()
+	if err != nil {
+		return [][]string{row("parse", err)}
+	}
+	rows := [][]string{row("parse", nil)}
+	if key.IsPrivate() && publicOnly {
+		if key, err = key.ToPublic(); err != nil {
+			return append(rows, row("public", parseError("%w: %w", ErrPubKeyFail, err)))
+		}
+	}
+	now := time.Now()
+	for _, c := range slices.Concat(importChecks, challengeChecks) {
+		rows = append(rows, row(c.name, c.check(key, now)))
+	}
+	revokers, err := revokerCandidates(store, key, revokerPaths)
+	if err == nil && designatedRevocation(key, revokers) {
+		err = policyError("%w", ErrKeyRevoked)
+	}
+	rows = append(rows, row("designated revoker", err))
+	return append(rows, row("nist curves", checkCurvePolicy(key, curvePolicy)))
+}
+
+// checkKeys vets key files as import would, without storing them, and
+// reports every check. It fails when any check does, for CI.
+func checkKeys(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	publicOnly := fs.Bool("public-only", false, "check the public half of private keys")
+	nistPolicy := fs.String("nist-curves", curvePolicyWarn, "keys using NIST P-curves: allow, warn or reject")
+	format := fs.String("output-format", "", "table, csv or json")
+	var revokerPaths []string
+	fs.Func("revoker", "public key file of a designated revoker, to check its revocations (repeatable)", func(path string) error {
+		revokerPaths = append(revokerPaths, path)
+		return nil
+	})
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 {
+		return errors.New("usage: pgp-mfa check [--public-only] [--nist-curves <policy>] [--revoker <file>]... [--output-format table|csv|json] <key-file>...")
+	}
+	curvePolicy, err := parseCurvePolicy(*nistPolicy)
+	if err != nil {
+		return err
+	}
+	f, err := outputFormat(*format)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	failed := 0
+	for _, path := range args {
+		for _, row := range checkKeyFile(path, *publicOnly, curvePolicy, revokerPaths) {
+			if row[2] == "fail" {
+				failed++
+			}
+			rows = append(rows, row)
+		}
+	}
+	if err := writeRecords(os.Stdout, f, []string{"file", "check", "result", "reason"}, rows); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return policyError("%w: %d failed", ErrCheckFailed, failed)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckKeys(t *testing.T) {
+	useTestDB(t)
+	results := func(rows [][]string) map[string]string {
+		checks := make(map[string]string)
+		for _, row := range rows {
+			checks[row[1]] = row[2]
+		}
+		return checks
+	}
+	checks := results(checkKeyFile(writeKeyFile(t, ecKey, false), false, curvePolicyReject, nil))
+	for _, name := range []string{"parse", "public", "self-signature", "not expired", "not revoked", "can encrypt", "designated revoker", "nist curves"} {
+		if checks[name] != "pass" {
+			t.Errorf("%s: got %q, expected pass", name, checks[name])
+		}
+	}
+
+	privFile := writeKeyFile(t, ecKey, true)
+	if checks := results(checkKeyFile(privFile, false, curvePolicyWarn, nil)); checks["public"] != "fail" {
+		t.Errorf("private key: got %q, expected fail", checks["public"])
+	}
+	if checks := results(checkKeyFile(privFile, true, curvePolicyWarn, nil)); checks["public"] != "pass" {
+		t.Errorf("public half of a private key: got %q, expected pass", checks["public"])
+	}
+	if checks := results(checkKeyFile(writeKeyFile(t, expiredKey(t), false), false, curvePolicyWarn, nil)); checks["not expired"] != "fail" || checks["can encrypt"] != "fail" {
+		t.Errorf("expired key: got %v", checks)
+	}
+
+	if err := checkKeys([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Errorf("valid key: %v", err)
+	}
+	if err := checkKeys([]string{writeKeyFile(t, ecKey, false), privFile}); !errors.Is(err, ErrCheckFailed) {
+		t.Errorf("got %v, expected %v", err, ErrCheckFailed)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("checked key was stored: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrChecksum = errors.New("checksum mismatch, the solution was mistyped")
+
+// luhnCheck returns the Luhn mod N check character of s over charset, N
+// being the charset size. With an even N it catches any single mistyped
+// character, and most swaps of adjacent ones. ok is false when s has
+// characters outside charset.
+func luhnCheck(s, charset string) (check byte, ok bool) {
+	n := len(charset)
+	sum, factor := 0, 2
+	for i := len(s) - 1; i >= 0; i-- {
+		index := strings.IndexByte(charset, s[i])
+		if index < 0 {
+			return 0, false
+		}
+		addend := factor * index
+		addend = addend/n + addend%n
+		sum += addend
+		factor = 3 - factor
+	}
+	return charset[(n-sum%n)%n], true
+}
+
+// appendChecksum appends the check character of a challenge, computed over
+// the charset solutions are compared in
+func (c ChallengeConfig) appendChecksum(challenge []byte) []byte {
+	if !c.Checksum {
+		return challenge
+	}
+	charset := c.solutionCharset()
+	folded := string(challenge)
+	if c.CaseInsensitive {
+		folded = strings.ToLower(folded)
+	}
+	check, _ := luhnCheck(folded, charset)
+	return append(challenge[:len(challenge):len(challenge)], check)
+}
+
+// stripChecksum verifies and removes the check character of a solution, a
+// mismatch means it was mistyped rather than wrong
+func (c ChallengeConfig) stripChecksum(solution string) (string, error) {
+	if !c.Checksum {
+		return solution, nil
+	}
+	if len(solution) < 2 {
+		return "", ErrChecksum
+	}
+	folded := solution
+	if c.CaseInsensitive {
+		folded = strings.ToLower(folded)
+	}
+	payload := folded[:len(folded)-1]
+	if check, ok := luhnCheck(payload, c.solutionCharset()); !ok || check != folded[len(folded)-1] {
+		return "", ErrChecksum
+	}
+	return solution[:len(solution)-1], nil
+}
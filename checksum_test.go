@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLuhnCheck(t *testing.T) {
+	// Luhn mod 10 over digits is the credit card checksum
+	if check, ok := luhnCheck("7992739871", "0123456789"); !ok || check != '3' {
+		t.Errorf("got %q (%v), expected '3'", check, ok)
+	}
+	if _, ok := luhnCheck("12a", "0123456789"); ok {
+		t.Error("expected characters outside the charset to fail")
+	}
+
+	cfg := ChallengeConfig{Charset: challengeCharset, Checksum: true}
+	challenge := []byte("abcdEFGH0123-_+/")
+	solution := string(cfg.appendChecksum(challenge))
+	if len(solution) != len(challenge)+1 || string(challenge) != "abcdEFGH0123-_+/" {
+		t.Fatalf("got %q, expected the challenge with one more character", solution)
+	}
+	if got, err := cfg.stripChecksum(solution); err != nil || got != string(challenge) {
+		t.Errorf("got %q (%v), expected %q", got, err, challenge)
+	}
+	// Every single substitution is caught, most adjacent swaps too
+	for i := 0; i < len(solution); i++ {
+		for j := 0; j < len(challengeCharset); j++ {
+			if challengeCharset[j] == solution[i] {
+				continue
+			}
+			typo := solution[:i] + challengeCharset[j:j+1] + solution[i+1:]
+			if _, err := cfg.stripChecksum(typo); !errors.Is(err, ErrChecksum) {
+				t.Fatalf("substitution %q not caught", typo)
+			}
+		}
+	}
+	swaps := 0
+	for i := 0; i+1 < len(solution); i++ {
+		if solution[i] == solution[i+1] {
+			continue
+		}
+		swapped := solution[:i] + solution[i+1:i+2] + solution[i:i+1] + solution[i+2:]
+		if _, err := cfg.stripChecksum(swapped); errors.Is(err, ErrChecksum) {
+			swaps++
+		}
+	}
+	if swaps == 0 {
+		t.Error("no adjacent swap caught")
+	}
+
+	// Case-insensitive checksums ignore the case of the whole solution
+	cfg.CaseInsensitive = true
+	folded := string(cfg.appendChecksum(challenge))
+	if _, err := cfg.stripChecksum(folded); err != nil {
+		t.Errorf("%q: %v", folded, err)
+	}
+	if _, err := cfg.stripChecksum("ABCDefgh0123-_+/" + folded[len(folded)-1:]); err != nil {
+		t.Errorf("other case rejected: %v", err)
+	}
+	if err := (ChallengeConfig{Length: 16, SolveTime: ChallengeSolveTime, Structured: true, Checksum: true}).validate(); err == nil {
+		t.Error("expected a structured challenge with a checksum to be invalid")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// staleChallengeAge is the default age past which leftover challenge files
+// are removed, far beyond the solve time
+const staleChallengeAge = time.Hour
+
+// staleChallengeFiles returns the challenge files of dir last modified
+// before cutoff
+func staleChallengeFiles(dir string, cutoff time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var stale []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), challengeFilePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		stale = append(stale, filepath.Join(dir, entry.Name()))
+	}
+	return stale, nil
+}
+
+// cleanupChallenges removes the challenge files left behind by interrupted
+// runs, from the challenge directory and the system temp directory where
+// older versions wrote them
+func cleanupChallenges(args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	olderThan := fs.Duration("older-than", staleChallengeAge, "only remove files older than this")
+	tmpDir := fs.String("tmpdir", "", "directory to clean instead of the default ones")
+	dryRun := fs.Bool("dry-run", false, "only list the files that would be removed")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa cleanup [--older-than <duration>] [--tmpdir <dir>] [--dry-run]")
+	}
+	dirs := []string{*tmpDir}
+	if *tmpDir == "" {
+		dir, err := challengeDir()
+		if err != nil {
+			return err
+		}
+		dirs = []string{dir}
+		if !slices.Contains(dirs, os.TempDir()) {
+			dirs = append(dirs, os.TempDir())
+		}
+	}
+	cutoff := time.Now().Add(-*olderThan)
+	var failures []error
+	for _, dir := range dirs {
+		stale, err := staleChallengeFiles(dir, cutoff)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		for _, path := range stale {
+			if *dryRun {
+				fmt.Println(path)
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				failures = append(failures, fmt.Errorf("failed to remove stale challenge file: %w", err))
+				continue
+			}
+			slog.Info("stale challenge file removed", "event", "cleanup", "path", path)
+		}
+	}
+	return errors.Join(failures...)
+}
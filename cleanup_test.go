@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanupChallenges(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, challengeFilePrefix+"stale")
+	fresh := filepath.Join(dir, challengeFilePrefix+"fresh")
+	other := filepath.Join(dir, "notes-stale")
+	for _, path := range []string{stale, fresh, other} {
+		if err := os.WriteFile(path, []byte("challenge"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleChallengeAge)
+	for _, path := range []string{stale, other} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cleanupChallenges([]string{"--tmpdir", dir, "--dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("dry run removed the stale file: %v", err)
+	}
+	if err := cleanupChallenges([]string{"--tmpdir", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stale challenge file kept: %v", err)
+	}
+	for _, path := range []string{fresh, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s removed: %v", path, err)
+		}
+	}
+	if err := cleanupChallenges([]string{"--tmpdir", dir, "--older-than", "0s"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fresh); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("--older-than 0s kept the fresh file: %v", err)
+	}
+}
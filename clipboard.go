@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var (
+	ErrNoClipboard = errors.New("no clipboard utility available")
+
+	// Utilities tried in order to write to the system clipboard
+	clipboardCopyCommands = [][]string{
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+		{"pbcopy"},
+		{"clip.exe"},
+	}
+
+	// Utilities tried in order to read the system clipboard
+	clipboardPasteCommands = [][]string{
+		{"wl-paste", "--no-newline"},
+		{"xclip", "-selection", "clipboard", "-out"},
+		{"xsel", "--clipboard", "--output"},
+		{"pbpaste"},
+		{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"},
+	}
+)
+
+// clipboardKeyPath stands for the clipboard among the key files to import
+const clipboardKeyPath = "(clipboard)"
+
+// copyToClipboard pipes data to the first clipboard utility that works
+func copyToClipboard(data string) error {
+	err := ErrNoClipboard
+	for _, args := range clipboardCopyCommands {
+		path, lookErr := exec.LookPath(args[0])
+		if lookErr != nil {
+			continue
+		}
+		cmd := exec.Command(path, args[1:]...)
+		cmd.Stdin = strings.NewReader(data)
+		if runErr := cmd.Run(); runErr != nil {
+			// e.g. wl-copy outside of a wayland session, try the next one
+			err = fmt.Errorf("%s: %w", args[0], runErr)
+			continue
+		}
+		return nil
+	}
+	return err
+}
+
+// readClipboard returns the content of the clipboard from the first
+// clipboard utility that works
+func readClipboard() (string, error) {
+	err := ErrNoClipboard
+	for _, args := range clipboardPasteCommands {
+		path, lookErr := exec.LookPath(args[0])
+		if lookErr != nil {
+			continue
+		}
+		out, runErr := exec.Command(path, args[1:]...).Output()
+		if runErr != nil {
+			// e.g. no display on a headless machine, try the next one
+			err = fmt.Errorf("%s: %w", args[0], runErr)
+			continue
+		}
+		return string(out), nil
+	}
+	return "", err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyToClipboardUnavailable(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if err := copyToClipboard("data"); !errors.Is(err, ErrNoClipboard) {
+		t.Errorf("got %v, expected %v", err, ErrNoClipboard)
+	}
+}
+
+func TestImportFromClipboard(t *testing.T) {
+	useTestDB(t)
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	if err := importKey([]string{"--from-clipboard"}); !errors.Is(err, ErrNoClipboard) {
+		t.Errorf("got %v, expected %v", err, ErrNoClipboard)
+	}
+
+	// Stand-in clipboard holding the armored key, shell builtins only
+	script := "#!/bin/sh\nwhile IFS= read -r line; do printf '%s\\n' \"$line\"; done < " + writeKeyFile(t, ecKey, false) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "wl-paste"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{"--from-clipboard"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); err != nil {
+		t.Errorf("clipboard key not imported: %v", err)
+	}
+	if err := importKey([]string{"--from-clipboard"}); !errors.Is(err, ErrAlreadyImported) {
+		t.Errorf("got %v, expected %v", err, ErrAlreadyImported)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// ANSI colors of warnings, errors and successes on a terminal
+const (
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorGreen  = "\033[32m"
+	colorReset  = "\033[0m"
+)
+
+// noColor disables colors, set by --no-color
+var noColor bool
+
+// colorAllowed tells whether colors were not disabled by --no-color or a
+// non-empty $NO_COLOR (https://no-color.org)
+func colorAllowed() bool {
+	return !noColor && os.Getenv("NO_COLOR") == ""
+}
+
+// useColor tells whether output to w gets colors, only terminals do
+func useColor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && colorAllowed() && term.IsTerminal(int(f.Fd()))
+}
+
+// colorize wraps s in color when w gets colors, s is returned as is otherwise
+func colorize(w io.Writer, color, s string) string {
+	if !useColor(w) {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// colorLogWriter colors the warning and error lines of the text logs, the log
+// package writes each record in a single call
+type colorLogWriter struct {
+	w io.Writer
+}
+
+func (c colorLogWriter) Write(p []byte) (int, error) {
+	color := ""
+	switch {
+	case bytes.Contains(p, []byte(" ERROR ")), bytes.Contains(p, []byte(" error: ")):
+		color = colorRed
+	case bytes.Contains(p, []byte(" WARN ")):
+		color = colorYellow
+	default:
+		return c.w.Write(p)
+	}
+	line, newline := bytes.CutSuffix(p, []byte("\n"))
+	out := append(append([]byte(color), line...), colorReset...)
+	if newline {
+		out = append(out, '\n')
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestColorLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(colorLogWriter{&buf}, "", log.LstdFlags)
+	for line, expected := range map[string]string{
+		"WARN challenge entropy is below the recommended minimum": colorYellow,
+		"ERROR failed":         colorRed,
+		"error: key not found": colorRed,
+		"INFO key imported":    "",
+	} {
+		buf.Reset()
+		logger.Print(line)
+		got := buf.String()
+		if expected == "" {
+			if bytes.Contains(buf.Bytes(), []byte("\033")) {
+				t.Errorf("%q: got %q, expected no color", line, got)
+			}
+			continue
+		}
+		if !bytes.HasPrefix(buf.Bytes(), []byte(expected)) || !bytes.HasSuffix(buf.Bytes(), []byte(colorReset+"\n")) {
+			t.Errorf("%q: got %q, expected it colored", line, got)
+		}
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	// Buffers and files are never colored, only terminals
+	file, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, w := range []io.Writer{&bytes.Buffer{}, file} {
+		if got := colorize(w, colorGreen, "ok"); got != "ok" {
+			t.Errorf("%T: got %q, expected plain", w, got)
+		}
+	}
+
+	t.Cleanup(func() { noColor = false })
+	t.Setenv("NO_COLOR", "")
+	if !colorAllowed() {
+		t.Error("colors disabled by default")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if colorAllowed() {
+		t.Error("colors allowed with NO_COLOR set")
+	}
+	t.Setenv("NO_COLOR", "")
+	noColor = true
+	if colorAllowed() {
+		t.Error("colors allowed with --no-color")
+	}
+}
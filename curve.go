@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// NIST curve policies, what import does with keys using NIST P-curves
+const (
+	curvePolicyAllow  = "allow"
+	curvePolicyWarn   = "warn"
+	curvePolicyReject = "reject"
+)
+
+var (
+	ErrCurvePolicy = errors.New("unknown NIST curve policy, expected allow, warn or reject")
+	ErrNISTCurve   = errors.New("key uses a NIST P-curve, prefer ed25519 / cv25519 keys")
+
+	nistCurves = []packet.Curve{packet.CurveNistP256, packet.CurveNistP384, packet.CurveNistP521}
+)
+
+func parseCurvePolicy(policy string) (string, error) {
+	switch policy {
+	case curvePolicyAllow, curvePolicyWarn, curvePolicyReject:
+		return policy, nil
+	}
+	return "", parseError("%w: '%s'", ErrCurvePolicy, policy)
+}
+
+// keyNISTCurves returns the NIST curves used by the primary key or subkeys
+func keyNISTCurves(key *crypto.Key) []string {
+	entity := key.GetEntity()
+	pubKeys := []*packet.PublicKey{entity.PrimaryKey}
+	for _, subkey := range entity.Subkeys {
+		pubKeys = append(pubKeys, subkey.PublicKey)
+	}
+	var curves []string
+	for _, pub := range pubKeys {
+		// only fails for non elliptic curve keys
+		curve, err := pub.Curve()
+		if err == nil && slices.Contains(nistCurves, curve) && !slices.Contains(curves, string(curve)) {
+			curves = append(curves, string(curve))
+		}
+	}
+	return curves
+}
+
+// checkCurvePolicy applies the NIST curve policy to a key being imported
+func checkCurvePolicy(key *crypto.Key, policy string) error {
+	curves := keyNISTCurves(key)
+	if len(curves) == 0 || policy == curvePolicyAllow {
+		return nil
+	}
+	if policy == curvePolicyReject {
+		return policyError("%w: %s", ErrNISTCurve, strings.Join(curves, ", "))
+	}
+	slog.Warn(ErrNISTCurve.Error(), "event", "import", "fingerprint", key.GetFingerprint(), "curves", strings.Join(curves, ","))
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+func TestCurvePolicy(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoECDSA, Curve: packet.CurveNistP256}
+	entity, err := openpgp.NewEntity("Test User", "", "test@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := crypto.NewKeyFromEntity(entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nistKey, err := privKey.ToPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if curves := keyNISTCurves(nistKey); !slices.Equal(curves, []string{"P256"}) {
+		t.Errorf("got curves %v, expected [P256]", curves)
+	}
+	for _, key := range []*crypto.Key{ecKey, rsa3072Key} {
+		if curves := keyNISTCurves(key); len(curves) != 0 {
+			t.Errorf("%s: got NIST curves %v", key.GetFingerprint(), curves)
+		}
+	}
+
+	if err := checkCurvePolicy(nistKey, curvePolicyReject); !errors.Is(err, ErrNISTCurve) {
+		t.Errorf("reject: got %v, expected %v", err, ErrNISTCurve)
+	}
+	for _, policy := range []string{curvePolicyWarn, curvePolicyAllow} {
+		if err := checkCurvePolicy(nistKey, policy); err != nil {
+			t.Errorf("%s: %v", policy, err)
+		}
+	}
+	if err := checkCurvePolicy(ecKey, curvePolicyReject); err != nil {
+		t.Errorf("ed25519 key rejected: %v", err)
+	}
+
+	useTestDB(t)
+	if err := importKey([]string{"--nist-curves", "reject", writeKeyFile(t, nistKey, false)}); !errors.Is(err, ErrNISTCurve) {
+		t.Errorf("import: got %v, expected %v", err, ErrNISTCurve)
+	}
+	if err := enroll([]string{"--nist-curves", "reject", writeKeyFile(t, nistKey, false)}); !errors.Is(err, ErrNISTCurve) {
+		t.Errorf("enroll: got %v, expected %v", err, ErrNISTCurve)
+	}
+	if err := importKey([]string{writeKeyFile(t, nistKey, false)}); err != nil {
+		t.Errorf("import with the default policy: %v", err)
+	}
+	if err := importKey([]string{"--nist-curves", "maybe", writeKeyFile(t, ecKey, false)}); !errors.Is(err, ErrCurvePolicy) {
+		t.Errorf("got %v, expected %v", err, ErrCurvePolicy)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var ErrDeadline = errors.New("command deadline exceeded")
+
+var (
+	// CommandDeadline bounds the run time of a command, 0 is no bound
+	CommandDeadline time.Duration
+
+	// commandCtx is cancelled once the command deadline passed, key
+	// downloads and database transactions are bound to it
+	commandCtx = context.Background()
+)
+
+// runWithDeadline runs fn and gives up on it once deadline passed, 0 waits
+// forever. Downloads and transactions of fn are cancelled through
+// commandCtx, a prompt cannot be: fn is left blocked on it and main exits
+// right after. The terminal is restored first as a passphrase prompt turns
+// echo off.
+func runWithDeadline(deadline time.Duration, fn func() error) error {
+	if deadline <= 0 {
+		return fn()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	prevCtx := commandCtx
+	commandCtx = ctx
+	fd := int(os.Stdin.Fd())
+	var state *term.State
+	if term.IsTerminal(fd) {
+		state, _ = term.GetState(fd)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		commandCtx = prevCtx
+		return err
+	case <-ctx.Done():
+		// commandCtx stays cancelled for fn, still running
+		if state != nil {
+			term.Restore(fd, state)
+		}
+		return fmt.Errorf("%w: still running after %s", ErrDeadline, deadline)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunWithDeadline(t *testing.T) {
+	t.Cleanup(func() { commandCtx = context.Background() })
+	boom := errors.New("boom")
+	if err := runWithDeadline(0, func() error { return boom }); !errors.Is(err, boom) {
+		t.Errorf("no deadline: got %v, expected %v", err, boom)
+	}
+	if err := runWithDeadline(time.Minute, func() error { return boom }); !errors.Is(err, boom) {
+		t.Errorf("in time: got %v, expected %v", err, boom)
+	}
+	// A command blocked on a prompt is given up on
+	block := make(chan struct{})
+	defer close(block)
+	start := time.Now()
+	err := runWithDeadline(50*time.Millisecond, func() error {
+		<-block
+		return nil
+	})
+	if !errors.Is(err, ErrDeadline) {
+		t.Errorf("blocked: got %v, expected %v", err, ErrDeadline)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %s", elapsed)
+	}
+}
+
+func TestDeadlineCancelsFetchAndTransaction(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+	prevClient := keyFetchClient
+	keyFetchClient = server.Client()
+	defer func() { keyFetchClient = prevClient }()
+	t.Cleanup(func() { commandCtx = context.Background() })
+	useTestDB(t)
+
+	fetched, took := make(chan error, 1), make(chan error, 1)
+	err := runWithDeadline(50*time.Millisecond, func() error {
+		_, err := fetchKey(server.URL + "/key.asc")
+		fetched <- err
+		took <- takeToken("abcd", 3, time.Minute, time.Now())
+		return nil
+	})
+	if !errors.Is(err, ErrDeadline) {
+		t.Errorf("got %v, expected %v", err, ErrDeadline)
+	}
+	select {
+	case err := <-fetched:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("fetch: got %v, expected %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch not cancelled by the deadline")
+	}
+	if err := <-took; !errors.Is(err, ErrDatabase) {
+		t.Errorf("transaction: got %v, expected %v", err, ErrDatabase)
+	}
+}
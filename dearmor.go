@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+// Key data formats, as detected by keyFormat
+const (
+	formatArmored = "armored"
+	formatBinary  = "binary"
+	formatUnknown = "unknown"
+)
+
+var (
+	ErrMalformedArmor   = errors.New("armor header found but the armored block is malformed or truncated")
+	ErrUnknownKeyFormat = errors.New("input is neither armored nor binary OpenPGP data")
+
+	armorBegin = regexp.MustCompile(`-----BEGIN PGP (PUBLIC|PRIVATE) KEY BLOCK-----`)
+	// email quoting ("> ", ">> ") and indentation in front of armor lines
+	armorQuote = regexp.MustCompile(`^[\s>]*`)
+)
+
+// keyFormat classifies key data by its first bytes, or by an armor header
+// anywhere in it as armored keys may come with surrounding text
+func keyFormat(data []byte) string {
+	switch {
+	case len(data) > 0 && data[0]&0x80 != 0: // the high bit starts any packet
+		return formatBinary
+	case armorBegin.Match(data):
+		return formatArmored
+	default:
+		return formatUnknown
+	}
+}
+
+// extractArmoredKey locates the armored key block in noisy input (text around
+// it, email quoting, trailing spaces) and returns it cleaned up. Binary input
+// and input without an armor header are returned as is.
+func extractArmoredKey(data []byte) []byte {
+	if keyFormat(data) == formatBinary {
+		return data
+	}
+	loc := armorBegin.FindSubmatchIndex(data)
+	if loc == nil {
+		return data
+	}
+	end := []byte("-----END PGP " + string(data[loc[2]:loc[3]]) + " KEY BLOCK-----")
+
+	var out bytes.Buffer
+	for _, line := range bytes.Split(data[loc[0]:], []byte("\n")) {
+		line = bytes.TrimRight(armorQuote.ReplaceAll(line, nil), " \t\r")
+		out.Write(line)
+		out.WriteByte('\n')
+		if bytes.Equal(line, end) {
+			break
+		}
+	}
+	return out.Bytes()
+}
+
+// armorHeaders returns the "Name: value" header lines of an armored key, in
+// order, e.g. "Comment: generated by ...". They are not part of the key.
+func armorHeaders(data []byte) []string {
+	if keyFormat(data) != formatArmored {
+		return nil
+	}
+	var headers []string
+	lines := bytes.Split(extractArmoredKey(data), []byte("\n"))
+	for _, line := range lines[1:] {
+		name, value, ok := bytes.Cut(line, []byte(": "))
+		if !ok || len(name) == 0 || bytes.ContainsAny(name, " \t") {
+			break
+		}
+		headers = append(headers, string(name)+": "+string(value))
+	}
+	return headers
+}
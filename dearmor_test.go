@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseNoisyArmoredKey(t *testing.T) {
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := ecKey.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted := "> " + strings.ReplaceAll(strings.TrimSpace(armored), "\n", "\n> ")
+
+	inputs := map[string]string{
+		"clean":            armored,
+		"leading garbage":  "Hi,\n\nhere is my key:\n\n" + armored,
+		"trailing garbage": armored + "\n\nCheers,\n-- \nAlice\n",
+		"quoted":           "On Monday Alice wrote:\n" + quoted + "\n> \n> Alice\n",
+		"double quoted":    ">> " + strings.ReplaceAll(strings.TrimSpace(armored), "\n", "\n>> "),
+		"crlf and spaces":  "key below  \r\n" + strings.ReplaceAll(armored, "\n", "   \r\n"),
+		"binary":           string(binary),
+	}
+	for name, input := range inputs {
+		key, err := parseKey(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if key.GetFingerprint() != ecKey.GetFingerprint() {
+			t.Errorf("%s: got %s, expected %s", name, key.GetFingerprint(), ecKey.GetFingerprint())
+		}
+	}
+
+	if _, err := parseKey(strings.NewReader("no key in here")); err == nil {
+		t.Error("expected an error without any key")
+	}
+}
+
+func TestKeyFormat(t *testing.T) {
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := ecKey.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for input, expected := range map[string]string{
+		armored:               formatArmored,
+		"my key:\n" + armored: formatArmored,
+		string(binary):        formatBinary,
+		"no key in here":      formatUnknown,
+		"":                    formatUnknown,
+	} {
+		if got := keyFormat([]byte(input)); got != expected {
+			t.Errorf("%.20q: got %s, expected %s", input, got, expected)
+		}
+	}
+
+	// Truncated armor gets a targeted error
+	truncated := armored[:len(armored)/2]
+	if _, err := parseKey(strings.NewReader(truncated)); !errors.Is(err, ErrMalformedArmor) || !errors.Is(err, ErrFailedRead) {
+		t.Errorf("truncated armor: got %v, expected %v", err, ErrMalformedArmor)
+	}
+	if _, err := parseKey(strings.NewReader("no key in here")); !errors.Is(err, ErrUnknownKeyFormat) {
+		t.Errorf("text: got %v, expected %v", err, ErrUnknownKeyFormat)
+	}
+	if _, err := parseKey(bytes.NewReader(binary[:len(binary)/2])); errors.Is(err, ErrMalformedArmor) || !errors.Is(err, ErrFailedRead) {
+		t.Errorf("truncated binary: got %v, expected a plain read error", err)
+	}
+}
+
+func TestArmorHeaders(t *testing.T) {
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withHeaders := strings.Replace(armored, "-----\n", "-----\nComment: generated by test\nComment: Alice's laptop\n", 1)
+	if _, err := parseKey(strings.NewReader(withHeaders)); err != nil {
+		t.Fatal(err)
+	}
+	got := armorHeaders([]byte("> " + strings.ReplaceAll(withHeaders, "\n", "\n> ")))
+	expected := []string{"Comment: generated by test", "Comment: Alice's laptop"}
+	if strings.Join(got[len(got)-2:], "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got %q, expected to end with %q", got, expected)
+	}
+	binary, _ := ecKey.GetPublicKey()
+	if got := armorHeaders(binary); got != nil {
+		t.Errorf("binary key: got %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var ErrDeliveryFailed = errors.New("challenge delivery failed")
+
+// deliverChallenge runs command through the shell with the armored challenge
+// on its stdin, its own output goes to stderr to keep stdout clean
+func deliverChallenge(command, armored string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = strings.NewReader(armored + "\n")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		slog.Warn("challenge delivery failed", "event", "challenge_delivery", "exit_status", exitErr.ExitCode())
+		return fmt.Errorf("%w: command exited with status %d", ErrDeliveryFailed, exitErr.ExitCode())
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDeliveryFailed, err)
+	}
+	slog.Info("challenge delivered", "event", "challenge_delivery", "exit_status", 0)
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDeliverChallenge(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a posix shell")
+	}
+	out := filepath.Join(t.TempDir(), "delivered")
+	if err := deliverChallenge("cat > "+out, "armored challenge"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "armored challenge" {
+		t.Errorf("delivered %q", data)
+	}
+
+	err = deliverChallenge("exit 3", "armored challenge")
+	if !errors.Is(err, ErrDeliveryFailed) || !strings.Contains(err.Error(), "status 3") {
+		t.Errorf("got %v, expected %v with exit status", err, ErrDeliveryFailed)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// enroll onboards a key: its details are shown for a trust on first use
+// confirmation, then a challenge must be solved with it. The key is only
+// stored once the challenge is solved, nothing is left behind otherwise.
+func enroll(args []string) error {
+	fs := flag.NewFlagSet("enroll", flag.ContinueOnError)
+	label := fs.String("label", "", "free text describing the key (owner, device...)")
+	trust := fs.String("trust", defaultTrust, "owner trust: none, unknown, marginal, full or ultimate")
+	card := fs.Bool("card", false, "the private key lives on an OpenPGP smartcard")
+	nistPolicy := fs.String("nist-curves", curvePolicyWarn, "keys using NIST P-curves: allow, warn or reject")
+	var revokerPaths []string
+	fs.Func("revoker", "public key file of a designated revoker, to check its revocations (repeatable)", func(path string) error {
+		revokerPaths = append(revokerPaths, path)
+		return nil
+	})
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa enroll [--label <text>] [--trust <level>] [--card] [--nist-curves <policy>] [--revoker <file>]... <key-file>")
+	}
+	if args[0] == "-" {
+		return errors.New("enroll reads the confirmation and the solution from stdin, it cannot read the key from it too")
+	}
+	if batchMode {
+		return policyError("%w: enroll", ErrInputRequired)
+	}
+	trustLevel, err := parseTrust(*trust)
+	if err != nil {
+		return err
+	}
+	curvePolicy, err := parseCurvePolicy(*nistPolicy)
+	if err != nil {
+		return err
+	}
+	key, _, err := readKeyFile(args[0], false, false)
+	if err != nil {
+		return err
+	}
+	fingerprint := key.GetFingerprint()
+	if _, err := store.Get(fingerprint); err == nil {
+		return policyError("%w: %s", ErrAlreadyImported, fingerprint)
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	revoked, err := vetImport(store, key, curvePolicy, revokerPaths)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("fingerprint:", fingerprint)
+	fmt.Println("user ids:", strings.Join(sortedUserIDs(key), "; "))
+	fmt.Println("created:", key.GetEntity().PrimaryKey.CreationTime.Format(time.RFC3339))
+	ok, err := confirm("check the fingerprint with the key owner, enroll this key?")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCancelled
+	}
+	if err := verifyDecrypt(key, stdin, os.Stdout, term.IsTerminal(int(os.Stdin.Fd()))); err != nil {
+		slog.Warn("enrollment challenge not solved, key not stored", "event", "enroll", "fingerprint", fingerprint)
+		return err
+	}
+	info := KeyInfo{CreatedAt: time.Now(), Label: *label, Card: *card, Trust: trustLevel, Revoked: revoked}
+	if err := store.Import(key, info); err != nil {
+		return err
+	}
+	slog.Info("key enrolled", "event", "enrolled", "fingerprint", fingerprint)
+	return nil
+}
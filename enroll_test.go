@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEnroll(t *testing.T) {
+	useTestDB(t)
+	// Zero bytes draw the first character of the charset
+	prevRand := challengeRand
+	t.Cleanup(func() { challengeRand = prevRand })
+	solution := strings.Repeat(challengeCharset[:1], 16)
+	keyFile := writeKeyFile(t, ecKey, false)
+
+	useTestStdin(t, "n\n")
+	if err := enroll([]string{keyFile}); !errors.Is(err, ErrCancelled) {
+		t.Errorf("declined: got %v, expected %v", err, ErrCancelled)
+	}
+	challengeRand = bytes.NewReader(make([]byte, 16))
+	useTestStdin(t, "y\nwrong\n")
+	if err := enroll([]string{keyFile}); !errors.Is(err, ErrDecryptCheck) {
+		t.Errorf("wrong solution: got %v, expected %v", err, ErrDecryptCheck)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("key stored without solving the challenge: %v", err)
+	}
+
+	challengeRand = bytes.NewReader(make([]byte, 16))
+	useTestStdin(t, "yes\n"+solution+"\n")
+	if err := enroll([]string{"--label", "alice", keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := store.Get(ecKey.GetFingerprint()); err != nil || stored.Label != "alice" {
+		t.Errorf("enrolled key: got %+v, %v", stored.KeyInfo, err)
+	}
+	if err := enroll([]string{keyFile}); !errors.Is(err, ErrAlreadyImported) {
+		t.Errorf("enrolled twice: got %v, expected %v", err, ErrAlreadyImported)
+	}
+}
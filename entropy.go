@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"strconv"
+)
+
+// MinChallengeEntropy is the default threshold, in bits, under which issuing
+// a challenge emits a warning
+var MinChallengeEntropy = 80.0
+
+// challengeEntropy returns the entropy in bits of a challenge of length
+// characters drawn from charset
+func challengeEntropy(length int, charset string) float64 {
+	return float64(length) * math.Log2(float64(len(charset)))
+}
+
+var (
+	ErrChallengeSize = errors.New("unknown challenge size, expected small, medium or large")
+	ErrEntropyTarget = errors.New("entropy target out of reach, the longest challenge is 512 characters")
+	ErrLengthFlags   = errors.New("give the challenge length either as an argument, with --size or with --bits")
+	ErrCharset       = errors.New("a charset needs at least 2 distinct characters and no duplicates")
+	ErrLengthUnit    = errors.New("unknown length unit, expected chars or bytes")
+
+	// challengeSizes are the named --size lengths
+	challengeSizes = map[string]int{
+		"small":  16,
+		"medium": 32,
+		"large":  64,
+	}
+)
+
+// Units of the challenge length argument: charset characters, or bytes of
+// entropy converted to the shortest length reaching them. A length of 16 is
+// 103.9 bits with the default charset but 16 bytes are 128 bits, so 32
+// characters.
+const (
+	unitChars = "chars"
+	unitBytes = "bytes"
+)
+
+// lengthForEntropy returns the shortest valid challenge length drawn from
+// charset with at least bits of entropy
+func lengthForEntropy(bits float64, charset string) (int, error) {
+	for length := 1; length <= 512; length *= 2 {
+		if challengeEntropy(length, charset) >= bits {
+			return length, nil
+		}
+	}
+	return 0, policyError("%w: %s bits", ErrEntropyTarget, strconv.FormatFloat(bits, 'f', -1, 64))
+}
+
+// isLengthArg reports whether arg is a challenge length rather than a key id,
+// a short number is a length, fingerprints and key ids are at least 8
+// characters long
+func isLengthArg(arg string) bool {
+	_, err := strconv.Atoi(arg)
+	return err == nil && len(arg) < 8
+}
+
+// challengeLength resolves the challenge length from the positional
+// arguments, a named size or an entropy target, and returns the remaining
+// arguments. Only one of the three may be used. The length is 0 when none
+// was given, leaving it to the default of the challenged key, an explicit 0
+// is refused as any invalid length. unit is the unit of the length argument.
+func challengeLength(args []string, size string, bits float64, unit, charset string) (int, []string, error) {
+	if unit != unitChars && unit != unitBytes {
+		return 0, nil, parseError("%w: '%s'", ErrLengthUnit, unit)
+	}
+	if size == "" && bits == 0 {
+		if len(args) == 0 || len(args) == 1 && !isLengthArg(args[0]) {
+			return 0, args, nil
+		}
+		length, _ := strconv.Atoi(args[0])
+		if length < 1 {
+			return 0, nil, policyError("%w", ErrChallengeLength)
+		}
+		if unit == unitBytes {
+			length, err := lengthForEntropy(float64(8*length), charset)
+			return length, args[1:], err
+		}
+		return length, args[1:], nil
+	}
+	if unit == unitBytes {
+		return 0, nil, parseError("%w, --unit bytes only applies to the length argument", ErrLengthFlags)
+	}
+	if size != "" && bits != 0 || len(args) > 1 {
+		return 0, nil, parseError("%w", ErrLengthFlags)
+	}
+	if len(args) == 1 && isLengthArg(args[0]) {
+		return 0, nil, parseError("%w", ErrLengthFlags)
+	}
+	if size != "" {
+		length, ok := challengeSizes[size]
+		if !ok {
+			return 0, nil, parseError("%w: '%s'", ErrChallengeSize, size)
+		}
+		return length, args, nil
+	}
+	length, err := lengthForEntropy(bits, charset)
+	return length, args, err
+}
+
+// validateCharset checks a charset gives challengeEntropy bits: challenges
+// are drawn byte by byte, so duplicates would only lower the real entropy
+func validateCharset(charset string) error {
+	seen := make(map[byte]bool, len(charset))
+	for i := 0; i < len(charset); i++ {
+		if seen[charset[i]] {
+			return parseError("%w: '%c' appears twice", ErrCharset, charset[i])
+		}
+		seen[charset[i]] = true
+	}
+	if len(seen) < 2 || len(seen) > 256 {
+		return parseError("%w", ErrCharset)
+	}
+	return nil
+}
+
+// foldCase returns the distinct characters of charset once lowercased, the
+// charset case-insensitive solutions are effectively drawn from
+func foldCase(charset string) string {
+	var folded []byte
+	for i := 0; i < len(charset); i++ {
+		c := charset[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if !bytes.ContainsRune(folded, rune(c)) {
+			folded = append(folded, c)
+		}
+	}
+	return string(folded)
+}
+
+// entropyRows lists the valid challenge lengths with their entropy in bits
+// and bytes for charset, and whether they reach MinChallengeEntropy
+func entropyRows(charset string) [][]string {
+	var rows [][]string
+	for length := 1; length <= 512; length *= 2 {
+		if length < MinChallengeLength || length > MaxChallengeLength {
+			continue
+		}
+		bits := challengeEntropy(length, charset)
+		status := "ok"
+		if bits < MinChallengeEntropy {
+			status = "below min-entropy"
+		}
+		rows = append(rows, []string{strconv.Itoa(length), strconv.FormatFloat(bits, 'f', 1, 64), strconv.FormatFloat(bits/8, 'f', 1, 64), status})
+	}
+	return rows
+}
+
+// entropy prints the entropy of every challenge length for the challenge
+// charset, or for the one given with --charset, to help picking a length
+func entropy(args []string) error {
+	fs := flag.NewFlagSet("entropy", flag.ContinueOnError)
+	charset := fs.String("charset", challengeCharset, "compute for these characters instead of the challenge charset")
+	format := fs.String("output-format", "", "table, csv or json")
+	asJSON := fs.Bool("json", false, "shorthand for --output-format json")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa entropy [--charset <chars>] [--output-format table|csv|json] [--json]")
+	}
+	if err := validateCharset(*charset); err != nil {
+		return err
+	}
+	if *asJSON {
+		*format = formatJSON
+	}
+	f, err := outputFormat(*format)
+	if err != nil {
+		return err
+	}
+	return writeRecords(os.Stdout, f, []string{"length", "bits", "bytes", "status"}, entropyRows(*charset))
+}
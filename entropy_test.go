@@ -0,0 +1,112 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestChallengeEntropy(t *testing.T) {
+	tests := []struct {
+		length   int
+		charset  string
+		expected float64
+	}{
+		{16, "01", 16},
+		{8, "0123456789abcdef", 32},
+		{16, challengeCharset, 16 * math.Log2(90)},
+	}
+	for _, tt := range tests {
+		if got := challengeEntropy(tt.length, tt.charset); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("length %d, charset size %d: got %f, expected %f", tt.length, len(tt.charset), got, tt.expected)
+		}
+	}
+}
+
+func TestChallengeLength(t *testing.T) {
+	tests := []struct {
+		args     []string
+		size     string
+		bits     float64
+		unit     string
+		charset  string
+		length   int
+		rest     []string
+		expected error
+	}{
+		{args: []string{"32", "abcd"}, length: 32, rest: []string{"abcd"}},
+		{args: []string{"abcd"}, length: 0, rest: []string{"abcd"}}, // the key default
+		{length: 0},
+		{args: []string{"0", "abcd"}, expected: ErrChallengeLength},
+		{args: []string{"0"}, expected: ErrChallengeLength},
+		{size: "medium", args: []string{"abcd"}, length: 32, rest: []string{"abcd"}},
+		{size: "large", length: 64},
+		{bits: 128, length: 32}, // 16 characters give 103.9 bits
+		{bits: 16, charset: "01", length: 16},
+		{size: "huge", expected: ErrChallengeSize},
+		{bits: 5000, expected: ErrEntropyTarget},
+		{size: "small", args: []string{"32"}, expected: ErrLengthFlags},
+		{size: "small", args: []string{"32", "abcd"}, expected: ErrLengthFlags},
+		{size: "small", bits: 80, expected: ErrLengthFlags},
+		{unit: unitBytes, args: []string{"16", "abcd"}, length: 32, rest: []string{"abcd"}}, // 128 bits
+		{unit: unitBytes, args: []string{"2"}, charset: "0123456789abcdef", length: 4},
+		{unit: unitBytes, args: []string{"abcd"}, length: 0, rest: []string{"abcd"}},
+		{unit: unitBytes, args: []string{"0"}, expected: ErrChallengeLength},
+		{unit: unitBytes, args: []string{"1000"}, expected: ErrEntropyTarget},
+		{unit: unitBytes, size: "small", expected: ErrLengthFlags},
+		{unit: "bits", args: []string{"16"}, expected: ErrLengthUnit},
+	}
+	for _, tt := range tests {
+		charset := cmp.Or(tt.charset, challengeCharset)
+		length, rest, err := challengeLength(tt.args, tt.size, tt.bits, cmp.Or(tt.unit, unitChars), charset)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%v %q %v: got %v, expected %v", tt.args, tt.size, tt.bits, err, tt.expected)
+			continue
+		}
+		if err == nil && (length != tt.length || !slices.Equal(rest, tt.rest)) {
+			t.Errorf("%v %q %v: got %d %v, expected %d %v", tt.args, tt.size, tt.bits, length, rest, tt.length, tt.rest)
+		}
+	}
+}
+
+func TestEntropyRows(t *testing.T) {
+	prevMin, prevMax := MinChallengeLength, MaxChallengeLength
+	t.Cleanup(func() { MinChallengeLength, MaxChallengeLength = prevMin, prevMax })
+	MinChallengeLength, MaxChallengeLength = 4, 64
+
+	expected := [][]string{
+		{"4", "16.0", "2.0", "below min-entropy"},
+		{"8", "32.0", "4.0", "below min-entropy"},
+		{"16", "64.0", "8.0", "below min-entropy"},
+		{"32", "128.0", "16.0", "ok"},
+		{"64", "256.0", "32.0", "ok"},
+	}
+	if rows := entropyRows("0123456789abcdef"); !slices.EqualFunc(rows, expected, slices.Equal) {
+		t.Errorf("got %v, expected %v", rows, expected)
+	}
+
+	for _, charset := range []string{"", "a", "abca"} {
+		if err := validateCharset(charset); !errors.Is(err, ErrCharset) {
+			t.Errorf("%q: got %v, expected %v", charset, err, ErrCharset)
+		}
+	}
+	if err := validateCharset(challengeCharset); err != nil {
+		t.Errorf("challenge charset rejected: %v", err)
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	if got := foldCase("aAbB0-"); got != "ab0-" {
+		t.Errorf("got %q", got)
+	}
+	if n := len(foldCase(challengeCharset)); n != len(challengeCharset)-26 {
+		t.Errorf("got %d characters, expected %d", n, len(challengeCharset)-26)
+	}
+	cfg := defaultChallengeConfig(16)
+	cfg.CaseInsensitive = true
+	if got, expected := challengeEntropy(16, cfg.solutionCharset()), 16*math.Log2(64); math.Abs(got-expected) > 1e-9 {
+		t.Errorf("got %f bits, expected %f", got, expected)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// Error categories, match them with errors.Is
+	ErrDatabase = errors.New("database error")
+	ErrParse    = errors.New("parse error")
+	ErrPolicy   = errors.New("policy violation")
+
+	ErrReadOnlyDB     = errors.New("database is not writable")
+	ErrNoSQLiteDriver = errors.New("the sqlite driver is unavailable")
+
+	// errQuietFailure fails a command without reporting it, for commands
+	// answering through their exit status
+	errQuietFailure = errors.New("quiet failure")
+)
+
+// Error attaches a category (ErrDatabase, ErrParse, ErrPolicy) to an
+// underlying error, both remain reachable through errors.Is / errors.As.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// Exit codes of the error categories with --json, other failures exit with 1
+const (
+	exitFailure  = 1
+	exitParse    = 2
+	exitPolicy   = 3
+	exitDatabase = 4
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrParse):
+		return exitParse
+	case errors.Is(err, ErrPolicy):
+		return exitPolicy
+	case errors.Is(err, ErrDatabase):
+		return exitDatabase
+	}
+	return exitFailure
+}
+
+func dbError(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	if sqliteReadOnly(err) {
+		if readOnlyDB != "" {
+			err = fmt.Errorf("%w: %s: %w", ErrReadOnlyDB, readOnlyDB, err)
+		} else {
+			err = fmt.Errorf("%w: %w", ErrReadOnlyDB, err)
+		}
+	}
+	return &Error{Kind: ErrDatabase, Err: err}
+}
+
+func parseError(format string, args ...any) error {
+	return &Error{Kind: ErrParse, Err: fmt.Errorf(format, args...)}
+}
+
+func policyError(format string, args ...any) error {
+	return &Error{Kind: ErrPolicy, Err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorCategories(t *testing.T) {
+	cause := errors.New("boom")
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{dbError("query failed: %w", cause), ErrDatabase},
+		{parseError("%w: %w", ErrFailedRead, cause), ErrParse},
+		{policyError("%w", ErrKeyPriv), ErrPolicy},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.kind) {
+			t.Errorf("%q: expected category %q", tt.err, tt.kind)
+		}
+		var e *Error
+		if !errors.As(tt.err, &e) || e.Kind != tt.kind {
+			t.Errorf("%q: expected *Error with kind %q", tt.err, tt.kind)
+		}
+	}
+	if !errors.Is(tests[0].err, cause) || !errors.Is(tests[1].err, cause) {
+		t.Error("underlying error lost while wrapping")
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	var buf bytes.Buffer
+	prevOutput := errOutput
+	jsonErrors, errOutput = true, &buf
+	t.Cleanup(func() { jsonErrors, errOutput = false, prevOutput })
+
+	for err, code := range map[error]int{
+		policyError("%w", ErrKeyPriv):       exitPolicy,
+		parseError("%w", ErrFailedRead):     exitParse,
+		dbError("query failed"):             exitDatabase,
+		errors.New("usage: pgp-mfa import"): exitFailure,
+	} {
+		buf.Reset()
+		logError("import", err)
+		var report errorReport
+		if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+			t.Fatalf("invalid json error %q: %v", buf.String(), err)
+		}
+		if report.Error != err.Error() || report.Code != code {
+			t.Errorf("got %+v, expected %q with code %d", report, err, code)
+		}
+	}
+}
+
+func TestImportKeyErrors(t *testing.T) {
+	useTestDB(t)
+
+	garbage := filepath.Join(t.TempDir(), "garbage")
+	if err := os.WriteFile(garbage, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := importKey([]string{garbage})
+	if !errors.Is(err, ErrParse) || !errors.Is(err, ErrFailedRead) {
+		t.Errorf("garbage input: got %v, expected parse error", err)
+	}
+
+	err = importKey([]string{filepath.Join(t.TempDir(), "missing")})
+	if !errors.Is(err, ErrOpenFailed) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, expected open error", err)
+	}
+
+	err = importKey([]string{writeKeyFile(t, ecKey, true)})
+	if !errors.Is(err, ErrPolicy) || !errors.Is(err, ErrKeyPriv) {
+		t.Errorf("private key: got %v, expected policy error", err)
+	}
+
+	pubFile := writeKeyFile(t, ecKey, false)
+	if err := importKey([]string{pubFile}); err != nil {
+		t.Fatalf("failed to import public key: %v", err)
+	}
+	err = importKey([]string{pubFile})
+	if !errors.Is(err, ErrPolicy) || !errors.Is(err, ErrAlreadyImported) {
+		t.Errorf("duplicate import: got %v, expected %v", err, ErrAlreadyImported)
+	}
+}
+
+func TestChallengeLengthErrors(t *testing.T) {
+	err := challenge([]string{"1024"})
+	if !errors.Is(err, ErrPolicy) || !errors.Is(err, ErrChallengeLength) {
+		t.Errorf("got %v, expected %v", err, ErrChallengeLength)
+	}
+	// Only an omitted length is left to the key default
+	err = challenge([]string{"0"})
+	if !errors.Is(err, ErrPolicy) || !errors.Is(err, ErrChallengeLength) {
+		t.Errorf("length 0: got %v, expected %v", err, ErrChallengeLength)
+	}
+	err = challenge([]string{"24"})
+	if !errors.Is(err, ErrPolicy) || !errors.Is(err, ErrChallengePow) {
+		t.Errorf("got %v, expected %v", err, ErrChallengePow)
+	}
+}
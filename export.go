@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/ProtonMail/gopenpgp/v3/armor"
+	"github.com/ProtonMail/gopenpgp/v3/constants"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+	"golang.org/x/term"
+)
+
+// exportKeyring returns the stored public keys, oldest first, as a single
+// keyring: the keys serialized one after the other
+func exportKeyring(stored []StoredKey) ([]byte, error) {
+	var keyRing *crypto.KeyRing
+	for i := len(stored) - 1; i >= 0; i-- {
+		key, err := parseKey(bytes.NewReader(stored[i].PubKey))
+		if err != nil {
+			return nil, err
+		}
+		if keyRing == nil {
+			keyRing, err = crypto.NewKeyRing(key)
+		} else {
+			err = keyRing.AddKey(key)
+		}
+		if err != nil {
+			return nil, parseError("failed to add %s to the keyring: %w", stored[i].Fingerprint, err)
+		}
+	}
+	if keyRing == nil {
+		return nil, policyError("%w: no key to export", ErrKeyNotFound)
+	}
+	data, err := keyRing.Serialize()
+	if err != nil {
+		return nil, parseError("%w: %w", ErrPubKeyFail, err)
+	}
+	return data, nil
+}
+
+// exportKeys writes a stored public key, or all of them as one keyring, for
+// other OpenPGP tools. The output is armored unless --binary is given.
+func exportKeys(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	all := fs.Bool("all", false, "export every stored key as a single keyring")
+	binary := fs.Bool("binary", false, "write the binary keyring instead of the armored one")
+	force := fs.Bool("force", false, "write --binary output to stdout even when it is a terminal")
+	output := fs.String("output", "", "file to write instead of stdout")
+	overwrite := fs.Bool("allow-overwrite", false, "replace the --output file if it exists")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *all == (len(args) == 1) || len(args) > 1 {
+		return errors.New("usage: pgp-mfa export [--binary [--force]] [--output <file> [--allow-overwrite]] --all | <key-id>")
+	}
+	if *binary && *output == "" {
+		if err := checkBinaryOutput(term.IsTerminal(int(os.Stdout.Fd())), *force); err != nil {
+			return err
+		}
+	}
+	var stored []StoredKey
+	if *all {
+		if stored, err = store.List(KeyQuery{}); err != nil {
+			return err
+		}
+	} else {
+		k, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		stored = []StoredKey{k}
+	}
+	data, err := exportKeyring(stored)
+	if err != nil {
+		return err
+	}
+	if !*binary {
+		armored, err := armor.ArmorWithType(data, constants.PublicKeyHeader)
+		if err != nil {
+			return parseError("%w: %w", ErrPubKeyFail, err)
+		}
+		data = []byte(armored + "\n")
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := createOutputFile(*output, *overwrite)
+		if errors.Is(err, ErrOutputExists) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write keys: %w", err)
+	}
+	slog.Info("keys exported", "event", "export", "count", len(stored), "output", *output)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v3/armor"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+func TestExportAll(t *testing.T) {
+	useTestDB(t)
+	dir := t.TempDir()
+	if err := exportKeys([]string{"--all", "--output", filepath.Join(dir, "empty.asc")}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("empty store: got %v, expected %v", err, ErrKeyNotFound)
+	}
+	keys := []*crypto.Key{ecKey, rsa3072Key}
+	for _, key := range keys {
+		if err := importKey([]string{writeKeyFile(t, key, false)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	armoredFile, binaryFile := filepath.Join(dir, "keyring.asc"), filepath.Join(dir, "keyring.gpg")
+	if err := exportKeys([]string{"--all", "--output", armoredFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportKeys([]string{"--all", "--binary", "--output", binaryFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportKeys([]string{"--all", "--output", armoredFile}); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("existing file: got %v, expected %v", err, ErrOutputExists)
+	}
+	armored, err := os.ReadFile(armoredFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := os.ReadFile(binaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unarmored, err := armor.Unarmor(string(armored)); err != nil || !bytes.Equal(unarmored, binary) {
+		t.Errorf("the armored keyring is not the binary one armored: %v", err)
+	}
+
+	// Round trip: every key of the keyring imports into an empty store
+	keyRing, err := crypto.NewKeyRingFromBinary(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestDB(t)
+	var fingerprints []string
+	for _, key := range keyRing.GetKeys() {
+		if err := importKey([]string{writeKeyFile(t, key, false)}); err != nil {
+			t.Fatal(err)
+		}
+		fingerprints = append(fingerprints, key.GetFingerprint())
+	}
+	for _, key := range keys {
+		if !slices.Contains(fingerprints, key.GetFingerprint()) {
+			t.Errorf("%s missing from the keyring", key.GetFingerprint())
+			continue
+		}
+		stored, err := store.Get(key.GetFingerprint())
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, err := key.GetPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stored.PubKey, original) {
+			t.Errorf("%s changed through the round trip", key.GetFingerprint())
+		}
+	}
+
+	for _, args := range [][]string{nil, {"--all", ecKey.GetFingerprint()}, {"a", "b"}} {
+		if err := exportKeys(args); err == nil {
+			t.Errorf("%v: expected a usage error", args)
+		}
+	}
+}
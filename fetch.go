@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+const (
+	keyFetchTimeout = 10 * time.Second
+	maxKeySize      = 1 << 20 // public keys are a few KiB, even with many signatures
+)
+
+var (
+	ErrFetchFailed        = errors.New("failed to fetch key")
+	ErrInsecureFetch      = errors.New("keys are only fetched over https")
+	ErrFingerprintFormat  = errors.New("a full fingerprint is required, 40 or 64 hex digits")
+	ErrFetchedKeyMismatch = errors.New("the keyserver returned another key")
+	ErrFetchNotFound      = errors.New("no key at this url")
+
+	keyFetchClient = &http.Client{Timeout: keyFetchTimeout, CheckRedirect: refuseInsecureRedirect}
+
+	// KeyFetchAttempts and KeyFetchBackoff retry transient fetch failures,
+	// the delay doubling after each attempt, set by the global --fetch-attempts
+	// and --fetch-backoff
+	KeyFetchAttempts = 3
+	KeyFetchBackoff  = time.Second
+)
+
+// retryableFetch marks the transient fetch failures worth retrying: server
+// errors, rate limiting, timeouts and truncated bodies
+type retryableFetch struct{ error }
+
+func (e retryableFetch) Unwrap() error { return e.error }
+
+func isRetryableFetch(err error) bool {
+	return errors.As(err, &retryableFetch{})
+}
+
+// refuseInsecureRedirect stops redirects to anything but https before they
+// are followed, and like the default policy after 10 of them
+func refuseInsecureRedirect(req *http.Request, via []*http.Request) error {
+	if req.URL.Scheme != "https" {
+		return policyError("%w: redirected to %s", ErrInsecureFetch, req.URL)
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// defaultKeyserver serves keys by fingerprint with the VKS api
+const defaultKeyserver = "https://keys.openpgp.org"
+
+// keyserverURL is where the key with fingerprint is fetched from a VKS
+// keyserver, only full fingerprints are accepted as key ids can collide
+func keyserverURL(keyserver, fingerprint string) (string, error) {
+	fingerprint = normalizeFingerprint(fingerprint)
+	if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != 40 && len(fingerprint) != 64 {
+		return "", parseError("%w: '%s'", ErrFingerprintFormat, fingerprint)
+	}
+	return strings.TrimSuffix(keyserver, "/") + "/vks/v1/by-fingerprint/" + strings.ToUpper(fingerprint), nil
+}
+
+// isURL tells whether a key file argument is an http(s) url
+func isURL(path string) bool {
+	u, err := url.Parse(path)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// fetchKey downloads the key hosted at rawURL, the body is read in full so
+// that size and transport errors surface here rather than while parsing.
+// Transient failures are retried KeyFetchAttempts times in all.
+func fetchKey(rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	if u.Scheme != "https" {
+		return nil, policyError("%w: %s", ErrInsecureFetch, rawURL)
+	}
+	delay := KeyFetchBackoff
+	for attempt := 1; ; attempt++ {
+		body, err := fetchKeyOnce(u)
+		if err == nil || !isRetryableFetch(err) || attempt >= KeyFetchAttempts || commandCtx.Err() != nil {
+			return body, err
+		}
+		slog.Warn("key fetch failed, retrying", "event", "key_fetch_retry", "url", rawURL, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-commandCtx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrFetchFailed, commandCtx.Err())
+		}
+		delay *= 2
+	}
+}
+
+func fetchKeyOnce(u *url.URL) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(commandCtx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	resp, err := keyFetchClient.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			err = retryableFetch{err}
+		}
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("%w: %s returned %s", ErrFetchFailed, u, resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			err = retryableFetch{err}
+		} else if resp.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%w: %w", err, ErrFetchNotFound)
+		}
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxKeySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, retryableFetch{err})
+	}
+	if len(body) > maxKeySize {
+		return nil, policyError("%w: %s is larger than %d bytes", ErrFetchFailed, u, maxKeySize)
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+// fetchRecipient downloads the key with fingerprint from keyserver for a
+// one-off challenge, it goes through the validation of imported keys and
+// its details are written to w before anything is issued
+func fetchRecipient(w io.Writer, keyserver, fingerprint string) (StoredKey, *crypto.Key, error) {
+	u, err := keyserverURL(keyserver, fingerprint)
+	if err != nil {
+		return StoredKey{}, nil, err
+	}
+	body, err := fetchKey(u)
+	if err != nil {
+		return StoredKey{}, nil, err
+	}
+	defer body.Close()
+	stored, key, err := inlineRecipient(body)
+	if err != nil {
+		return StoredKey{}, nil, err
+	}
+	if stored.Fingerprint != normalizeFingerprint(fingerprint) {
+		return StoredKey{}, nil, policyError("%w: %s instead of %s", ErrFetchedKeyMismatch, stored.Fingerprint, normalizeFingerprint(fingerprint))
+	}
+	fmt.Fprintf(w, "fetched key %s from %s, it is not stored\n", stored.Fingerprint, keyserver)
+	fmt.Fprintf(w, "\tuser ids: %s\n", strings.Join(sortedUserIDs(key), "; "))
+	fmt.Fprintf(w, "\tcreated: %s\n", key.GetEntity().PrimaryKey.CreationTime.Format(time.RFC3339))
+	return stored, key, nil
+}
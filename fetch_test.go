@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestImportFromURL(t *testing.T) {
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := rsa3072Key.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key.asc":
+			w.Write([]byte(armored))
+		case "/key.gpg":
+			w.Write(binary)
+		case "/huge":
+			w.Write([]byte(strings.Repeat("a", maxKeySize+1)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	prevClient := keyFetchClient
+	keyFetchClient = server.Client()
+	defer func() { keyFetchClient = prevClient }()
+
+	useTestDB(t)
+	if err := importKey([]string{server.URL + "/key.asc", server.URL + "/key.gpg"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{ecKey.GetFingerprint(), rsa3072Key.GetFingerprint()} {
+		if _, err := store.Get(key); err != nil {
+			t.Errorf("fetched key %s not imported: %v", key, err)
+		}
+	}
+
+	if err := importKey([]string{server.URL + "/missing"}); !errors.Is(err, ErrFetchFailed) || !strings.Contains(err.Error(), "404") {
+		t.Errorf("not found: got %v, expected %v with the status", err, ErrFetchFailed)
+	}
+	if err := importKey([]string{server.URL + "/huge"}); !errors.Is(err, ErrFetchFailed) {
+		t.Errorf("oversized: got %v, expected %v", err, ErrFetchFailed)
+	}
+	plain := "http://" + strings.TrimPrefix(server.URL, "https://") + "/key.asc"
+	if err := importKey([]string{plain}); !errors.Is(err, ErrInsecureFetch) {
+		t.Errorf("plain http: got %v, expected %v", err, ErrInsecureFetch)
+	}
+}
+
+func TestFetchInsecureRedirect(t *testing.T) {
+	var followed bool
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer plain.Close()
+	server := httptest.NewTLSServer(http.RedirectHandler(plain.URL+"/key.asc", http.StatusFound))
+	defer server.Close()
+	prevClient := keyFetchClient
+	keyFetchClient = server.Client()
+	keyFetchClient.CheckRedirect = refuseInsecureRedirect
+	defer func() { keyFetchClient = prevClient }()
+
+	useTestDB(t)
+	if err := importKey([]string{server.URL + "/key.asc"}); !errors.Is(err, ErrInsecureFetch) || !errors.Is(err, ErrPolicy) {
+		t.Errorf("got %v, expected %v", err, ErrInsecureFetch)
+	}
+	if followed {
+		t.Error("redirect to plain http was followed")
+	}
+}
+
+func TestChallengeFetch(t *testing.T) {
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The keyserver answers every lookup with the ed25519 key
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/vks/v1/by-fingerprint/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(armored))
+	}))
+	defer server.Close()
+	prevClient := keyFetchClient
+	keyFetchClient = server.Client()
+	defer func() { keyFetchClient = prevClient }()
+
+	useTestDB(t)
+	fingerprint := strings.ToUpper(ecKey.GetFingerprint())
+	err = challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", writeKeyFile(t, ecKey, true), "--keyserver", server.URL, "16", "--fetch", fingerprint})
+	if err != nil {
+		t.Errorf("fetched recipient challenge failed: %v", err)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("fetched recipient was stored: %v", err)
+	}
+
+	if err := challenge([]string{"--keyserver", server.URL, "--fetch", rsa3072Key.GetFingerprint(), "16"}); !errors.Is(err, ErrFetchedKeyMismatch) {
+		t.Errorf("other key served: got %v, expected %v", err, ErrFetchedKeyMismatch)
+	}
+	if err := challenge([]string{"--keyserver", server.URL, "--fetch", ecKey.GetHexKeyID(), "16"}); !errors.Is(err, ErrFingerprintFormat) {
+		t.Errorf("key id: got %v, expected %v", err, ErrFingerprintFormat)
+	}
+	if err := challenge([]string{"--fetch", fingerprint, "16", fingerprint}); err == nil {
+		t.Error("expected --fetch with a key-id to fail")
+	}
+}
+
+func TestFetchRetry(t *testing.T) {
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/flaky" && n <= 2:
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		case r.URL.Path == "/slow" && n == 1:
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(armored))
+		case r.URL.Path == "/down":
+			http.Error(w, "down", http.StatusInternalServerError)
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(armored))
+		}
+	}))
+	defer server.Close()
+	count := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[path]
+	}
+	prevClient, prevBackoff := keyFetchClient, KeyFetchBackoff
+	keyFetchClient = server.Client()
+	keyFetchClient.Timeout = 100 * time.Millisecond
+	KeyFetchBackoff = time.Millisecond
+	defer func() { keyFetchClient, KeyFetchBackoff = prevClient, prevBackoff }()
+
+	for path, expected := range map[string]int{"/flaky": 3, "/slow": 2} {
+		if _, err := fetchKey(server.URL + path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+		if got := count(path); got != expected {
+			t.Errorf("%s: got %d requests, expected %d", path, got, expected)
+		}
+	}
+	// Retries are bounded, client errors are not retried
+	for path, expected := range map[string]int{"/down": KeyFetchAttempts, "/missing": 1} {
+		if _, err := fetchKey(server.URL + path); !errors.Is(err, ErrFetchFailed) {
+			t.Errorf("%s: got %v, expected %v", path, err, ErrFetchFailed)
+		}
+		if got := count(path); got != expected {
+			t.Errorf("%s: got %d requests, expected %d", path, got, expected)
+		}
+	}
+}
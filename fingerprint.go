@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const fingerprintSaltSetting = "fingerprint_salt"
+
+var (
+	ErrHashingUnsupported = errors.New("fingerprint hashing needs the sqlite backend and an empty database")
+
+	// fingerprintSalt, when set, makes the database hold salted hashes of the
+	// fingerprints rather than the fingerprints themselves, and no user ids.
+	// openStore loads it from the settings, init-db --hash-fingerprints
+	// creates it. It lives next to the public keys the fingerprints can be
+	// computed from anyway: hashing keeps them out of the index, nothing more.
+	fingerprintSalt []byte
+)
+
+// fingerprintID is the form under which a fingerprint is stored: lower case,
+// or its HMAC-SHA256 keyed with the salt when hashing is enabled
+func fingerprintID(fingerprint string) string {
+	fingerprint = strings.ToLower(fingerprint)
+	if fingerprintSalt == nil {
+		return fingerprint
+	}
+	mac := hmac.New(sha256.New, fingerprintSalt)
+	mac.Write([]byte(fingerprint))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lookupIDs are the arguments of a `fingerprint IN (?, ?)` clause matching
+// both a fingerprint and a stored id as printed by list
+func lookupIDs(fingerprint string) []any {
+	return []any{fingerprintID(fingerprint), strings.ToLower(fingerprint)}
+}
+
+// activeTenantIDs are the arguments of a `tenant = ? AND fingerprint IN
+// (?, ?)` clause scoped to the active tenant
+func activeTenantIDs(fingerprint string) []any {
+	return append([]any{activeTenant}, lookupIDs(fingerprint)...)
+}
+
+// enableFingerprintHashing generates the salt of a database holding no key yet
+func enableFingerprintHashing(conn *sql.DB) error {
+	var count int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM keys`).Scan(&count); err != nil {
+		return dbError("failed to count keys: %w", err)
+	}
+	if count > 0 {
+		return policyError("%w: %d keys already stored, back them up and use init-db --force --hash-fingerprints then restore", ErrHashingUnsupported, count)
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate fingerprint salt: %w", err)
+	}
+	if err := setSetting(conn, fingerprintSaltSetting, salt); err != nil {
+		return err
+	}
+	fingerprintSalt = salt
+	return nil
+}
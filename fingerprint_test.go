@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashedFingerprints(t *testing.T) {
+	useTestDB(t) // restores the globals afterwards
+	path := filepath.Join(t.TempDir(), "hashed.db")
+	open := func() {
+		s, conn, err := openStore("sqlite:" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		store, db = s, conn
+	}
+	open()
+	if err := initDB([]string{"--hash-fingerprints"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := totpEnroll([]string{ecKey.GetFingerprint()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashing survives reopening the database
+	open()
+	fingerprint := ecKey.GetFingerprint()
+	for _, table := range []string{"keys", "totp"} {
+		var stored string
+		if err := db.QueryRow(`SELECT fingerprint FROM ` + table).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if strings.EqualFold(stored, fingerprint) || stored != fingerprintID(fingerprint) {
+			t.Errorf("%s: got %s, expected the hash of %s", table, stored, fingerprint)
+		}
+	}
+	keys, err := store.List(KeyQuery{})
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("got %d keys (%v), expected 1", len(keys), err)
+	}
+	// The user id would identify the key
+	var userID string
+	if err := db.QueryRow(`SELECT user_id FROM keys`).Scan(&userID); err != nil || userID != "" {
+		t.Errorf("got user id %q (%v), expected none stored", userID, err)
+	}
+	if err := store.Replace(ecKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT user_id FROM keys`).Scan(&userID); err != nil || userID != "" {
+		t.Errorf("replaced key: got user id %q (%v), expected none stored", userID, err)
+	}
+	if _, _, err := getKey(strings.ToUpper(fingerprint), KeyQuery{}); err != nil {
+		t.Errorf("lookup by fingerprint: %v", err)
+	}
+	if _, err := getTOTPSecret(fingerprint); err != nil {
+		t.Errorf("totp lookup by fingerprint: %v", err)
+	}
+
+	if err := initDB([]string{"--hash-fingerprints"}); err != nil {
+		t.Errorf("enabling hashing twice: %v", err)
+	}
+
+	// Listed ids work as well
+	if err := removeKey(keys[0].Fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getTOTPSecret(fingerprint); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Errorf("totp secret kept: %v", err)
+	}
+}
+
+func TestHashedFingerprintsNeedEmptyDB(t *testing.T) {
+	useTestDB(t)
+	if err := initDB([]string{"--hash-fingerprints"}); !errors.Is(err, ErrHashingUnsupported) {
+		t.Errorf("memory store: got %v, expected %v", err, ErrHashingUnsupported)
+	}
+	s, conn, err := openStore("sqlite:" + filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store, db = s, conn
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := initDB([]string{"--hash-fingerprints"}); !errors.Is(err, ErrHashingUnsupported) {
+		t.Errorf("non empty database: got %v, expected %v", err, ErrHashingUnsupported)
+	}
+}
@@ -3,14 +3,24 @@ module github.com/quintessence-sec/pgp-mfa
 go 1.23.2
 
 require (
+	github.com/ProtonMail/go-crypto v1.1.0
 	github.com/ProtonMail/gopenpgp/v3 v3.0.0
 	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/term v0.16.0
+	modernc.org/sqlite v1.34.5
 )
 
 require (
-	github.com/ProtonMail/go-crypto v1.1.0 // indirect
 	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/crypto v0.17.0 // indirect
-	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
 )
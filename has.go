@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// normalizeFingerprint accepts fingerprints as printed by gpg: spaced out,
+// with a 0x prefix, in any case
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.Join(strings.Fields(fingerprint), "")
+	if len(fingerprint) > 2 && strings.EqualFold(fingerprint[:2], "0x") {
+		fingerprint = fingerprint[2:]
+	}
+	return strings.ToLower(fingerprint)
+}
+
+// hasKey exits successfully when the key is stored, for scripts
+func hasKey(args []string) error {
+	fs := flag.NewFlagSet("has", flag.ContinueOnError)
+	quiet := fs.Bool("quiet", false, "no output, only the exit status")
+	fs.BoolVar(quiet, "q", false, "shorthand for --quiet")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa has [--quiet] <fingerprint>")
+	}
+	stored, err := store.Get(normalizeFingerprint(args[0]))
+	if errors.Is(err, ErrKeyNotFound) && *quiet {
+		return errQuietFailure
+	}
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Println(stored.Fingerprint)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFingerprint(t *testing.T) {
+	for input, expected := range map[string]string{
+		"ABCD 1234 EF": "abcd1234ef",
+		"0xABCD1234":   "abcd1234",
+		" 0Xabcd1234 ": "abcd1234",
+		"abcd1234":     "abcd1234",
+	} {
+		if got := normalizeFingerprint(input); got != expected {
+			t.Errorf("%q: got %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestHasKey(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := ecKey.GetFingerprint()
+	if err := hasKey([]string{"-q", "0x" + strings.ToUpper(fingerprint)}); err != nil {
+		t.Errorf("stored key: %v", err)
+	}
+	if err := hasKey([]string{"--quiet", "0000"}); !errors.Is(err, errQuietFailure) {
+		t.Errorf("quiet: got %v, expected %v", err, errQuietFailure)
+	}
+	if err := hasKey([]string{"0000"}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v, expected %v", err, ErrKeyNotFound)
+	}
+}
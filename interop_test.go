@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// decryptors are the external OpenPGP implementations challenges must be
+// solvable with, each returns the command decrypting msgFile with the
+// private key in keyFile, home is a scratch directory
+var decryptors = []struct {
+	name    string
+	command func(home, keyFile, msgFile string) [][]string
+}{
+	{"gpg", func(home, keyFile, msgFile string) [][]string {
+		return [][]string{
+			{"gpg", "--homedir", home, "--batch", "--quiet", "--import", keyFile},
+			{"gpg", "--homedir", home, "--batch", "--quiet", "--decrypt", msgFile},
+		}
+	}},
+	{"sq", func(home, keyFile, msgFile string) [][]string {
+		return [][]string{{"sq", "--home", home, "decrypt", "--recipient-file", keyFile, msgFile}}
+	}},
+	{"rnp", func(home, keyFile, msgFile string) [][]string {
+		return [][]string{{"rnp", "--homedir", home, "--keyfile", keyFile, "--decrypt", msgFile, "--output", "-"}}
+	}},
+}
+
+// TestInterop decrypts challenges with the implementations found on PATH,
+// the others are skipped
+func TestInterop(t *testing.T) {
+	var tested []string
+	keys := []struct {
+		name string
+		key  *crypto.Key
+	}{{"ed25519", ecKey}, {"rsa3072", rsa3072Key}}
+	for _, k := range keys {
+		for _, d := range decryptors {
+			t.Run(d.name+"/"+k.name, func(t *testing.T) {
+				if _, err := exec.LookPath(d.name); err != nil {
+					t.Skipf("%s is not installed", d.name)
+				}
+				solution, err := generateChallenge(rand.Reader, 32, challengeCharset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, armored, err := encryptChallenge(k.key, solution, nil, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				msgFile := filepath.Join(t.TempDir(), "challenge.asc")
+				if err := os.WriteFile(msgFile, []byte(armored), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				home := t.TempDir()
+				if d.name == "gpg" {
+					t.Cleanup(func() { exec.Command("gpgconf", "--homedir", home, "--kill", "all").Run() })
+				}
+				var out []byte
+				for _, args := range d.command(home, writeKeyFile(t, k.key, true), msgFile) {
+					cmd := exec.Command(args[0], args[1:]...)
+					cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+					if out, err = cmd.Output(); err != nil {
+						t.Fatalf("%s: %v", strings.Join(args, " "), err)
+					}
+				}
+				if strings.TrimSpace(string(out)) != string(solution) {
+					t.Errorf("got %q, expected %q", out, solution)
+				}
+				tested = append(tested, d.name+"/"+k.name)
+			})
+		}
+	}
+	t.Logf("implementations tested: %s", strings.Join(tested, ", "))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var ErrTimeoutAction = errors.New("unknown timeout action, expected error or reissue")
+
+// ChallengeDefaults are challenge settings stored with a key, e.g. a longer
+// timeout for a slow smartcard. They apply when the key is challenged unless
+// overridden by flags, zero fields are unset.
+type ChallengeDefaults struct {
+	Length        int           `json:"length,omitempty"`
+	SolveTime     time.Duration `json:"solve_time,omitempty"`
+	TimeoutAction string        `json:"timeout_action,omitempty"`
+}
+
+func parseTimeoutAction(action string) (string, error) {
+	switch action {
+	case timeoutActionError, timeoutActionReissue:
+		return action, nil
+	}
+	return "", parseError("%w: '%s'", ErrTimeoutAction, action)
+}
+
+func (d ChallengeDefaults) validate() error {
+	if d.Length != 0 {
+		if err := defaultChallengeConfig(d.Length).validate(); err != nil {
+			return err
+		}
+	}
+	if d.SolveTime < 0 {
+		return parseError("the default challenge timeout cannot be negative")
+	}
+	if d.TimeoutAction != "" {
+		if _, err := parseTimeoutAction(d.TimeoutAction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// challengeDefaultFlags registers the flags setting per key challenge
+// defaults, shared by import and config
+func challengeDefaultFlags(fs *flag.FlagSet, d *ChallengeDefaults) {
+	fs.IntVar(&d.Length, "length", 0, "default challenge length of the key")
+	fs.DurationVar(&d.SolveTime, "timeout", 0, "default time left to solve the key's challenges")
+	fs.StringVar(&d.TimeoutAction, "timeout-action", "", "default action once the key's challenges expire: error or reissue")
+}
+
+// setFlags returns the names of the flags given on the command line
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+func printChallengeDefaults(d ChallengeDefaults) {
+	unset := func(set bool, value any) any {
+		if !set {
+			return "unset"
+		}
+		return value
+	}
+	fmt.Println("length:", unset(d.Length != 0, d.Length))
+	fmt.Println("timeout:", unset(d.SolveTime != 0, d.SolveTime))
+	fmt.Println("timeout action:", unset(d.TimeoutAction != "", d.TimeoutAction))
+}
+
+// defaultKeySetting names the setting holding the key challenged when none
+// is given, each tenant has its own
+func defaultKeySetting() string {
+	return "default-key:" + activeTenant
+}
+
+// defaultKey returns the fingerprint of the default key, empty when unset
+func defaultKey() (string, error) {
+	value, err := getSetting(db, defaultKeySetting())
+	return string(value), err
+}
+
+// configDefaultKey sets or unsets the default key, which must be stored
+func configDefaultKey(action string, args []string) error {
+	if len(args) == 0 || args[0] != "default-key" || action == "set" && len(args) != 2 || action == "unset" && len(args) != 1 {
+		return errors.New("usage: pgp-mfa config set default-key <key-id> | config unset default-key")
+	}
+	if action == "unset" {
+		if _, err := db.Exec(`DELETE FROM settings WHERE name = ?`, defaultKeySetting()); err != nil {
+			return dbError("failed to unset the default key: %w", err)
+		}
+		return nil
+	}
+	stored, err := store.Get(args[1])
+	if err != nil {
+		return err
+	}
+	return setSetting(db, defaultKeySetting(), []byte(stored.Fingerprint))
+}
+
+// configKey shows or changes the challenge defaults of a stored key, only
+// the given flags are changed
+func configKey(args []string) error {
+	if len(args) > 0 && (args[0] == "set" || args[0] == "unset") {
+		return configDefaultKey(args[0], args[1:])
+	}
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	var given ChallengeDefaults
+	challengeDefaultFlags(fs, &given)
+	reset := fs.Bool("reset", false, "clear the defaults before applying the other flags")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa config <key-id> [--length <n>] [--timeout <duration>] [--timeout-action <action>] [--reset]")
+	}
+	stored, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+	set := setFlags(fs)
+	if len(set) == 0 {
+		printChallengeDefaults(stored.Defaults)
+		return nil
+	}
+	defaults := stored.Defaults
+	if *reset {
+		defaults = ChallengeDefaults{}
+	}
+	if set["length"] {
+		defaults.Length = given.Length
+	}
+	if set["timeout"] {
+		defaults.SolveTime = given.SolveTime
+	}
+	if set["timeout-action"] {
+		defaults.TimeoutAction = given.TimeoutAction
+	}
+	if err := defaults.validate(); err != nil {
+		return err
+	}
+	return store.Update(args[0], func(info *KeyInfo) {
+		info.Defaults = defaults
+	})
+}
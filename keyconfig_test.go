@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChallengeDefaults(t *testing.T) {
+	useTestDB(t)
+	fingerprint := ecKey.GetFingerprint()
+	if err := importKey([]string{"--length", "24", writeKeyFile(t, ecKey, false)}); !errors.Is(err, ErrChallengePow) {
+		t.Errorf("invalid default length: got %v, expected %v", err, ErrChallengePow)
+	}
+	if err := importKey([]string{"--length", "32", "--timeout", "3m", writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ChallengeDefaults{Length: 32, SolveTime: 3 * time.Minute}); stored.Defaults != expected {
+		t.Errorf("got %+v, expected %+v", stored.Defaults, expected)
+	}
+
+	// Only the given flags change
+	if err := configKey([]string{fingerprint, "--timeout-action", "reissue"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := configKey([]string{fingerprint, "--timeout-action", "retry"}); !errors.Is(err, ErrTimeoutAction) {
+		t.Errorf("got %v, expected %v", err, ErrTimeoutAction)
+	}
+	stored, _ = store.Get(fingerprint)
+	if expected := (ChallengeDefaults{Length: 32, SolveTime: 3 * time.Minute, TimeoutAction: timeoutActionReissue}); stored.Defaults != expected {
+		t.Errorf("got %+v, expected %+v", stored.Defaults, expected)
+	}
+
+	// The stored length replaces the length argument
+	privFile := writeKeyFile(t, ecKey, true)
+	if err := challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", privFile, fingerprint}); err != nil {
+		t.Errorf("challenge with the default length failed: %v", err)
+	}
+	if err := configKey([]string{fingerprint, "--reset"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = store.Get(fingerprint)
+	if stored.Defaults != (ChallengeDefaults{}) {
+		t.Errorf("defaults not reset: %+v", stored.Defaults)
+	}
+	if err := challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", privFile, fingerprint}); err == nil {
+		t.Error("expected a challenge without any length to fail")
+	}
+}
+
+func TestDefaultKey(t *testing.T) {
+	useTestDB(t)
+	fingerprint := ecKey.GetFingerprint()
+	if err := configKey([]string{"set", "default-key", fingerprint}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown default key: got %v, expected %v", err, ErrKeyNotFound)
+	}
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"set"}, {"set", "default-key"}, {"set", "other", fingerprint}, {"unset", "default-key", fingerprint}} {
+		if err := configKey(args); err == nil {
+			t.Errorf("%v: expected a usage error", args)
+		}
+	}
+	if err := configKey([]string{"set", "default-key", fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := defaultKey(); err != nil || got != fingerprint {
+		t.Errorf("got %q (%v), expected %q", got, err, fingerprint)
+	}
+	// Without key-id, the default key is challenged instead of prompting
+	privFile := writeKeyFile(t, ecKey, true)
+	if err := challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", privFile, "16"}); err != nil {
+		t.Errorf("challenge of the default key failed: %v", err)
+	}
+	t.Cleanup(func() { activeTenant = defaultTenant })
+	activeTenant = "other"
+	if got, err := defaultKey(); err != nil || got != "" {
+		t.Errorf("other tenant: got %q (%v), expected no default key", got, err)
+	}
+	activeTenant = defaultTenant
+	if err := configKey([]string{"unset", "default-key"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := defaultKey(); err != nil || got != "" {
+		t.Errorf("got %q (%v) once unset", got, err)
+	}
+
+	// Deleting the default key unsets it, deleting another key does not
+	if err := importKey([]string{writeKeyFile(t, rsa3072Key, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := configKey([]string{"set", "default-key", fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteKey([]string{rsa3072Key.GetFingerprint()}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := defaultKey(); err != nil || got != fingerprint {
+		t.Errorf("another key deleted: got %q (%v), expected %q", got, err, fingerprint)
+	}
+	if err := deleteKey([]string{strings.ToUpper(fingerprint)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := defaultKey(); err != nil || got != "" {
+		t.Errorf("default key deleted: got %q (%v), expected no default key", got, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+)
+
+var (
+	ErrLogFormat = errors.New("log format must be text or json")
+
+	jsonLogs bool
+
+	// jsonErrors renders command failures as a json object on stderr and
+	// exits with the exitCode of the error, set by the global --json flag
+	jsonErrors bool
+	errOutput  io.Writer = os.Stderr
+
+	// exit ends the process, replaced in tests
+	exit = os.Exit
+)
+
+type errorReport struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// setupLogging configures the default slog logger, verbose enables debug
+// records. In json mode plain log calls are routed through it too, text mode
+// keeps the log package format.
+func setupLogging(w io.Writer, format string, verbose bool) error {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	switch format {
+	case "text":
+		jsonLogs = false
+		log.SetOutput(w)
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		jsonLogs = true
+		handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey && len(groups) == 0 {
+					a.Key = "timestamp"
+				}
+				return a
+			},
+		})
+		slog.SetDefault(slog.New(handler))
+	default:
+		return ErrLogFormat
+	}
+	return nil
+}
+
+// logError reports a command failure, quiet failures are left to the exit
+// status and cancellations are not errors
+func logError(cmd string, err error) {
+	if errors.Is(err, errQuietFailure) {
+		return
+	}
+	if errors.Is(err, ErrCancelled) {
+		slog.Info("cancelled", "event", "cancelled", "command", cmd)
+		return
+	}
+	if jsonErrors {
+		json.NewEncoder(errOutput).Encode(errorReport{Error: err.Error(), Code: exitCode(err)})
+		return
+	}
+	if jsonLogs {
+		slog.Error(err.Error(), "event", "error", "command", cmd)
+		return
+	}
+	log.Printf("error: %v", err)
+}
+
+// logFatal reports a command failure and exits, with status 0 when the user
+// cancelled
+func logFatal(cmd string, err error) {
+	logError(cmd, err)
+	switch {
+	case errors.Is(err, ErrCancelled):
+		exit(0)
+	case jsonErrors:
+		exit(exitCode(err))
+	default:
+		exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestJSONLogging(t *testing.T) {
+	prev, flags := slog.Default(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		setupLogging(os.Stderr, "text", false)
+		log.SetFlags(flags)
+	})
+
+	var buf bytes.Buffer
+	if err := setupLogging(&buf, "json", false); err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("key imported successfully!", "event", "imported", "fingerprint", "abcd")
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid json log line %q: %v", buf.String(), err)
+	}
+	for _, field := range []string{"timestamp", "level", "event", "fingerprint"} {
+		if _, ok := record[field]; !ok {
+			t.Errorf("missing %s in %v", field, record)
+		}
+	}
+
+	// Plain log calls end up as json too
+	buf.Reset()
+	log.Println("plain message")
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil || record["msg"] != "plain message" {
+		t.Errorf("plain log not routed to json: %q", buf.String())
+	}
+
+	// Debug records only show up in verbose mode
+	buf.Reset()
+	slog.Debug("detected key format")
+	if buf.Len() != 0 {
+		t.Errorf("debug record logged without verbose: %q", buf.String())
+	}
+	if err := setupLogging(&buf, "json", true); err != nil {
+		t.Fatal(err)
+	}
+	slog.Debug("detected key format")
+	if buf.Len() == 0 {
+		t.Error("debug record not logged in verbose mode")
+	}
+
+	if err := setupLogging(&buf, "xml", false); !errors.Is(err, ErrLogFormat) {
+		t.Errorf("got %v, expected %v", err, ErrLogFormat)
+	}
+}
+
+func TestLogFatalExitStatus(t *testing.T) {
+	var status int
+	prevExit, prevOutput, prevJSON := exit, errOutput, jsonErrors
+	exit = func(code int) { status = code }
+	errOutput = io.Discard
+	t.Cleanup(func() { exit, errOutput, jsonErrors = prevExit, prevOutput, prevJSON })
+
+	for _, jsonErrors = range []bool{false, true} {
+		cases := map[error]int{
+			ErrCancelled:                           0,
+			fmt.Errorf("enroll: %w", ErrCancelled): 0,
+			errors.New("failed"):                   1,
+		}
+		if jsonErrors {
+			cases[parseError("%w", ErrFailedRead)] = exitParse
+		}
+		for err, expected := range cases {
+			status = -1
+			logFatal("enroll", err)
+			if status != expected {
+				t.Errorf("json %v, %v: got status %d, expected %d", jsonErrors, err, status, expected)
+			}
+		}
+	}
+}
@@ -3,36 +3,70 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"crypto/rand"
 	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ProtonMail/gopenpgp/v3/crypto"
-	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/term"
 )
 
 const (
-	dbPath           = "pgp-mfa.db"
 	challengeCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_+/\\'\"!@#$%^&*()[]{}<>?,.;:"
+
+	// ChallengeSolveTime is the default time left to solve a challenge
+	ChallengeSolveTime = time.Minute
 )
 
 var (
 	commands = map[string]func(args []string) error{
-		"help":      help,
-		"import":    importKey,
-		"challenge": challenge,
+		"help":         help,
+		"import":       importKey,
+		"challenge":    challenge,
+		"totp":         totp,
+		"backup":       backupDB,
+		"restore":      restoreDB,
+		"delete":       deleteKey,
+		"list":         listKeys,
+		"show":         showKey,
+		"label":        labelKey,
+		"init-db":      initDB,
+		"prune":        pruneKeys,
+		"trust":        trustKey,
+		"profiles":     listProfiles,
+		"has":          hasKey,
+		"cleanup":      cleanupChallenges,
+		"config":       configKey,
+		"whoami":       whoami,
+		"entropy":      entropy,
+		"check":        checkKeys,
+		"enroll":       enroll,
+		"challenges":   challenges,
+		"capabilities": capabilities,
+		"serve-http":   serveHTTP,
+		"refresh":      refresh,
+		"maintenance":  maintenance,
+		"export":       exportKeys,
 	}
-	db *sql.DB
+	db    *sql.DB
+	store KeyStore
 
-	ChallengeSolveTime = time.Duration(time.Minute * 1)
+	// readOnlyDB is the path of the open database when it cannot be written,
+	// reads still work and writes fail with ErrReadOnlyDB
+	readOnlyDB string
 
 	// Key related errors
 	ErrKeyPriv         = errors.New("key is private, only public keys are accepted")
@@ -41,251 +75,1437 @@ var (
 	ErrPubKeyFail      = errors.New("failed to get public key")
 	ErrOpenFailed      = errors.New("failed to open key file")
 	ErrAlreadyImported = errors.New("key already imported")
+	ErrKeyNotPriv      = errors.New("key is public, a private key is required for signing")
+	ErrDecryptCheck    = errors.New("test challenge not solved, key not imported")
 
 	// Challenge related errors
-	ErrChallengeLength = errors.New("challenge length must be a power of two between 1 and 512")
-	ErrChallengePow    = errors.New("challenge length must be a power of two")
+	ErrChallengeLength   = errors.New("challenge length must be a power of two between 1 and 512")
+	ErrChallengePow      = errors.New("challenge length must be a power of two")
+	ErrIncorrectSolution = errors.New("incorrect solution")
+	ErrSubkeyNotFound    = errors.New("subkey not found on key")
+	ErrSubkeyNoEncrypt   = errors.New("subkey is not a valid encryption key")
+	ErrChallengeKeyExp   = errors.New("key has expired, cannot issue a challenge")
+	ErrUserIDNotFound    = errors.New("user id not found on key")
 )
 
 func init() {
-	var err error
-
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	db, err = sql.Open("sqlite3", "file:"+dbPath)
-	if err != nil {
-		log.Fatalf("failed to open database %s: %v", dbPath, err)
+}
+
+// dbWritable reports whether an existing database file can be opened for
+// writing, new files are left for sqlite to create
+func dbWritable(path string) bool {
+	file, _, _ := strings.Cut(path, "?")
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	if err == nil {
+		f.Close()
+	}
+	return true
+}
+
+func openDB(path string) (*sql.DB, error) {
+	readOnlyDB = ""
+	if err := sqliteAvailable(); err != nil {
+		return nil, err
+	}
+	dsn := "file:" + path
+	if path != ":memory:" && !dbWritable(path) {
+		// opening read-write would fail on the first write with a cryptic
+		// sqlite error, read-only commands can still use the database
+		if strings.Contains(path, "?") {
+			dsn += "&mode=ro"
+		} else {
+			dsn += "?mode=ro"
+		}
+	}
+	if strings.Contains(dsn, "mode=ro") {
+		readOnlyDB, _, _ = strings.Cut(path, "?")
+		slog.Debug("database opened read-only", "event", "read_only_db", "path", readOnlyDB)
 	}
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS keys (
-		fingerprint VARCHAR(40) NOT NULL PRIMARY KEY,
-		pub_key BLOB NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`)
+	conn, err := sqliteOpen(dsn)
 	if err != nil {
-		log.Fatalf("failed to create table: %v", err)
+		return nil, dbError("failed to open database %s: %w", path, err)
+	}
+	if _, err := migrate(conn); err != nil {
+		conn.Close()
+		return nil, err
 	}
+	return conn, nil
 }
 
 func help(args []string) error {
-	fmt.Println("usage: pgp-mfa <command> [args...]")
+	fmt.Println("usage: pgp-mfa [--profile <name>] [--tenant <name>] [--min-length <n>] [--max-length <n>] [--fetch-attempts <n>] [--fetch-backoff <dur>] [--deadline <dur>] [--db <dsn>] [--log-format text|json] [--no-color] [--batch] [--verbose] [--json] <command> [args...]")
+	fmt.Println("\t--profile <name>            # key store $XDG_DATA_HOME/pgp-mfa/<name>.db, created on demand (default: default, a ./pgp-mfa.db is moved there)")
+	fmt.Println("\t--tenant <name>             # keys, totp secrets and rate limits of this tenant only, the same key may be enrolled by several (default: default)")
+	fmt.Println("\t--passphrase-fd <n>         # read the database passphrase from the first line of file descriptor n")
+	fmt.Println("\t                            # precedence: --passphrase-fd, then $PGP_MFA_DB_PASSPHRASE (leaks to child processes), then a prompt")
+	fmt.Println("\t--min-length <n>            # refuse challenges shorter than n (default 1)")
+	fmt.Println("\t--max-length <n>            # refuse challenges longer than n, the hard cap of 512 still applies")
+	fmt.Println("\t--fetch-attempts <n>        # tries of key downloads (import <url>, challenge --fetch) failing with 5xx or timeouts (default 3)")
+	fmt.Println("\t--fetch-backoff <dur>       # delay before the first retry, doubled after each (default 1s)")
+	fmt.Println("\t--deadline <dur>            # abort the command with 'command deadline exceeded' once it ran this long, prompts and downloads included")
+	fmt.Println("\t--db <dsn>                  # sqlite:<path>, <path> or memory:, defaults to $PGP_MFA_DB, overrides --profile")
+	fmt.Println("\t--log-format text|json      # json emits one structured log record per line on stderr")
+	fmt.Println("\t--no-color                  # plain output on terminals too, as NO_COLOR=1 (output that is not a terminal is never colored)")
+	fmt.Println("\t--batch                     # never prompt, fail with 'input required in batch mode' instead")
+	fmt.Println("\t--verbose                   # debug logs, e.g. the detected format of imported keys")
+	fmt.Println("\t--json                      # errors as {\"error\": ..., \"code\": N} on stderr, exit code N: 2 parse, 3 policy, 4 database, 1 other")
 	fmt.Println("commands:")
-	fmt.Println("\timport <key-file> # armored / binary format accepted, - for stdin")
-	fmt.Println("\tchallenge [key-id]    # if no key-id is provided, you'll be prompted to select one")
+	fmt.Println("\timport <key-file>... # armored / binary format accepted, - for stdin, https:// urls are fetched")
+	fmt.Println("\t\t--atomic              # import every key or none, default is best effort per file")
+	fmt.Println("\t\t--proton <email>      # also import the primary key of a Proton address, fetched from the Proton key api (repeatable)")
+	fmt.Println("\t\t--public-only         # accept a private key, importing only its public half")
+	fmt.Println("\t\t--label <text>        # free text describing the key (owner, device...)")
+	fmt.Println("\t\t--card                # the private key lives on an OpenPGP smartcard, challenges remind to insert it")
+	fmt.Println("\t\t--allow-expired       # import an expired key with a warning (archival, recovery), challenges refuse it")
+	fmt.Println("\t\t--trust <level>       # owner trust: none, unknown (default), marginal, full or ultimate")
+	fmt.Println("\t\t--preserve-headers    # keep the armor headers (Comment: ...) of the key, shown by show, --verbose logs them anyway")
+	fmt.Println("\t\t--stdin               # read the key from stdin before any prompt: paste it, ctrl-d, then prompts like --verify-decrypt work")
+	fmt.Println("\t\t--from-clipboard      # import the key copied to the clipboard (wl-paste, xclip, xsel, pbpaste)")
+	fmt.Println("\t\t--verify-decrypt      # store the key only once a test challenge encrypted to it is solved")
+	fmt.Println("\t\t--revoker <file>       # public key of a designated revoker: keys it revoked are stored as revoked (repeatable)")
+	fmt.Println("\t\t--nist-curves <policy> # keys using NIST P-curves: allow, warn (default) or reject, ed25519 / cv25519 is preferred")
+	fmt.Println("\t\t--length, --timeout, --timeout-action # challenge defaults stored with the key, see config")
+	fmt.Println("\tenroll <key-file>           # onboarding: confirm the key's fingerprint, solve a challenge with it, only then is it stored")
+	fmt.Println("\t\t--label <text>, --trust <level>, --card, --nist-curves <policy>, --revoker <file> # as for import")
+	fmt.Println("\tchallenge [key-id]    # without key-id: the default key if set, else you'll be prompted to select one, while solving :show prints the challenge again")
+	fmt.Println("\t\t--subkey <key-id>     # encrypt to this specific encryption subkey")
+	fmt.Println("\t\t--copy-to-clipboard   # copy the armored challenge to the clipboard instead of a file")
+	fmt.Println("\t\t--deliver <command>   # pipe the armored challenge to a command (mail, chat...) instead of a file")
+	fmt.Println("\t\t--allow-totp          # also accept the key's totp code as a fallback solution, each code once, disabled after 3 invalid ones")
+	fmt.Println("\t\t--min-entropy <bits>  # warn when the challenge entropy is lower (default 80)")
+	fmt.Println("\t\t--sign-with <file>    # sign the challenge with this private key so its origin can be verified")
+	fmt.Println("\t\t--rate-limit <n>      # max challenges issued in a burst for a key (default 5, 0 disables)")
+	fmt.Println("\t\t--rate-window <dur>   # time to fully refill the rate limit (default 10m)")
+	fmt.Println("\t\t--print-path          # print only the challenge file path on stdout and keep the file")
+	fmt.Println("\t\t--comment <text>      # armor header comment so the solver can tell challenges apart")
+	fmt.Println("\t\t--solve-hint <cmd>    # solve command printed for the challenge file, {file} is its path (default \"gpg -dq --batch < {file}\")")
+	fmt.Println("\t\t--min-trust <level>   # refuse keys trusted less, the selection prompt only lists trusted enough keys")
+	fmt.Println("\t\t--size <name>         # instead of <length>: small (16), medium (32) or large (64)")
+	fmt.Println("\t\t--bits <n>            # instead of <length>: shortest length with at least n bits of entropy")
+	fmt.Println("\t\t--unit chars|bytes    # <length> in characters (default) or in bytes of entropy: 16 bytes are 128 bits, 32 characters")
+	fmt.Println("\t\t--timeout-action <a>  # on expiry: error (default) or reissue a fresh challenge and keep solving")
+	fmt.Println("\t\t--timeout <duration>  # time left to solve the challenge, 1m by default")
+	fmt.Println("\t\t--user-id <uid>       # user id (or email) of the key the challenge is meant for, shown and put in the receipt")
+	fmt.Println("\t\t--symmetric-password  # prompt for a password the solver also needs: the challenge is encrypted with it, then to the key")
+	fmt.Println("\t\t--qr                  # also render the armored challenge as a terminal qr code (needs qrencode)")
+	fmt.Println("\t\t--wrap <prefix>       # encrypt <prefix>{challenge} so its boundaries stand out, solutions are accepted with or without it")
+	fmt.Println("\t\t--quiet               # no \"encrypting…\" status on stderr, shown for large challenges to big RSA keys")
+	fmt.Println("\t\t--case-insensitive    # accept solutions in any case, easier to type back but fewer bits of entropy (shown)")
+	fmt.Println("\t\t--binary              # write the challenge as a binary OpenPGP message to stdout, e.g. > challenge.gpg")
+	fmt.Println("\t\t--force               # allow --binary to write to a terminal")
+	fmt.Println("\t\t--keep <n>            # only the n most recent unsolved challenges of the key stay solvable, older ones are deleted (default 0, all)")
+	fmt.Println("\t\t--log-attempts        # log each failed attempt (challenge id, attempt number, reason) for intrusion detection, never the guess")
+	fmt.Println("\t\t--checksum            # append a check character (Luhn mod N), a mistyped solution is reported as such, not as wrong")
+	fmt.Println("\t\t--structured          # encrypt {\"nonce\", \"exp\", \"id\"} as json, answer with the nonce (or the whole object)")
+	fmt.Println("\t\t--no-file             # never write the challenge to disk, only print it (for noexec or audited temp dirs)")
+	fmt.Println("\t\t--recipient-armored <key> # challenge this armored public key instead of a stored one, e.g. \"$KEY\" in CI")
+	fmt.Println("\t\t--fetch <fingerprint> # challenge this key from the keyserver without storing it, instead of a key-id")
+	fmt.Println("\t\t--keyserver <url>     # VKS keyserver used by --fetch (default https://keys.openpgp.org)")
+	fmt.Println("\t\t--self-solve <file>   # TESTING ONLY, defeats MFA: decrypt and submit the solution with this private key")
+	fmt.Println("\t\t--receipt-key <file>  # sign a receipt (challenge id, fingerprint, times) with this private key once solved")
+	fmt.Println("\t\t--tmpdir <dir>        # where the challenge file is written (default $XDG_RUNTIME_DIR or a private cache dir)")
+	fmt.Println("\tchallenges list <fingerprint> # outstanding challenges of a key: id, status, issue and expiry times, never solutions")
+	fmt.Println("\t\t--all                  # also list solved and expired challenges")
+	fmt.Println("\t\t--output-format table|csv|json, --json # table on a terminal, json otherwise")
+	fmt.Println("\tlist [--output-format table|csv|json] # list stored keys, table on a terminal, json otherwise")
+	fmt.Println("\t\t--since <date>         # only keys imported at or after date, RFC3339, YYYY-MM-DD or relative like 7d")
+	fmt.Println("\t\t--before <date>        # only keys imported before date")
+	fmt.Println("\t\t--sort <column>        # created (newest first, default), fingerprint or userid")
+	fmt.Println("\t\t--limit <n>            # list at most n keys")
+	fmt.Println("\t\t--offset <n>           # skip the first n keys")
+	fmt.Println("\t\t--count-only           # only print the number of keys matching --since and --before")
+	fmt.Println("\tshow [--output-format table|csv|json] <key-id> # show a stored key's details")
+	fmt.Println("\tlabel <key-id> <text>       # set the free text label of a stored key")
+	fmt.Println("\trefresh <fingerprint>       # download a key imported from an https url again, stored when newer (new subkey, extended expiry...)")
+	fmt.Println("\tconfig <key-id>             # show the challenge defaults of a key, used unless challenge flags override them")
+	fmt.Println("\tconfig set default-key <key-id> # key challenged when no key-id is given, instead of the selection prompt (unset default-key)")
+	fmt.Println("\t\t--length <n>           # default length, challenge <key-id> then needs no length argument")
+	fmt.Println("\t\t--timeout <duration>   # default time to solve, e.g. 3m for a slow smartcard")
+	fmt.Println("\t\t--timeout-action <a>   # default action on expiry: error or reissue")
+	fmt.Println("\t\t--reset                # clear the defaults first")
+	fmt.Println("\ttrust <key-id> <level>      # set the owner trust of a stored key (none, unknown, marginal, full, ultimate)")
+	fmt.Println("\twhoami [--json]             # effective settings (db, profile, timeouts...) and where each comes from: flag, env, profile or default")
+	fmt.Println("\tcapabilities [--json]       # supported key algorithms, curves, profiles, charsets, challenge sizes and modes")
+	fmt.Println("\tentropy [--charset <chars>] # entropy in bits of every challenge length, to pick the shortest meeting a policy")
+	fmt.Println("\tprofiles                    # list the profiles, the active one marked with *")
+	fmt.Println("\tserve-http                  # http api: POST /challenge {fingerprint, length}, POST /verify {id, solution}")
+	fmt.Println("\t\t--listen <addr>        # address to listen on (default 127.0.0.1:8080)")
+	fmt.Println("\t\t--tls-cert <file>      # serve https with this certificate, along with --tls-key <file>")
+	fmt.Println("\t\t--keep <n>             # only the n most recent unsolved challenges of each key can be verified (default 0, all)")
+	fmt.Println("\tshell                       # run commands interactively on one open database, 'history', '!n' and 'exit' built in")
+	fmt.Println("\thas [--quiet] <fingerprint> # exit status 0 when the key is stored, 1 otherwise (spaces and 0x are ignored)")
+	fmt.Println("\tcheck <key-file>...         # run the import and challenge checks without storing, fails if any does (for CI)")
+	fmt.Println("\t\t--public-only          # check the public half of private keys")
+	fmt.Println("\t\t--nist-curves <policy> # allow, warn (default, passes) or reject NIST P-curve keys")
+	fmt.Println("\t\t--revoker <file>       # designated revoker key to check revocations against (repeatable)")
+	fmt.Println("\tdelete <key-id>             # remove a stored key")
+	fmt.Println("\tcleanup                     # remove challenge files left by interrupted runs")
+	fmt.Println("\t\t--older-than <dur>     # only files older than this (default 1h)")
+	fmt.Println("\t\t--tmpdir <dir>         # clean this directory instead of the challenge and system temp ones")
+	fmt.Println("\t\t--dry-run              # only list the files")
+	fmt.Println("\tprune [--dry-run]           # remove every expired key, --dry-run only lists them")
+	fmt.Println("\tmaintenance                 # prune expired unsolved challenges, VACUUM and PRAGMA optimize, reports the space reclaimed")
+	fmt.Println("\ttotp enroll <key-id>        # generate a fallback totp secret for a key")
+	fmt.Println("\ttotp verify <key-id> <code> # check a totp code, each code is accepted once")
+	fmt.Println("\tinit-db [--force]           # create the schema and print its version, --force drops all data first")
+	fmt.Println("\t\t--hash-fingerprints   # store salted fingerprint hashes and no user ids, list then shows hashes (empty database only)")
+	fmt.Println("\t\t                      # the public keys are still stored: anyone holding the database can recover fingerprints and user ids")
+	fmt.Println("\texport --all | <key-id>     # armored public keys, all of them as one keyring, for other OpenPGP tools")
+	fmt.Println("\t\t--output <file>       # write the file instead of stdout, --allow-overwrite replaces an existing one")
+	fmt.Println("\t\t--binary              # binary keyring, refused on a terminal stdout unless --force")
+	fmt.Println("\tbackup <file>               # dump keys, totp secrets and issued challenges to a json file")
+	fmt.Println("\t\t--allow-overwrite      # replace the file if it exists, refused by default")
+	fmt.Println("\trestore <file>              # import a backup, skipping keys already present")
+	printAliases()
 	return nil
 }
 
-func openKey(keyFile string) (*os.File, error) {
+// parseArgs parses flags that may be interleaved with positional arguments,
+// returning the positional ones in order
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// openKey opens a key file, - for stdin, an https url or a Proton address to
+// download it
+func openKey(keyFile string) (io.ReadCloser, error) {
 	if keyFile == "-" {
 		return os.Stdin, nil
 	}
+	// Read through the prompts' buffer, which can still prompt once the key
+	// was read from a terminal
+	if keyFile == stdinKeyPath {
+		data, err := io.ReadAll(io.LimitReader(stdin, maxKeySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxKeySize {
+			return nil, policyError("the key read from stdin is larger than %d bytes", maxKeySize)
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	if keyFile == clipboardKeyPath {
+		data, err := readClipboard()
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+	if address, ok := strings.CutPrefix(keyFile, protonKeyPrefix); ok {
+		return fetchProtonKey(address)
+	}
+	if isURL(keyFile) {
+		return fetchKey(keyFile)
+	}
 	return os.Open(keyFile)
 }
 
 func importKey(args []string) error {
-	if len(args) != 1 {
-		fmt.Println("usage: pgp-mfa import <key-file>")
-		os.Exit(1)
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	publicOnly := fs.Bool("public-only", false, "import the public half of a private key")
+	label := fs.String("label", "", "free text describing the key (owner, device...)")
+	atomic := fs.Bool("atomic", false, "import all the keys or none of them")
+	card := fs.Bool("card", false, "the private key lives on an OpenPGP smartcard")
+	allowExpired := fs.Bool("allow-expired", false, "import expired keys with a warning, challenges still refuse them")
+	trust := fs.String("trust", defaultTrust, "owner trust: none, unknown, marginal, full or ultimate")
+	nistPolicy := fs.String("nist-curves", curvePolicyWarn, "keys using NIST P-curves: allow, warn or reject")
+	checkDecrypt := fs.Bool("verify-decrypt", false, "have a test challenge solved before storing the key")
+	fromClipboard := fs.Bool("from-clipboard", false, "also import the armored key copied to the clipboard")
+	preserveHeaders := fs.Bool("preserve-headers", false, "store the armor headers (Comment: ...) of the key, shown by show")
+	fromStdin := fs.Bool("stdin", false, "also import the key read from stdin, before any prompt")
+	var defaults ChallengeDefaults
+	challengeDefaultFlags(fs, &defaults)
+	var protonAddresses []string
+	fs.Func("proton", "email address whose key to fetch from the Proton key api (repeatable)", func(address string) error {
+		protonAddresses = append(protonAddresses, address)
+		return nil
+	})
+	var revokerPaths []string
+	fs.Func("revoker", "public key file of a designated revoker, to check its revocations (repeatable)", func(path string) error {
+		revokerPaths = append(revokerPaths, path)
+		return nil
+	})
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *fromClipboard {
+		args = append(args, clipboardKeyPath)
+	}
+	for _, address := range protonAddresses {
+		args = append(args, protonKeyPrefix+address)
+	}
+	// First, so that the key is read before the prompts of the others
+	if *fromStdin {
+		args = append([]string{stdinKeyPath}, args...)
+	}
+	if len(args) < 1 {
+		return errors.New("usage: pgp-mfa import [--from-clipboard] [--stdin] [--proton <email>]... [--preserve-headers] [--public-only] [--label <text>] [--atomic] [--card] [--allow-expired] [--trust <level>] [--nist-curves <policy>] [--revoker <file>]... [--verify-decrypt] [--length <n>] [--timeout <duration>] [--timeout-action <action>] <key-file>...")
+	}
+	if *checkDecrypt && batchMode {
+		return policyError("%w: --verify-decrypt", ErrInputRequired)
+	}
+	if *checkDecrypt && slices.Contains(args, "-") {
+		return errors.New("--verify-decrypt reads the solution from stdin, it cannot read the key from it too, use --stdin on a terminal")
+	}
+	if *checkDecrypt && *fromStdin && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("--verify-decrypt reads the solution from stdin, once the key was piped to --stdin nothing is left to read")
+	}
+	trustLevel, err := parseTrust(*trust)
+	if err != nil {
+		return err
 	}
+	curvePolicy, err := parseCurvePolicy(*nistPolicy)
+	if err != nil {
+		return err
+	}
+	if err := defaults.validate(); err != nil {
+		return err
+	}
+
+	importOne := func(s KeyStore, path string) error {
+		key, headers, err := readKeyFile(path, *publicOnly, *allowExpired)
+		if err != nil {
+			return err
+		}
+		revoked, err := vetImport(s, key, curvePolicy, revokerPaths)
+		if err != nil {
+			return err
+		}
+		if *checkDecrypt {
+			if err := verifyDecrypt(key, stdin, os.Stdout, term.IsTerminal(int(os.Stdin.Fd()))); err != nil {
+				return err
+			}
+		}
+		slog.Info("importing key", "event", "import", "fingerprint", key.GetFingerprint())
+		now := time.Now()
+		info := KeyInfo{CreatedAt: now, Label: *label, Card: *card, Expired: key.IsExpired(now.Unix()), Trust: trustLevel, Revoked: revoked, Defaults: defaults}
+		if *preserveHeaders {
+			info.ArmorHeaders = strings.Join(headers, "\n")
+		}
+		if isURL(path) || strings.HasPrefix(path, protonKeyPrefix) {
+			info.Origin = path
+		}
+		if err := s.Import(key, info); err != nil {
+			return err
+		}
+		slog.Info("key imported successfully!", "event", "imported", "fingerprint", key.GetFingerprint())
+		return nil
+	}
+
+	if *atomic {
+		return store.Atomic(func(tx KeyStore) error {
+			for _, path := range args {
+				if err := importOne(tx, path); err != nil {
+					return fmt.Errorf("%s: %w, no key of the batch was imported", path, err)
+				}
+			}
+			return nil
+		})
+	}
+	// Best effort, every file is attempted
+	var failures []error
+	for _, path := range args {
+		if err := importOne(store, path); err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// vetImport applies the policies of import and enroll beyond validateKey:
+// the NIST curve policy, and designated revocations checked against the
+// revokers in s and the revokerPaths files. It tells whether key was revoked.
+func vetImport(s KeyStore, key *crypto.Key, curvePolicy string, revokerPaths []string) (bool, error) {
+	if err := checkCurvePolicy(key, curvePolicy); err != nil {
+		return false, err
+	}
+	revokers, err := revokerCandidates(s, key, revokerPaths)
+	if err != nil {
+		return false, err
+	}
+	revoked := designatedRevocation(key, revokers)
+	if revoked {
+		slog.Warn("key has been revoked by its designated revoker, challenges will refuse it", "event", "import", "fingerprint", key.GetFingerprint())
+	}
+	return revoked, nil
+}
+
+// stdinKeyPath stands for the key read with import --stdin among the key files
+const stdinKeyPath = "(stdin)"
 
-	keyFile, err := openKey(args[0])
+// readKeyFile loads and validates the key to import from path (- for stdin),
+// allowExpired downgrades the expiry check to a warning. The armor headers of
+// the key are returned along with it, logged in verbose mode.
+func readKeyFile(path string, publicOnly, allowExpired bool) (*crypto.Key, []string, error) {
+	keyFile, err := openKey(path)
 	if err != nil {
-		return ErrOpenFailed
+		return nil, nil, fmt.Errorf("%w: %w", ErrOpenFailed, err)
 	}
 	defer keyFile.Close()
-	key, err := crypto.NewKeyFromReader(keyFile)
+	data, err := io.ReadAll(keyFile)
 	if err != nil {
-		return ErrFailedRead
+		return nil, nil, fmt.Errorf("%w: %w", ErrFailedRead, err)
 	}
-	bytes, err := key.GetPublicKey()
+	key, err := parseKey(bytes.NewReader(data))
 	if err != nil {
-		return ErrPubKeyFail
+		return nil, nil, err
 	}
-	if key.IsPrivate() {
-		return ErrKeyPriv
+	headers := armorHeaders(data)
+	for _, header := range headers {
+		slog.Debug("armor header", "event", "armor_header", "fingerprint", key.GetFingerprint(), "header", header)
 	}
-	if key.IsExpired(time.Now().Unix()) {
-		return ErrKeyExp
+	if key.IsPrivate() && publicOnly {
+		slog.Warn("a private key was supplied, only its public half will be imported", "event", "import", "fingerprint", key.GetFingerprint())
+		privKey := key
+		key, err = privKey.ToPublic()
+		privKey.ClearPrivateParams()
+		if err != nil {
+			return nil, nil, parseError("%w: %w", ErrPubKeyFail, err)
+		}
+	}
+	if err := validateKey(key); errors.Is(err, ErrKeyExp) && allowExpired {
+		slog.Warn("importing an expired key, challenges will refuse it", "event", "import", "fingerprint", key.GetFingerprint())
+	} else if err != nil {
+		return nil, nil, err
 	}
-	log.Printf("importing key: %s\n", key.GetFingerprint())
-	_, err = db.Exec(`INSERT INTO keys (fingerprint, pub_key, created_at) VALUES (?, ?, ?)`,
-		key.GetFingerprint(),
-		bytes,
-		time.Now(),
-	)
+	return key, headers, nil
+}
+
+// verifyDecrypt issues a throwaway challenge to key and has it solved, to
+// make sure the matching private key can actually decrypt challenges
+func verifyDecrypt(key *crypto.Key, r io.Reader, w io.Writer, interactive bool) error {
+	issued, err := issueChallenge(key, defaultChallengeConfig(16), time.Now())
 	if err != nil {
-		return fmt.Errorf("key import error: %v", err)
+		return err
 	}
-	log.Println("key imported successfully!")
+	fmt.Fprintln(w, "decrypt this test challenge to confirm the key can solve challenges (gpg -dq, then paste the message):")
+	fmt.Fprintln(w, issued.Armored)
+	if err := solveChallenge(r, w, interactive, issued.Solution, nil, issued.ExpiresAt, ChallengeConfig{}, nil, nil, nil); err != nil {
+		return policyError("%w: %w", ErrDecryptCheck, err)
+	}
+	slog.Info("test challenge solved", "event", "import_verify_decrypt", "fingerprint", key.GetFingerprint())
 	return nil
 }
 
-func getKey(fingerprint string) (*crypto.Key, error) {
+// inlineRecipient parses and validates a public key passed on the command
+// line or fetched from a keyserver, it is used as is without being stored
+func inlineRecipient(r io.Reader) (StoredKey, *crypto.Key, error) {
+	key, err := parseKey(r)
+	if err != nil {
+		return StoredKey{}, nil, err
+	}
+	if err := validateKey(key); err != nil {
+		return StoredKey{}, nil, err
+	}
+	stored := StoredKey{
+		Fingerprint: key.GetFingerprint(),
+		UserID:      primaryUserID(key),
+		KeyInfo:     KeyInfo{CreatedAt: time.Now(), Trust: defaultTrust},
+	}
+	return stored, key, nil
+}
+
+// parseKey reads an armored or binary key. Malformed packets can make the
+// OpenPGP parser panic, the panic is turned into a parse error as keys
+// come from untrusted input.
+func parseKey(r io.Reader) (key *crypto.Key, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			key, err = nil, parseError("%w: malformed key: %v", ErrFailedRead, p)
+		}
+	}()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFailedRead, err)
+	}
+	format := keyFormat(data)
+	slog.Debug("detected key format", "event", "key_format", "format", format, "bytes", len(data))
+	key, err = crypto.NewKeyFromReader(bytes.NewReader(extractArmoredKey(data)))
+	switch {
+	case err == nil:
+		return key, nil
+	case format == formatArmored:
+		return nil, parseError("%w: %w: %w", ErrFailedRead, ErrMalformedArmor, err)
+	case format == formatUnknown:
+		return nil, parseError("%w: %w", ErrFailedRead, ErrUnknownKeyFormat)
+	default:
+		return nil, parseError("%w: invalid binary key: %w", ErrFailedRead, err)
+	}
+}
+
+// validateKey runs the checks a key has to pass before being stored
+func validateKey(key *crypto.Key) error {
+	now := time.Now()
+	for _, c := range importChecks {
+		if err := c.check(key, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getKey loads the key matching fingerprint, or prompts to select one of the
+// keys matching q when fingerprint is empty
+func getKey(fingerprint string, q KeyQuery) (StoredKey, *crypto.Key, error) {
 	// Non interactive mode, we got a fingerprint passed
 	if len(fingerprint) > 0 {
-		row, err := db.Query(`SELECT pub_key FROM keys WHERE fingerprint = ?`, strings.ToLower(fingerprint))
+		stored, err := store.Get(fingerprint)
 		if err != nil {
-			return nil, fmt.Errorf("failed to query key: %v", err)
+			return stored, nil, err
+		}
+		key, err := stored.Key()
+		return stored, key, err
+	}
+
+	// Otherwise interactive mode, only the chosen key gets parsed
+	stored, err := store.List(q)
+	if err != nil {
+		return StoredKey{}, nil, err
+	}
+	if len(stored) == 0 {
+		return StoredKey{}, nil, policyError("%w: no key to select", ErrKeyNotFound)
+	}
+	printKeyChoices(os.Stdout, stored)
+	choice, err := promptChoice("select a key: ", len(stored))
+	if err != nil {
+		return StoredKey{}, nil, err
+	}
+	key, err := stored[choice].Key()
+	return stored[choice], key, err
+}
+
+// promptChoice reads an index in [0, n), prompting again on invalid entries.
+// An empty line, q or the end of input cancel the choice with ErrCancelled.
+func promptChoice(prompt string, n int) (int, error) {
+	for {
+		line, err := promptLine(prompt)
+		if errors.Is(err, ErrInputRequired) {
+			return 0, err
+		}
+		if errors.Is(err, io.EOF) {
+			fmt.Println()
+			return 0, ErrCancelled
 		}
-		defer row.Close()
-		var pubKey string
-		row.Next()
-		err = row.Scan(&pubKey)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
+			return 0, parseError("failed to read choice: %w", err)
+		}
+		if line == "" || line == "q" {
+			return 0, ErrCancelled
 		}
-		key, err := crypto.NewKeyFromReader(bytes.NewReader([]byte(pubKey)))
-		return key, err
+		choice, err := strconv.Atoi(line)
+		if err == nil && choice >= 0 && choice < n {
+			return choice, nil
+		}
+		fmt.Printf("invalid choice '%s', enter a number between 0 and %d, or q to cancel\n", line, n-1)
 	}
+}
 
-	// Otherwise interactive mode
-	rows, err := db.Query(`SELECT fingerprint, pub_key FROM keys ORDER BY created_at DESC`)
+// printKeyChoices lists stored keys from their metadata alone
+func printKeyChoices(w io.Writer, stored []StoredKey) {
+	for i, k := range stored {
+		if k.Label != "" {
+			fmt.Fprintf(w, "[%d]: %s (%s)\n", i, k.Fingerprint, k.Label)
+		} else {
+			fmt.Fprintf(w, "[%d]: %s\n", i, k.Fingerprint)
+		}
+	}
+}
+
+func listKeys(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	format := fs.String("output-format", "", "table, csv or json")
+	since := fs.String("since", "", "only keys imported at or after this date (RFC3339, YYYY-MM-DD or relative like 7d)")
+	before := fs.String("before", "", "only keys imported before this date (RFC3339, YYYY-MM-DD or relative like 7d)")
+	sortBy := fs.String("sort", "created", "created (newest first), fingerprint or userid")
+	limit := fs.Int("limit", 0, "list at most this many keys, 0 for all")
+	offset := fs.Int("offset", 0, "skip this many keys")
+	countOnly := fs.Bool("count-only", false, "only print the number of matching keys")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *countOnly && (*limit != 0 || *offset != 0) {
+		return errors.New("--count-only cannot be combined with --limit or --offset")
+	}
+	f, err := outputFormat(*format)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query keys: %v", err)
+		return err
 	}
-	defer rows.Close()
-	var keys []*crypto.Key
-	var i int
-	for rows.Next() {
-		var fingerprint, pubKey string
-		err := rows.Scan(&fingerprint, &pubKey)
+	q := KeyQuery{Sort: *sortBy, Limit: *limit, Offset: *offset}
+	now := time.Now()
+	if q.Since, err = parseDate(*since, now); err != nil {
+		return err
+	}
+	if q.Before, err = parseDate(*before, now); err != nil {
+		return err
+	}
+	if *countOnly {
+		n, err := store.Count(q)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
+			return err
 		}
-		key, err := crypto.NewKeyFromReader(bytes.NewReader([]byte(pubKey)))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse key: %v", err)
+		fmt.Println(n)
+		return nil
+	}
+	stored, err := store.List(q)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(stored))
+	for _, k := range stored {
+		rows = append(rows, []string{k.Fingerprint, k.UserID, k.CreatedAt.Format(time.RFC3339), k.Label, k.Trust})
+	}
+	return writeRecords(os.Stdout, f, []string{"fingerprint", "user_id", "created_at", "label", "trust"}, rows)
+}
+
+// parseDate reads an absolute (RFC3339 or YYYY-MM-DD) or relative date, a
+// relative one like 7d or 12h is that long before now, empty is the zero time
+func parseDate(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		if n, err := strconv.Atoi(value[:len(value)-1]); err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * unit), nil
 		}
-		keys = append(keys, key)
-		fmt.Printf("[%d]: %s\n", i, fingerprint)
-		i++
+	} else if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, parseError("invalid date '%s', expected RFC3339, YYYY-MM-DD or a relative form like 7d", value)
+}
+
+func showKey(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	format := fs.String("output-format", "", "table, csv or json")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa show [--output-format table|csv|json] <key-id>")
+	}
+	f, err := outputFormat(*format)
+	if err != nil {
+		return err
+	}
+	stored, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+	key, err := stored.Key()
+	if err != nil {
+		return err
+	}
+	row := []string{
+		stored.Fingerprint,
+		key.GetHexKeyID(),
+		strings.Join(sortedUserIDs(key), "; "),
+		stored.CreatedAt.Format(time.RFC3339),
+		stored.Label,
+		strconv.FormatBool(stored.Card),
+		stored.Trust,
+		strconv.FormatBool(stored.Revoked),
+		strings.ReplaceAll(stored.ArmorHeaders, "\n", "; "),
+		stored.Origin,
 	}
+	return writeRecords(os.Stdout, f, []string{"fingerprint", "key_id", "user_ids", "created_at", "label", "card", "trust", "revoked", "armor_headers", "origin"}, [][]string{row})
+}
+
+// sortedUserIDs returns the user ids of key in alphabetical order
+func sortedUserIDs(key *crypto.Key) []string {
+	var userIDs []string
+	for _, identity := range key.GetEntity().Identities {
+		userIDs = append(userIDs, identity.Name)
+	}
+	sort.Strings(userIDs)
+	return userIDs
+}
+
+func labelKey(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: pgp-mfa label <key-id> <text>")
+	}
+	return store.Update(args[0], func(info *KeyInfo) {
+		info.Label = args[1]
+	})
+}
+
+func deleteKey(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa delete <key-id>")
+	}
+	return removeKey(args[0])
+}
+
+// removeKey deletes a stored key along with its totp secret, and unsets it
+// as the default key
+func removeKey(fingerprint string) error {
+	stored, err := store.Get(fingerprint)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(fingerprint); err != nil {
+		return err
+	}
+	// Fallback secrets are useless without the key
+	if _, err := db.Exec(`DELETE FROM totp WHERE tenant = ? AND fingerprint IN (?, ?)`, activeTenantIDs(fingerprint)...); err != nil {
+		return dbError("totp delete error: %w", err)
+	}
+	if _, err := db.Exec(`DELETE FROM settings WHERE name = ? AND value = ?`, defaultKeySetting(), []byte(stored.Fingerprint)); err != nil {
+		return dbError("failed to unset the default key: %w", err)
+	}
+	slog.Info("key deleted successfully!", "event", "deleted", "fingerprint", strings.ToLower(fingerprint))
+	return nil
+}
 
-	// Prompt user to select a key
-	fmt.Print("select a key: ")
-	var choice int
-	if _, err := fmt.Scanf("%d", &choice); err != nil {
-		return nil, fmt.Errorf("failed to read choice: %v", err)
+func pruneKeys(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the keys that would be removed")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
 	}
-	if choice < 0 || choice >= len(keys) {
-		return nil, errors.New("invalid choice")
+	stored, err := store.List(KeyQuery{})
+	if err != nil {
+		return err
 	}
-	return keys[choice], nil
+	now := time.Now().Unix()
+	pruned := 0
+	for _, k := range stored {
+		key, err := k.Key()
+		if err != nil {
+			return err
+		}
+		// Keys without expiry never expire
+		if !key.IsExpired(now) {
+			continue
+		}
+		if *dryRun {
+			fmt.Println("would remove", k.Fingerprint)
+		} else if err := removeKey(k.Fingerprint); err != nil {
+			return err
+		}
+		pruned++
+	}
+	if *dryRun {
+		fmt.Printf("%d expired key(s) would be removed\n", pruned)
+	} else {
+		fmt.Printf("%d expired key(s) removed\n", pruned)
+	}
+	return nil
 }
 
-func generateChallenge(length int) ([]byte, error) {
+func generateChallenge(rnd io.Reader, length int, charset string) ([]byte, error) {
 	buffer := make([]byte, length)
-	_, err := rand.Read(buffer)
+	_, err := io.ReadFull(rnd, buffer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate challenge: %v", err)
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
 	}
 	for i := 0; i < length; i++ {
-		buffer[i] = challengeCharset[buffer[i]%byte(len(challengeCharset))]
+		buffer[i] = charset[buffer[i]%byte(len(charset))]
 	}
 	return buffer, nil
 }
 
-func encryptChallenge(key *crypto.Key, challenge []byte) ([]byte, string, error) {
-	pgpCtx, err := crypto.PGP().Encryption().Recipient(key).New()
+// keyUserID returns the user id of key matching uid, either the full user id
+// or its email address
+func keyUserID(key *crypto.Key, uid string) (string, error) {
+	for name, identity := range key.GetEntity().Identities {
+		if name == uid || identity.UserId != nil && strings.EqualFold(identity.UserId.Email, uid) {
+			return name, nil
+		}
+	}
+	return "", policyError("%w: %s", ErrUserIDNotFound, uid)
+}
+
+// selectSubkey returns a copy of key whose only subkey is the one matching
+// keyID (16 hex chars key id or full fingerprint), so that it is the one
+// picked as encryption recipient
+func selectSubkey(key *crypto.Key, keyID string) (*crypto.Key, error) {
+	keyID = strings.TrimPrefix(strings.ToLower(keyID), "0x")
+	entity := key.GetEntity()
+	for _, subkey := range entity.Subkeys {
+		pub := subkey.PublicKey
+		if strings.ToLower(pub.KeyIdString()) != keyID && hex.EncodeToString(pub.Fingerprint) != keyID {
+			continue
+		}
+		sig, err := subkey.Verify(time.Now(), nil)
+		if err != nil {
+			return nil, policyError("%w: %w", ErrSubkeyNoEncrypt, err)
+		}
+		if !pub.PubKeyAlgo.CanEncrypt() || !sig.FlagsValid || !(sig.FlagEncryptCommunications || sig.FlagEncryptStorage) {
+			return nil, policyError("%w: %s", ErrSubkeyNoEncrypt, keyID)
+		}
+		restricted := *entity
+		restricted.Subkeys = append(entity.Subkeys[:0:0], subkey)
+		return crypto.NewKeyFromEntity(&restricted)
+	}
+	return nil, policyError("%w: %s", ErrSubkeyNotFound, keyID)
+}
+
+// loadSigningKey reads a private key used to sign challenges, prompting for
+// its passphrase when it is locked
+func loadSigningKey(path string) (*crypto.Key, error) {
+	keyFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOpenFailed, err)
+	}
+	defer keyFile.Close()
+	key, err := parseKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !key.IsPrivate() {
+		return nil, policyError("%w", ErrKeyNotPriv)
+	}
+	locked, err := key.IsLocked()
+	if err != nil {
+		return nil, parseError("failed to check signing key lock: %w", err)
+	}
+	if !locked {
+		return key, nil
+	}
+	passphrase, err := readPassphrase("signing key passphrase")
+	if err != nil {
+		return nil, err
+	}
+	unlocked, err := key.Unlock(passphrase)
+	if err != nil {
+		return nil, policyError("failed to unlock signing key: %w", err)
+	}
+	return unlocked, nil
+}
+
+// encryptChallenge encrypts challenge to key, signed by signingKey when not
+// nil, and returns it with its armored form carrying comment as a header when
+// not empty
+func encryptChallenge(key *crypto.Key, challenge []byte, signingKey *crypto.Key, comment string) ([]byte, string, error) {
+	pgpCtx, err := newEncryptionHandle(key, signingKey)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create pgp context: %v", err)
+		return nil, "", err
 	}
+	return encryptWith(pgpCtx, challenge, comment)
+}
+
+func newEncryptionHandle(key, signingKey *crypto.Key) (crypto.PGPEncryption, error) {
+	builder := crypto.PGP().Encryption().Recipient(key)
+	if signingKey != nil {
+		builder = builder.SigningKey(signingKey)
+	}
+	pgpCtx, err := builder.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pgp context: %w", err)
+	}
+	return pgpCtx, nil
+}
+
+// encryptWith encrypts and armors a challenge with an existing handle
+func encryptWith(pgpCtx crypto.PGPEncryption, challenge []byte, comment string) ([]byte, string, error) {
 	encrypted, err := pgpCtx.Encrypt(challenge)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to encrypt challenge: %v", err)
+		return nil, "", fmt.Errorf("failed to encrypt challenge: %w", err)
+	}
+	var armored string
+	if comment != "" {
+		armored, err = encrypted.ArmorWithCustomHeaders(comment, "")
+	} else {
+		armored, err = encrypted.Armor()
 	}
-	armored, err := encrypted.Armor()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to armor challenge: %v", err)
+		return nil, "", fmt.Errorf("failed to armor challenge: %w", err)
 	}
 	return encrypted.Bytes(), armored, nil
 }
 
 func challenge(args []string) error {
-	if len(args) < 1 {
-		return errors.New("usage: pgp-mfa challenge <length> [key-id]")
+	fs := flag.NewFlagSet("challenge", flag.ContinueOnError)
+	subkeyID := fs.String("subkey", "", "encrypt to this specific encryption subkey")
+	toClipboard := fs.Bool("copy-to-clipboard", false, "copy the armored challenge to the clipboard")
+	allowTOTP := fs.Bool("allow-totp", false, "also accept the key's totp code as a fallback solution")
+	signWith := fs.String("sign-with", "", "private key file used to sign the challenge")
+	deliver := fs.String("deliver", "", "shell command receiving the armored challenge on its stdin")
+	minEntropy := fs.Float64("min-entropy", MinChallengeEntropy, "warn when the challenge entropy is below this many bits")
+	rateBurst := fs.Int("rate-limit", ChallengeRateBurst, "challenges that can be issued in a burst for a key, 0 to disable")
+	rateWindow := fs.Duration("rate-window", ChallengeRateWindow, "time needed to fully refill the rate limit")
+	printPath := fs.Bool("print-path", false, "only print the challenge file path on stdout and keep the file")
+	comment := fs.String("comment", "", "armor header comment identifying the challenge (id, label...)")
+	tmpDir := fs.String("tmpdir", "", "directory of the challenge file, defaults to a user private one")
+	receiptKeyPath := fs.String("receipt-key", "", "private key file signing a receipt once the challenge is solved")
+	selfSolve := fs.String("self-solve", "", "testing only: decrypt and submit the solution with this private key file")
+	hintTemplate := fs.String("solve-hint", defaultSolveHint, "solve command printed for the challenge file, {file} is replaced by its path")
+	minTrustFlag := fs.String("min-trust", "", "only challenge keys trusted at least this much (none, unknown, marginal, full, ultimate)")
+	recipientArmored := fs.String("recipient-armored", "", "armored public key to challenge instead of a stored one")
+	fetch := fs.String("fetch", "", "fingerprint of a key to fetch from the keyserver and challenge without storing it")
+	keyserver := fs.String("keyserver", defaultKeyserver, "VKS keyserver --fetch downloads keys from")
+	noFile := fs.Bool("no-file", false, "never write the challenge to disk, only print it")
+	wrap := fs.String("wrap", "", "delimit the challenge as <prefix>{...} to ease copy-paste, e.g. PGPMFA")
+	quiet := fs.Bool("quiet", false, "no encrypting status on stderr for slow keys")
+	caseInsensitive := fs.Bool("case-insensitive", false, "accept solutions whatever their case, lowering the entropy")
+	structured := fs.Bool("structured", false, "encrypt a json object with the nonce to answer, the expiry and the challenge id")
+	checksum := fs.Bool("checksum", false, "append a check character telling typos apart from wrong solutions")
+	binary := fs.Bool("binary", false, "write the challenge as a binary OpenPGP message to stdout, not armored")
+	force := fs.Bool("force", false, "write --binary challenges to stdout even when it is a terminal")
+	keep := fs.Int("keep", 0, "only keep the n most recent unsolved challenges of the key, older ones can no longer be solved, 0 keeps them all")
+	logAttempts := fs.Bool("log-attempts", false, "log every failed solution attempt with the challenge id, never the attempted solution")
+	size := fs.String("size", "", "named challenge length instead of the length argument: small, medium or large")
+	bits := fs.Float64("bits", 0, "entropy target in bits, picks the shortest length reaching it")
+	unit := fs.String("unit", unitChars, "unit of the length argument: chars, or bytes of entropy")
+	qr := fs.Bool("qr", false, "also render the armored challenge as a qr code, needs qrencode")
+	timeoutAction := fs.String("timeout-action", timeoutActionError, "on expiry, error out or reissue a fresh challenge and keep solving")
+	solveTime := fs.Duration("timeout", ChallengeSolveTime, "time left to solve the challenge")
+	withPassword := fs.Bool("symmetric-password", false, "prompt for a password also required to decrypt the challenge")
+	userIDFlag := fs.String("user-id", "", "user id (or its email) of the key the challenge is meant for, shown and put in the receipt")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *printPath && (*deliver != "" || *toClipboard) {
+		return errors.New("--print-path cannot be combined with --deliver or --copy-to-clipboard")
+	}
+	if *noFile && (*printPath || *tmpDir != "") {
+		return errors.New("--no-file cannot be combined with --print-path or --tmpdir")
+	}
+	if _, err := parseTimeoutAction(*timeoutAction); err != nil {
+		return err
+	}
+	if *keep < 0 {
+		return parseError("--keep cannot be negative")
+	}
+	if *rateWindow <= 0 {
+		return parseError("--rate-window must be positive")
 	}
-	length, _ := strconv.Atoi(args[0])
-	if length <= 0 || length > 512 {
-		return ErrChallengeLength
+	if *binary && (*printPath || *deliver != "" || *toClipboard || *qr || *tmpDir != "") {
+		return errors.New("--binary cannot be combined with --print-path, --deliver, --copy-to-clipboard, --qr or --tmpdir")
 	}
-	if (length & (length - 1)) != 0 {
-		return ErrChallengePow
+	if *binary {
+		if err := checkBinaryOutput(term.IsTerminal(int(os.Stdout.Fd())), *force); err != nil {
+			return err
+		}
+	}
+	// With --print-path or --binary, stdout is reserved to the path or the
+	// message for wrappers to read
+	var out io.Writer = os.Stdout
+	if *printPath || *binary {
+		out = os.Stderr
+	}
+	// --bits targets the entropy solutions are actually checked with
+	charset := challengeCharset
+	if *caseInsensitive {
+		charset = foldCase(charset)
+	}
+	length, args, err := challengeLength(args, *size, *bits, *unit, charset)
+	if err != nil {
+		return err
+	}
+	// Fail before selecting a key, 0 is left to the key default
+	if length != 0 {
+		if err := defaultChallengeConfig(length).validate(); err != nil {
+			return err
+		}
+	}
+	minTrust := ""
+	if *minTrustFlag != "" {
+		if minTrust, err = parseTrust(*minTrustFlag); err != nil {
+			return err
+		}
 	}
 	fingerprint := ""
-	if len(args) > 1 {
-		fingerprint = args[1]
+	if len(args) > 0 {
+		fingerprint = args[0]
+	}
+	var stored StoredKey
+	var selectedKey *crypto.Key
+	switch {
+	case *fetch != "" && (fingerprint != "" || *recipientArmored != ""):
+		return errors.New("--fetch cannot be combined with a key-id or --recipient-armored")
+	case *recipientArmored != "" && fingerprint != "":
+		return errors.New("--recipient-armored cannot be combined with a key-id")
+	case *recipientArmored != "":
+		stored, selectedKey, err = inlineRecipient(strings.NewReader(*recipientArmored))
+	case *fetch != "":
+		stored, selectedKey, err = fetchRecipient(out, *keyserver, *fetch)
+	default:
+		// The default key spares the selection prompt
+		if fingerprint == "" {
+			if fingerprint, err = defaultKey(); err != nil {
+				return err
+			}
+		}
+		stored, selectedKey, err = getKey(fingerprint, KeyQuery{MinTrust: minTrust})
 	}
-	selectedKey, err := getKey(fingerprint)
 	if err != nil {
 		return err
 	}
+	if !trustAtLeast(stored.Trust, minTrust) {
+		return policyError("%w: %s is %s", ErrInsufficientTrust, stored.Fingerprint, stored.Trust)
+	}
+	if stored.Expired || selectedKey.IsExpired(time.Now().Unix()) {
+		return policyError("%w: %s", ErrChallengeKeyExp, stored.Fingerprint)
+	}
+	if stored.Revoked {
+		return policyError("%w: %s", ErrKeyRevoked, stored.Fingerprint)
+	}
+	// Flags always win over the defaults stored with the key
+	explicit := setFlags(fs)
+	if length == 0 {
+		length = stored.Defaults.Length
+	}
+	if length == 0 {
+		return fmt.Errorf("usage: pgp-mfa challenge [flags] <length> [key-id], %s has no default length", stored.Fingerprint)
+	}
+	cfg := defaultChallengeConfig(length)
+	cfg.Comment = *comment
+	cfg.Wrap = *wrap
+	cfg.Structured = *structured
+	cfg.CaseInsensitive = *caseInsensitive
+	cfg.Checksum = *checksum
+	cfg.SolveTime = *solveTime
+	if !explicit["timeout"] && stored.Defaults.SolveTime != 0 {
+		cfg.SolveTime = stored.Defaults.SolveTime
+	}
+	if !explicit["timeout-action"] && stored.Defaults.TimeoutAction != "" {
+		*timeoutAction = stored.Defaults.TimeoutAction
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	// The password is shared with the solver out of band
+	decryptCmd := "gpg -dq --batch"
+	if *withPassword {
+		if cfg.Password, err = promptNewPassword("challenge password"); err != nil {
+			return err
+		}
+		decryptCmd += " | gpg -dq"
+		if !explicit["solve-hint"] {
+			*hintTemplate = passwordSolveHint
+		}
+	}
+	userID := ""
+	if *userIDFlag != "" {
+		if userID, err = keyUserID(selectedKey, *userIDFlag); err != nil {
+			return err
+		}
+	}
+	if *subkeyID != "" {
+		selectedKey, err = selectSubkey(selectedKey, *subkeyID)
+		if err != nil {
+			return err
+		}
+	}
 
-	challengeBytes, err := generateChallenge(length)
-	if err != nil {
+	if err := takeToken(selectedKey.GetFingerprint(), *rateBurst, *rateWindow, time.Now()); err != nil {
 		return err
 	}
-	_, armored, err := encryptChallenge(selectedKey, challengeBytes)
+
+	var totp *totpFallback
+	if *allowTOTP {
+		totp, err = newTOTPFallback(selectedKey.GetFingerprint())
+		if err != nil {
+			return err
+		}
+	}
+
+	if *signWith != "" {
+		cfg.SigningKey, err = loadSigningKey(*signWith)
+		if err != nil {
+			return err
+		}
+		defer cfg.SigningKey.ClearPrivateParams()
+	}
+	var solverKey *crypto.Key
+	if *selfSolve != "" {
+		fmt.Fprintln(os.Stderr, colorize(os.Stderr, colorYellow, "WARNING: --self-solve answers the challenge with the recipient's private key, this defeats the purpose of MFA, only use it for testing"))
+		solverKey, err = loadSigningKey(*selfSolve)
+		if err != nil {
+			return err
+		}
+		defer solverKey.ClearPrivateParams()
+	}
+	var receiptKey *crypto.Key
+	if *receiptKeyPath != "" {
+		receiptKey, err = loadSigningKey(*receiptKeyPath)
+		if err != nil {
+			return err
+		}
+		defer receiptKey.ClearPrivateParams()
+	}
+
+	if !*quiet && term.IsTerminal(int(os.Stderr.Fd())) && slowEncryption(selectedKey, cfg.Length) {
+		fmt.Fprintln(os.Stderr, "encrypting…")
+	}
+	issued, err := issueChallenge(selectedKey, cfg, time.Now())
 	if err != nil {
 		return err
 	}
-	exp := time.Now().Add(ChallengeSolveTime)
-	tempFile, err := os.CreateTemp("", "pgp-mfa-challenge-")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %v", err)
+	if err := recordChallenge(selectedKey.GetFingerprint(), issued, *keep); err != nil {
+		return err
+	}
+	challengeBytes, armored, exp := issued.Solution, issued.Armored, issued.ExpiresAt
+	if userID != "" {
+		fmt.Fprintln(out, "challenge for", userID)
+	}
+	if stored.Card {
+		fmt.Fprintln(out, "this key lives on a smartcard, insert it and check it is detected with: gpg --card-status")
+	}
+	delivered := false
+	challengePath := "" // the challenge file removed once solved, if any
+	if *binary {
+		if _, err := os.Stdout.Write(issued.Encrypted); err != nil {
+			return fmt.Errorf("failed to write challenge: %w", err)
+		}
+		delivered = true
+		fmt.Fprintln(out, "binary challenge written to stdout, solve it with:", decryptCmd)
+	} else if *deliver != "" {
+		if err := deliverChallenge(*deliver, armored); err != nil {
+			return err
+		}
+		delivered = true
+		fmt.Println("challenge delivered, solve it with:", decryptCmd)
+	} else if *toClipboard {
+		if err := copyToClipboard(armored); err != nil {
+			slog.Warn("failed to copy challenge to clipboard, falling back to file", "event", "clipboard", "error", err)
+		} else {
+			delivered = true
+			fmt.Printf("challenge copied to clipboard, solve with: %s, then paste it\n", decryptCmd)
+		}
 	}
-	defer tempFile.Close()
+	if !delivered && *noFile {
+		fmt.Println(armored)
+		fmt.Println("solve by piping the message above into:", decryptCmd)
+	} else if !delivered {
+		tempFile, err := createChallengeFile(*tmpDir)
+		if err != nil {
+			return err
+		}
+		defer tempFile.Close()
+
+		if *printPath {
+			// The caller owns the file from now on, it is not removed
+			if _, err := tempFile.WriteString(armored + "\n"); err != nil {
+				return fmt.Errorf("failed to write challenge file: %w", err)
+			}
+			path, err := filepath.Abs(tempFile.Name())
+			if err != nil {
+				return fmt.Errorf("failed to resolve challenge file path: %w", err)
+			}
+			fmt.Println(path)
+		} else {
+			writer := io.MultiWriter(tempFile, os.Stdout)
+			_, err = writer.Write([]byte(armored + "\n"))
+			if err == nil { // if writing in the tempfile succeeded, we can print the solve command
+				fmt.Println("solve with:", solveHint(*hintTemplate, tempFile.Name()))
+			}
+			challengePath = tempFile.Name()
 
-	writer := io.MultiWriter(tempFile, os.Stdout)
-	_, err = writer.Write([]byte(armored + "\n"))
-	if err == nil { // if writing in the tempfile succeeded, we can print the solve command
-		fmt.Println("solve with: gpg -dq --batch <", tempFile.Name())
+			defer func() {
+				os.Remove(tempFile.Name())
+			}()
+		}
+	}
+	if *qr {
+		if !term.IsTerminal(int(os.Stdout.Fd())) {
+			slog.Warn("not rendering the qr code, stdout is not a terminal", "event", "challenge_qr")
+		} else if code, err := renderQR(armored); err != nil {
+			slog.Warn("failed to render the qr code, use the armored challenge instead", "event", "challenge_qr", "error", err)
+		} else {
+			fmt.Fprint(out, code)
+		}
+	}
+	fmt.Fprintln(out, "challenge will expire at", exp.Format(time.RFC3339))
+	entropy := challengeEntropy(cfg.Length, cfg.solutionCharset())
+	fmt.Fprintf(out, "challenge entropy: %.1f bits (%.1f bytes)\n", entropy, entropy/8)
+	if cfg.CaseInsensitive {
+		slog.Warn("case-insensitive solutions lower the challenge entropy", "event", "challenge_entropy", "bits", entropy, "case_sensitive_bits", challengeEntropy(cfg.Length, cfg.Charset))
+	}
+	if entropy < *minEntropy {
+		slog.Warn("challenge entropy is below the recommended minimum", "event", "challenge_entropy", "bits", entropy, "min_bits", *minEntropy)
+	}
+	if cfg.Checksum {
+		fmt.Fprintln(out, "the last character of the challenge is a checksum, enter it too")
 	}
-	fmt.Println("challenge will expire at", exp.Format(time.RFC3339))
 
-	defer func() {
-		os.Remove(tempFile.Name())
-	}()
+	slog.Info("challenge issued", "event", "challenge_issued", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID, "expires_at", exp)
+	// In batch mode the solution is read once from stdin, without prompting
+	var input io.Reader = stdin
+	interactive := !batchMode && term.IsTerminal(int(os.Stdin.Fd()))
+	if solverKey != nil {
+		solution, err := decryptChallenge(issued.Encrypted, solverKey)
+		if err != nil {
+			return err
+		}
+		slog.Warn("challenge self-solved, testing only", "event", "challenge_self_solved", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID)
+		input, interactive = bytes.NewReader(append(solution, '\n')), false
+	}
+	// :show prints the same challenge again, rewriting its file if it was lost
+	show := func() error {
+		fmt.Fprintln(out, armored)
+		if challengePath == "" {
+			return nil
+		}
+		if err := os.WriteFile(challengePath, []byte(armored+"\n"), 0o600); err != nil {
+			return fmt.Errorf("failed to rewrite challenge file: %w", err)
+		}
+		fmt.Fprintln(out, "solve with:", solveHint(*hintTemplate, challengePath))
+		return nil
+	}
+	// A reissued challenge replaces the expired one, printed like :show does
+	var reissue func() ([]byte, time.Time, error)
+	if *timeoutAction == timeoutActionReissue && interactive {
+		reissue = func() ([]byte, time.Time, error) {
+			now := time.Now()
+			if err := takeToken(selectedKey.GetFingerprint(), *rateBurst, *rateWindow, now); err != nil {
+				return nil, time.Time{}, err
+			}
+			reissued, err := issueChallenge(selectedKey, cfg, now)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			if err := recordChallenge(selectedKey.GetFingerprint(), reissued, *keep); err != nil {
+				return nil, time.Time{}, err
+			}
+			issued, armored = reissued, reissued.Armored
+			slog.Info("challenge reissued", "event", "challenge_issued", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID, "expires_at", issued.ExpiresAt)
+			if err := show(); err != nil {
+				return nil, time.Time{}, err
+			}
+			fmt.Fprintln(out, "challenge will expire at", issued.ExpiresAt.Format(time.RFC3339))
+			return issued.Solution, issued.ExpiresAt, nil
+		}
+	}
+	// Failed attempts are logged for intrusion detection, without the guess
+	var attempted func(error)
+	if *logAttempts {
+		attempts := 0
+		attempted = func(err error) {
+			attempts++
+			slog.Warn("challenge attempt failed", "event", "challenge_attempt_failed", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID, "attempt", attempts, "error", err)
+		}
+	}
+	err = solveChallenge(input, out, interactive, challengeBytes, totp, exp, cfg, show, reissue, attempted)
+	if err != nil {
+		slog.Warn("challenge failed", "event", "challenge_failed", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID, "error", err)
+		return err
+	}
+	// A newer challenge of the key may have superseded this one, see --keep
+	if err := markChallengeSolved(issued.ID, time.Now()); err != nil {
+		slog.Warn("challenge failed", "event", "challenge_failed", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID, "error", err)
+		return err
+	}
+	slog.Info("challenge solved", "event", "challenge_solved", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID)
+	if receiptKey != nil {
+		signed, err := signReceipt(receipt{
+			ChallengeID: issued.ID,
+			Fingerprint: selectedKey.GetFingerprint(),
+			UserID:      userID,
+			IssuedAt:    issued.IssuedAt,
+			SolvedAt:    time.Now(),
+		}, receiptKey)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, signed)
+		slog.Info("challenge receipt", "event", "challenge_receipt", "fingerprint", selectedKey.GetFingerprint(), "challenge_id", issued.ID, "receipt", signed)
+	}
+	return nil
+}
 
-	// Read input from stdin
-	reader := bufio.NewReader(os.Stdin)
+// Timeout actions, what the solve loop does once the challenge expired
+const (
+	timeoutActionError   = "error"
+	timeoutActionReissue = "reissue"
+)
+
+// showCommand re-prints the challenge in the interactive solve loop, it can
+// never be a solution as challenge lengths are powers of two (plus one with a
+// checksum, a 4 character challenge would need the ":sho" draw)
+const showCommand = ":show"
+
+// solveChallenge reads solutions from r until one matches, prompts and
+// results are written to w. When the input is not a terminal (e.g. a piped
+// solution), a single line is read and compared without prompting.
+// Interactively, show is called when showCommand is entered, if not nil.
+// With totp, a code of the key is accepted too, once, until too many invalid
+// ones were entered.
+// Solutions may be entered wrapped with the cfg.Wrap prefix or bare, with
+// cfg.Structured a pasted challenge object counts as its nonce,
+// cfg.CaseInsensitive ignores the case of letters and with cfg.Checksum a
+// mistyped solution is reported as such. Once the challenge expired, reissue is called for a new solution and
+// expiry if not nil, otherwise solving fails. attempted, if not nil, is called
+// with the reason of every failed attempt, never with the attempted solution.
+func solveChallenge(r io.Reader, w io.Writer, interactive bool, challengeBytes []byte, totp *totpFallback, exp time.Time, cfg ChallengeConfig, show func() error, reissue func() ([]byte, time.Time, error), attempted func(err error)) error {
+	reader := bufio.NewReader(r)
 	for {
-		fmt.Print("enter your solution: ")
+		if interactive {
+			fmt.Fprint(w, "enter your solution: ")
+		}
 		input, err := reader.ReadString('\n')
-		if err != nil {
-			return fmt.Errorf("failed to read input: %v", err)
+		if err != nil && !(errors.Is(err, io.EOF) && len(input) > 0) {
+			return fmt.Errorf("failed to read input: %w", err)
 		}
-		if len(input) == 0 {
+		// A bare Enter is not an attempt
+		input = strings.TrimSpace(input)
+		if input == "" {
 			continue
 		}
 		// Check if the challenge has expired
 		if exp.Before(time.Now()) {
-			return errors.New("challenge has expired")
+			if reissue == nil {
+				return policyError("challenge has expired")
+			}
+			if challengeBytes, exp, err = reissue(); err != nil {
+				return err
+			}
+			fmt.Fprintln(w, "challenge expired, solve the new one above")
+			continue
 		}
-		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(input)), challengeBytes) == 1 {
-			fmt.Println("challenge solved!")
-			break
-		} else {
-			fmt.Println("incorrect!")
+		input = unwrapSolution(input, cfg.Wrap)
+		if cfg.Structured {
+			input = structuredNonce(input)
+		}
+		if interactive && show != nil && input == showCommand {
+			if err := show(); err != nil {
+				fmt.Fprintln(w, "failed to show the challenge:", err)
+			}
+			continue
+		}
+		// A rejected totp code goes on to be compared with the challenge,
+		// failing as any wrong solution
+		if totp != nil && !totp.disabled() && isTOTPCode(input) {
+			err := totp.use(input, time.Now())
+			if err == nil {
+				fmt.Fprintln(w, colorize(w, colorGreen, "challenge solved with totp fallback!"))
+				return nil
+			}
+			if !errors.Is(err, ErrTOTPInvalid) && !errors.Is(err, ErrTOTPReplayed) {
+				return err
+			}
+			if totp.disabled() && interactive {
+				fmt.Fprintln(w, colorize(w, colorYellow, "too many invalid totp codes, only the challenge solution is accepted now"))
+			}
 		}
+		if input, err = cfg.stripChecksum(input); err != nil {
+			if attempted != nil {
+				attempted(err)
+			}
+			if !interactive {
+				return parseError("%w", err)
+			}
+			fmt.Fprintln(w, colorize(w, colorYellow, "checksum mismatch, recheck your typing"))
+			continue
+		}
+		expected := challengeBytes
+		if cfg.CaseInsensitive {
+			input, expected = strings.ToLower(input), bytes.ToLower(challengeBytes)
+		}
+		if subtle.ConstantTimeCompare([]byte(input), expected) == 1 {
+			fmt.Fprintln(w, colorize(w, colorGreen, "challenge solved!"))
+			return nil
+		}
+		if attempted != nil {
+			attempted(ErrIncorrectSolution)
+		}
+		if !interactive {
+			return policyError("%w", ErrIncorrectSolution)
+		}
+		fmt.Fprintln(w, colorize(w, colorRed, "incorrect!"))
 	}
-	return nil
 }
 
 func main() {
-	defer db.Close()
-	if len(os.Args) < 2 {
+	global := flag.NewFlagSet("pgp-mfa", flag.ContinueOnError)
+	global.String("db", os.Getenv("PGP_MFA_DB"), "key store location, overrides --profile")
+	global.StringVar(&activeProfile, "profile", defaultProfile, "named key store under the data directory")
+	global.StringVar(&activeTenant, "tenant", defaultTenant, "only see and challenge the keys of this tenant")
+	global.IntVar(&passphraseFD, "passphrase-fd", -1, "read the database passphrase from this file descriptor")
+	global.IntVar(&MinChallengeLength, "min-length", MinChallengeLength, "refuse shorter challenges")
+	global.IntVar(&MaxChallengeLength, "max-length", MaxChallengeLength, "refuse longer challenges, 512 at most")
+	global.IntVar(&KeyFetchAttempts, "fetch-attempts", KeyFetchAttempts, "tries of key downloads failing transiently (5xx, timeouts)")
+	global.DurationVar(&KeyFetchBackoff, "fetch-backoff", KeyFetchBackoff, "delay before the first key download retry, doubled after each")
+	global.DurationVar(&CommandDeadline, "deadline", 0, "abort the command once it ran this long, prompts included")
+	logFormat := global.String("log-format", "text", "text or json")
+	global.BoolVar(&batchMode, "batch", false, "fail instead of prompting for input")
+	verbose := global.Bool("verbose", false, "also log debug records")
+	global.BoolVar(&jsonErrors, "json", false, "report errors as json objects with a category exit code")
+	global.BoolVar(&noColor, "no-color", false, "never color the output, as a non-empty $NO_COLOR")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(1)
+	}
+	// json logs are never colored
+	var logOutput io.Writer = os.Stderr
+	if *logFormat == "text" && useColor(os.Stderr) {
+		logOutput = colorLogWriter{os.Stderr}
+	}
+	if err := setupLogging(logOutput, *logFormat, *verbose); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if global.NArg() < 1 {
 		fmt.Println("usage: pgp-mfa <command> [args...], use 'pgp-mfa help' for more info")
 		os.Exit(1)
 	}
-	cmd := os.Args[1]
-	args := os.Args[2:]
+	cmd := canonicalCommand(global.Arg(0))
+	args := global.Args()[1:]
 	fn, ok := commands[cmd]
 	if !ok {
 		fmt.Printf("unknown command '%s'\n", cmd)
 		help(nil)
 		os.Exit(1)
 	}
-	err := fn(args)
+
+	dsn, err := resolveGlobals(global)
+	if err == nil {
+		err = validateTenant(activeTenant)
+	}
+	if err == nil {
+		err = validateLengthPolicy(MinChallengeLength, MaxChallengeLength)
+	}
+	if err == nil && (KeyFetchAttempts < 1 || KeyFetchBackoff < 0) {
+		err = parseError("--fetch-attempts must be at least 1 and --fetch-backoff cannot be negative")
+	}
+	if err == nil && CommandDeadline < 0 {
+		err = parseError("--deadline cannot be negative")
+	}
+	if err != nil {
+		logFatal(cmd, err)
+	}
+	// Opening the database is bounded too, it may wait on a lock
+	err = runWithDeadline(CommandDeadline, func() error {
+		var err error
+		if store, db, err = openStore(dsn); err != nil {
+			return err
+		}
+		err = fn(args)
+		db.Close()
+		return err
+	})
 	if err != nil {
-		log.Fatalf("error: %v", err)
+		logFatal(cmd, err)
 	}
 }
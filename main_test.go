@@ -1,9 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"github.com/ProtonMail/gopenpgp/v3/armor"
 	"github.com/ProtonMail/gopenpgp/v3/constants"
 	"github.com/ProtonMail/gopenpgp/v3/crypto"
 	"github.com/ProtonMail/gopenpgp/v3/profile"
@@ -30,10 +43,751 @@ var (
 	}
 )
 
+// useTestDB swaps the global stores for fresh in-memory ones
+func useTestDB(t testing.TB) *sql.DB {
+	t.Helper()
+	prevStore, prevDB, prevSalt := store, db, fingerprintSalt
+	s, conn, err := openStore("memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	store, db = s, conn
+	t.Cleanup(func() {
+		conn.Close()
+		store, db, fingerprintSalt = prevStore, prevDB, prevSalt
+	})
+	return conn
+}
+
+// writeKeyFile writes the armored public (or private) half of key to a temp file
+func writeKeyFile(t testing.TB, key *crypto.Key, private bool) string {
+	t.Helper()
+	var armored string
+	var err error
+	if private {
+		armored, err = key.Armor()
+	} else {
+		armored, err = key.GetArmoredPublicKey()
+	}
+	if err != nil {
+		t.Fatalf("failed to armor key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.asc")
+	if err := os.WriteFile(path, []byte(armored), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	return path
+}
+
+func TestKeyUserID(t *testing.T) {
+	key, err := crypto.PGP().KeyGeneration().
+		AddUserId("Alice", "alice@example.com").
+		AddUserId("Alice Work", "alice@work.example").
+		New().GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for uid, expected := range map[string]string{
+		"Alice Work <alice@work.example>": "Alice Work <alice@work.example>",
+		"ALICE@example.com":               "Alice <alice@example.com>",
+	} {
+		if got, err := keyUserID(key, uid); err != nil || got != expected {
+			t.Errorf("%s: got %q (%v), expected %q", uid, got, err, expected)
+		}
+	}
+	if _, err := keyUserID(key, "bob@example.com"); !errors.Is(err, ErrUserIDNotFound) {
+		t.Errorf("got %v, expected %v", err, ErrUserIDNotFound)
+	}
+}
+
+func TestSelectSubkey(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEd25519}
+	entity, err := openpgp.NewEntity("Test User", "", "test@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddEncryptionSubkey(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddSigningSubkey(config); err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := crypto.NewKeyFromEntity(entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := privKey.ToPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both encryption subkeys can be targeted, not only the preferred one
+	for _, i := range []int{0, 1} {
+		subkey := entity.Subkeys[i].PublicKey
+		selected, err := selectSubkey(key, "0x"+subkey.KeyIdString())
+		if err != nil {
+			t.Fatalf("failed to select subkey %s: %v", subkey.KeyIdString(), err)
+		}
+		encrypted, _, err := encryptChallenge(selected, []byte("challenge"), nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, _ := crypto.NewPGPMessage(encrypted).EncryptionKeyIDs()
+		if len(ids) != 1 || ids[0] != subkey.KeyId {
+			t.Errorf("expected message encrypted to %x, got %x", subkey.KeyId, ids)
+		}
+	}
+
+	_, err = selectSubkey(key, entity.Subkeys[2].PublicKey.KeyIdString())
+	if !errors.Is(err, ErrSubkeyNoEncrypt) {
+		t.Errorf("signing subkey: got %v, expected %v", err, ErrSubkeyNoEncrypt)
+	}
+	_, err = selectSubkey(key, key.GetHexKeyID())
+	if !errors.Is(err, ErrSubkeyNotFound) {
+		t.Errorf("primary key: got %v, expected %v", err, ErrSubkeyNotFound)
+	}
+}
+
+func TestImportPublicOnly(t *testing.T) {
+	useTestDB(t)
+	privFile := writeKeyFile(t, ecKey, true)
+	if err := importKey([]string{privFile}); !errors.Is(err, ErrKeyPriv) {
+		t.Fatalf("got %v, expected %v", err, ErrKeyPriv)
+	}
+	if err := importKey([]string{"--public-only", privFile}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(ecKey.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := stored.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.IsPrivate() {
+		t.Error("secret material was stored")
+	}
+}
+
+func TestImportBatch(t *testing.T) {
+	useTestDB(t)
+	garbage := filepath.Join(t.TempDir(), "garbage.asc")
+	if err := os.WriteFile(garbage, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ecFile, rsaFile := writeKeyFile(t, ecKey, false), writeKeyFile(t, rsa3072Key, false)
+
+	err := importKey([]string{"--atomic", ecFile, garbage, rsaFile})
+	if !errors.Is(err, ErrFailedRead) || !strings.Contains(err.Error(), garbage) {
+		t.Errorf("got %v, expected the failing file to be reported", err)
+	}
+	if keys, _ := store.List(KeyQuery{}); len(keys) != 0 {
+		t.Errorf("atomic batch partially imported %d keys", len(keys))
+	}
+
+	err = importKey([]string{ecFile, garbage, rsaFile})
+	if !errors.Is(err, ErrFailedRead) {
+		t.Errorf("got %v, expected %v", err, ErrFailedRead)
+	}
+	if keys, _ := store.List(KeyQuery{}); len(keys) != 2 {
+		t.Errorf("best effort batch imported %d keys, expected 2", len(keys))
+	}
+}
+
+func TestKeyLabel(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{"--label", "laptop yubikey", writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(ecKey.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Label != "laptop yubikey" {
+		t.Errorf("got label %q", stored.Label)
+	}
+	if err := labelKey([]string{ecKey.GetFingerprint(), "desktop"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = store.Get(ecKey.GetFingerprint()); stored.Label != "desktop" {
+		t.Errorf("got label %q after update", stored.Label)
+	}
+	if err := labelKey([]string{"0000", "desktop"}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v, expected %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestImportCard(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{"--card", writeKeyFile(t, ecKey, false), writeKeyFile(t, rsa3072Key, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := store.Get(ecKey.GetFingerprint()); err != nil || !stored.Card {
+		t.Errorf("got %+v (%v), expected a smartcard key", stored.KeyInfo, err)
+	}
+	err := store.Update(rsa3072Key.GetFingerprint(), func(info *KeyInfo) {
+		info.Card = false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.Get(rsa3072Key.GetFingerprint()); stored.Card {
+		t.Error("card flag kept after update")
+	}
+}
+
+// expiredKey returns a public key created two hours ago that expired after one
+func expiredKey(t *testing.T) *crypto.Key {
+	t.Helper()
+	config := &packet.Config{
+		Algorithm:       packet.PubKeyAlgoEd25519,
+		KeyLifetimeSecs: 3600,
+		Time:            func() time.Time { return time.Now().Add(-2 * time.Hour) },
+	}
+	entity, err := openpgp.NewEntity("Expired User", "", "expired@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := crypto.NewKeyFromEntity(entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := privKey.ToPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.IsExpired(time.Now().Unix()) {
+		t.Fatal("fixture key is not expired")
+	}
+	return key
+}
+
+func TestImportAllowExpired(t *testing.T) {
+	useTestDB(t)
+	key := expiredKey(t)
+	path := writeKeyFile(t, key, false)
+	if err := importKey([]string{path}); !errors.Is(err, ErrKeyExp) {
+		t.Fatalf("got %v, expected %v", err, ErrKeyExp)
+	}
+	if err := importKey([]string{"--allow-expired", path}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(key.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Expired {
+		t.Error("expired flag not stored")
+	}
+	err = challenge([]string{"16", key.GetFingerprint()})
+	if !errors.Is(err, ErrPolicy) || !errors.Is(err, ErrChallengeKeyExp) {
+		t.Errorf("challenge: got %v, expected %v", err, ErrChallengeKeyExp)
+	}
+
+	// Valid keys are never flagged
+	if err := importKey([]string{"--allow-expired", writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.Get(ecKey.GetFingerprint()); stored.Expired {
+		t.Error("valid key flagged as expired")
+	}
+}
+
+func TestImportMangledSelfSignature(t *testing.T) {
+	useTestDB(t)
+	entity, err := openpgp.NewEntity("Mangled User", "", "mangled@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEd25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range entity.Identities {
+		for _, sig := range identity.SelfCertifications {
+			sig.Packet.EdSig[0] ^= 0xff
+		}
+	}
+	var buf bytes.Buffer
+	if err := entity.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// Parsed again, so that no verification result is cached
+	key, err := crypto.NewKey(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeKeyFile(t, key, false)
+	for _, args := range [][]string{{path}, {"--allow-expired", path}} {
+		if err := importKey(args); !errors.Is(err, ErrKeySelfSig) || !errors.Is(err, ErrPolicy) {
+			t.Errorf("%v: got %v, expected %v", args, err, ErrKeySelfSig)
+		}
+	}
+	if _, err := store.Get(key.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("mangled key stored: %v", err)
+	}
+}
+
+// TestImportKeepsCertifications makes sure third-party certifications
+// survive the import, for web of trust checks on the stored key
+func TestImportKeepsCertifications(t *testing.T) {
+	useTestDB(t)
+	binary, err := ecKey.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certified, err := crypto.NewKey(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := primaryUserID(certified)
+	for _, signer := range []*crypto.Key{rsa3072Key, rsa4092Key} {
+		if err := certified.GetEntity().SignIdentity(uid, signer.GetEntity(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := importKey([]string{writeKeyFile(t, certified, false)}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(certified.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := stored.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := key.GetEntity().Identities[uid]
+	if identity == nil {
+		t.Fatalf("user id %q not stored", uid)
+	}
+	if n := len(identity.OtherCertifications); n != 2 {
+		t.Errorf("got %d third-party certifications, expected 2", n)
+	}
+	if n := len(identity.SelfCertifications); n != 1 {
+		t.Errorf("got %d self certifications, expected 1", n)
+	}
+}
+
+// FuzzImportKey feeds arbitrary bytes to the parse and validate path of
+// import, it must never panic and always fail with a parse or policy error
+func FuzzImportKey(f *testing.F) {
+	for _, key := range []*crypto.Key{ecKey, rsa3072Key} {
+		armored, err := key.GetArmoredPublicKey()
+		if err != nil {
+			f.Fatal(err)
+		}
+		binary, err := key.GetPublicKey()
+		if err != nil {
+			f.Fatal(err)
+		}
+		private, err := key.Armor()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add([]byte(armored))
+		f.Add(binary)
+		f.Add([]byte(private))
+		f.Add(binary[:len(binary)/2])
+		f.Add([]byte("> " + strings.ReplaceAll(armored, "\n", "\n> ")))
+	}
+	f.Add([]byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n-----END PGP PUBLIC KEY BLOCK-----\n"))
+	f.Add([]byte{0x99, 0xff, 0xff})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		key, err := parseKey(bytes.NewReader(data))
+		if err != nil {
+			if !errors.Is(err, ErrParse) {
+				t.Errorf("unexpected error category: %v", err)
+			}
+			return
+		}
+		if key.IsPrivate() {
+			if key, err = key.ToPublic(); err != nil {
+				return
+			}
+		}
+		if err := validateKey(key); err != nil && !errors.Is(err, ErrPolicy) {
+			t.Errorf("unexpected error category: %v", err)
+		}
+		if err := checkCurvePolicy(key, curvePolicyReject); err != nil && !errors.Is(err, ErrPolicy) {
+			t.Errorf("unexpected error category: %v", err)
+		}
+		key.GetFingerprint()
+		primaryUserID(key)
+	})
+}
+
+func TestPruneKeys(t *testing.T) {
+	useTestDB(t)
+	key := expiredKey(t)
+	if err := importKey([]string{"--allow-expired", writeKeyFile(t, key, false), writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneKeys([]string{"--dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(key.GetFingerprint()); err != nil {
+		t.Errorf("dry run removed the expired key: %v", err)
+	}
+	if err := pruneKeys(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(key.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expired key kept: %v", err)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); err != nil {
+		t.Errorf("key without expiry pruned: %v", err)
+	}
+}
+
+func TestSelfSolve(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	privFile := writeKeyFile(t, ecKey, true)
+	err := challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", privFile, "16", ecKey.GetFingerprint()})
+	if err != nil {
+		t.Errorf("self-solved challenge failed: %v", err)
+	}
+	err = challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", privFile, "--wrap", "PGPMFA", "16", ecKey.GetFingerprint()})
+	if err != nil {
+		t.Errorf("self-solved wrapped challenge failed: %v", err)
+	}
+	err = challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", writeKeyFile(t, rsa3072Key, true), "16", ecKey.GetFingerprint()})
+	if err == nil {
+		t.Error("expected a wrong solver key to fail")
+	}
+}
+
+// decryptingWriter answers the challenge written to it with key, through
+// the solutions pipe
+type decryptingWriter struct {
+	buf       bytes.Buffer
+	key       *crypto.Key
+	solutions *io.PipeWriter
+}
+
+func (d *decryptingWriter) Write(p []byte) (int, error) {
+	d.buf.Write(p)
+	if i := strings.Index(d.buf.String(), "-----END PGP MESSAGE-----"); i >= 0 {
+		start := strings.Index(d.buf.String(), "-----BEGIN PGP MESSAGE-----")
+		encrypted, err := armor.Unarmor(d.buf.String()[start : i+len("-----END PGP MESSAGE-----")])
+		d.buf.Reset()
+		if err != nil {
+			return 0, err
+		}
+		solution, err := decryptChallenge(encrypted, d.key)
+		if err != nil {
+			solution = []byte("cannot decrypt")
+		}
+		go d.solutions.Write(append(solution, '\n'))
+	}
+	return len(p), nil
+}
+
+func TestVerifyDecrypt(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	if err := verifyDecrypt(ecKey, r, &decryptingWriter{key: ecKey, solutions: w}, false); err != nil {
+		t.Errorf("test challenge decrypted with the right key: %v", err)
+	}
+	if err := verifyDecrypt(ecKey, r, &decryptingWriter{key: rsa3072Key, solutions: w}, false); !errors.Is(err, ErrDecryptCheck) {
+		t.Errorf("wrong key: got %v, expected %v", err, ErrDecryptCheck)
+	}
+
+	useTestDB(t)
+	useTestStdin(t, "wrong\n")
+	if err := importKey([]string{"--verify-decrypt", writeKeyFile(t, ecKey, false)}); !errors.Is(err, ErrDecryptCheck) {
+		t.Errorf("import: got %v, expected %v", err, ErrDecryptCheck)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("key stored without solving the test challenge: %v", err)
+	}
+}
+
+func TestChallengeNoFile(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	err := challenge([]string{"--no-file", "--self-solve", writeKeyFile(t, ecKey, true), "16", ecKey.GetFingerprint()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(runtimeDir); len(entries) != 0 {
+		t.Errorf("--no-file wrote %d files", len(entries))
+	}
+	if err := challenge([]string{"--no-file", "--print-path", "16"}); err == nil {
+		t.Error("expected --no-file --print-path to be rejected")
+	}
+}
+
+func TestChallengeBinary(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	output, err := os.Create(filepath.Join(t.TempDir(), "challenge.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	prev := os.Stdout
+	os.Stdout = output
+	err = challenge([]string{"--binary", "--self-solve", writeKeyFile(t, ecKey, true), "16", ecKey.GetFingerprint()})
+	os.Stdout = prev
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := os.ReadFile(output.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decHandle, err := crypto.PGP().Decryption().DecryptionKey(ecKey).New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := decHandle.Decrypt(message, crypto.Bytes); err != nil || len(decrypted.Bytes()) != 16 {
+		t.Errorf("stdout is not the binary challenge: %v", err)
+	}
+	if entries, _ := os.ReadDir(runtimeDir); len(entries) != 0 {
+		t.Errorf("--binary wrote %d files", len(entries))
+	}
+	if err := challenge([]string{"--binary", "--print-path", "16"}); err == nil {
+		t.Error("expected --binary --print-path to be rejected")
+	}
+}
+
+func TestRecipientArmored(t *testing.T) {
+	useTestDB(t)
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = challenge([]string{"--tmpdir", t.TempDir(), "--self-solve", writeKeyFile(t, ecKey, true), "--recipient-armored", armored, "16"})
+	if err != nil {
+		t.Errorf("inline recipient challenge failed: %v", err)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("inline recipient was stored: %v", err)
+	}
+
+	privArmored, err := ecKey.Armor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := challenge([]string{"--recipient-armored", privArmored, "16"}); !errors.Is(err, ErrKeyPriv) {
+		t.Errorf("private key: got %v, expected %v", err, ErrKeyPriv)
+	}
+	if err := challenge([]string{"--recipient-armored", "not a key", "16"}); !errors.Is(err, ErrFailedRead) {
+		t.Errorf("garbage: got %v, expected %v", err, ErrFailedRead)
+	}
+}
+
+func TestSignedChallenge(t *testing.T) {
+	signingKey, err := loadSigningKey(writeKeyFile(t, ecKey, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSigningKey(writeKeyFile(t, ecKey, false)); !errors.Is(err, ErrKeyNotPriv) {
+		t.Errorf("public signing key: got %v, expected %v", err, ErrKeyNotPriv)
+	}
+
+	encrypted, _, err := encryptChallenge(rsa3072Key, []byte("challenge"), signingKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerPub, err := ecKey.ToPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decHandle, err := crypto.PGP().Decryption().DecryptionKey(rsa3072Key).VerificationKey(signerPub).New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := decHandle.Decrypt(encrypted, crypto.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Bytes()) != "challenge" {
+		t.Errorf("got %q after decryption", result.Bytes())
+	}
+	if err := result.SignatureError(); err != nil {
+		t.Errorf("signature did not verify: %v", err)
+	}
+}
+
+func TestChallengeComment(t *testing.T) {
+	encrypted, armored, err := encryptChallenge(ecKey, []byte("challenge"), nil, "challenge 42 for laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(armored, "\nComment: challenge 42 for laptop\n") {
+		t.Errorf("comment header missing from:\n%s", armored)
+	}
+	unarmored, err := armor.Unarmor(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unarmored, encrypted) {
+		t.Error("the comment altered the ciphertext")
+	}
+}
+
+func TestLogAttempts(t *testing.T) {
+	useTestDB(t)
+	prev, flags := slog.Default(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		setupLogging(os.Stderr, "text", false)
+		log.SetFlags(flags)
+	})
+	var logs bytes.Buffer
+	if err := setupLogging(&logs, "json", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	const guess = "n0tTheS0lution!!"
+	useTestStdin(t, guess+"\n")
+	err := challenge([]string{"--no-file", "--log-attempts", "16", ecKey.GetFingerprint()})
+	if !errors.Is(err, ErrIncorrectSolution) {
+		t.Fatalf("got %v, expected %v", err, ErrIncorrectSolution)
+	}
+	records, err := listChallenges(ecKey.GetFingerprint(), true, time.Now())
+	if err != nil || len(records) != 1 {
+		t.Fatalf("got %v (%v), expected one challenge", records, err)
+	}
+	if !strings.Contains(logs.String(), `"event":"challenge_attempt_failed"`) || !strings.Contains(logs.String(), `"challenge_id":"`+records[0].ID+`"`) {
+		t.Errorf("failed attempt not logged with its challenge id: %s", logs.String())
+	}
+	if strings.Contains(logs.String(), guess) {
+		t.Errorf("the attempted solution was logged: %s", logs.String())
+	}
+}
+
+func TestSolveChallenge(t *testing.T) {
+	solution := []byte("s3cr3t")
+	exp := time.Now().Add(time.Minute)
+
+	// Piped input, single line without trailing newline
+	if err := solveChallenge(strings.NewReader("s3cr3t"), io.Discard, false, solution, nil, exp, ChallengeConfig{}, nil, nil, nil); err != nil {
+		t.Errorf("piped solution rejected: %v", err)
+	}
+	err := solveChallenge(strings.NewReader("wrong\ns3cr3t\n"), io.Discard, false, solution, nil, exp, ChallengeConfig{}, nil, nil, nil)
+	if !errors.Is(err, ErrIncorrectSolution) {
+		t.Errorf("piped wrong solution: got %v, expected %v", err, ErrIncorrectSolution)
+	}
+
+	// Interactive input keeps reading until the right solution
+	if err := solveChallenge(strings.NewReader("wrong\ns3cr3t\n"), io.Discard, true, solution, nil, exp, ChallengeConfig{}, nil, nil, nil); err != nil {
+		t.Errorf("interactive solution rejected: %v", err)
+	}
+	err = solveChallenge(strings.NewReader("wrong\n"), io.Discard, true, solution, nil, exp, ChallengeConfig{}, nil, nil, nil)
+	if err == nil {
+		t.Error("expected an error once input is exhausted")
+	}
+
+	// Case-insensitive solutions only when asked for
+	if err := solveChallenge(strings.NewReader("S3cR3T\n"), io.Discard, false, []byte("s3Cr3t"), nil, exp, ChallengeConfig{CaseInsensitive: true}, nil, nil, nil); err != nil {
+		t.Errorf("case-insensitive solution rejected: %v", err)
+	}
+	err = solveChallenge(strings.NewReader("S3CR3T\n"), io.Discard, false, solution, nil, exp, ChallengeConfig{}, nil, nil, nil)
+	if !errors.Is(err, ErrIncorrectSolution) {
+		t.Errorf("case-sensitive by default: got %v, expected %v", err, ErrIncorrectSolution)
+	}
+
+	// Wrapped solutions are accepted with or without the delimiters
+	for _, input := range []string{"PGPMFA{s3cr3t}\n", "s3cr3t\n"} {
+		if err := solveChallenge(strings.NewReader(input), io.Discard, false, solution, nil, exp, ChallengeConfig{Wrap: "PGPMFA"}, nil, nil, nil); err != nil {
+			t.Errorf("%q: %v", input, err)
+		}
+	}
+	err = solveChallenge(strings.NewReader("OTHER{s3cr3t}\n"), io.Discard, false, solution, nil, exp, ChallengeConfig{Wrap: "PGPMFA"}, nil, nil, nil)
+	if !errors.Is(err, ErrIncorrectSolution) {
+		t.Errorf("wrong wrapper: got %v, expected %v", err, ErrIncorrectSolution)
+	}
+
+	// Checksummed solutions tell typos apart from wrong solutions
+	checksummed := ChallengeConfig{Charset: challengeCharset, Checksum: true}
+	withCheck := string(checksummed.appendChecksum(solution))
+	if err := solveChallenge(strings.NewReader(withCheck+"\n"), io.Discard, false, solution, nil, exp, checksummed, nil, nil, nil); err != nil {
+		t.Errorf("checksummed solution rejected: %v", err)
+	}
+	typo := "s3cr4t" + withCheck[len(withCheck)-1:]
+	if err := solveChallenge(strings.NewReader(typo+"\n"), io.Discard, false, solution, nil, exp, checksummed, nil, nil, nil); !errors.Is(err, ErrChecksum) {
+		t.Errorf("typo: got %v, expected %v", err, ErrChecksum)
+	}
+	var typed bytes.Buffer
+	if err := solveChallenge(strings.NewReader(typo+"\n"+withCheck+"\n"), &typed, true, solution, nil, exp, checksummed, nil, nil, nil); err != nil {
+		t.Errorf("solution after a typo rejected: %v", err)
+	}
+	if !strings.Contains(typed.String(), "recheck your typing") {
+		t.Errorf("typo not reported: %q", typed.String())
+	}
+
+	// Failed attempts are reported with their reason only
+	var reasons []error
+	attempted := func(err error) { reasons = append(reasons, err) }
+	if err := solveChallenge(strings.NewReader("wrong\n"+typo+"\n"+withCheck+"\n"), io.Discard, true, solution, nil, exp, checksummed, nil, nil, attempted); err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) != 2 || !errors.Is(reasons[0], ErrChecksum) || !errors.Is(reasons[1], ErrChecksum) {
+		t.Errorf("got %v, expected two checksum mismatches", reasons)
+	}
+	reasons = nil
+	solveChallenge(strings.NewReader("wrong\n"), io.Discard, false, solution, nil, exp, ChallengeConfig{}, nil, nil, attempted)
+	if len(reasons) != 1 || !errors.Is(reasons[0], ErrIncorrectSolution) {
+		t.Errorf("got %v, expected an incorrect solution", reasons)
+	}
+
+	// :show is handled interactively only, without consuming the session
+	shown := 0
+	show := func() error { shown++; return nil }
+	if err := solveChallenge(strings.NewReader(":show\n:show\ns3cr3t\n"), io.Discard, true, solution, nil, exp, ChallengeConfig{}, show, nil, nil); err != nil || shown != 2 {
+		t.Errorf("got %v after %d shows, expected success after 2", err, shown)
+	}
+	err = solveChallenge(strings.NewReader(":show\n"), io.Discard, false, solution, nil, exp, ChallengeConfig{}, show, nil, nil)
+	if !errors.Is(err, ErrIncorrectSolution) || shown != 2 {
+		t.Errorf("piped :show: got %v, expected %v", err, ErrIncorrectSolution)
+	}
+
+	err = solveChallenge(strings.NewReader("s3cr3t\n"), io.Discard, false, solution, nil, time.Now().Add(-time.Second), ChallengeConfig{}, nil, nil, nil)
+	if !errors.Is(err, ErrPolicy) {
+		t.Errorf("expired challenge: got %v, expected policy error", err)
+	}
+	// Blank lines are skipped without counting as wrong solutions
+	var prompts bytes.Buffer
+	if err := solveChallenge(strings.NewReader("\n  \ns3cr3t\n"), &prompts, true, solution, nil, exp, ChallengeConfig{}, nil, nil, nil); err != nil {
+		t.Errorf("blank lines: got %v, expected success", err)
+	}
+	if strings.Contains(prompts.String(), "incorrect") {
+		t.Errorf("blank lines counted as incorrect: %q", prompts.String())
+	}
+	if err := solveChallenge(strings.NewReader("\ns3cr3t\n"), io.Discard, false, solution, nil, exp, ChallengeConfig{}, nil, nil, nil); err != nil {
+		t.Errorf("piped blank line: got %v, expected success", err)
+	}
+
+	reissued := 0
+	reissue := func() ([]byte, time.Time, error) {
+		reissued++
+		return []byte("n3wer"), time.Now().Add(time.Minute), nil
+	}
+	err = solveChallenge(strings.NewReader("s3cr3t\ns3cr3t\nn3wer\n"), io.Discard, true, solution, nil, time.Now().Add(-time.Second), ChallengeConfig{}, nil, reissue, nil)
+	if err != nil || reissued != 1 {
+		t.Errorf("got %v after %d reissues, expected the new challenge solved after 1", err, reissued)
+	}
+}
+
 func benchmarkChallengeEncryption(b *testing.B, length int, key *crypto.Key) {
 	byteRef := chalMap[length]
 	for i := 0; i < b.N; i++ {
-		_, _, err := encryptChallenge(key, byteRef)
+		_, _, err := encryptChallenge(key, byteRef, nil, "")
 		if err != nil {
 			b.Fail()
 		}
@@ -114,7 +868,7 @@ func BenchmarkRsa3072_512(b *testing.B) {
 
 func createChallenges(b *testing.B, length int) {
 	for i := 0; i < b.N; i++ {
-		_, err := generateChallenge(length)
+		_, err := generateChallenge(rand.Reader, length, challengeCharset)
 		if err != nil {
 			log.Println(err)
 			b.Fail()
@@ -202,3 +956,110 @@ func init() {
 		}
 	}
 }
+
+// benchmarkKeySelection lists 100 stored keys and loads the last one, as the
+// interactive challenge does, either parsing every key or only the chosen one
+func benchmarkKeySelection(b *testing.B, parseAll bool) {
+	useTestDB(b)
+	keygen := crypto.PGP().KeyGeneration().AddUserId("test@example.com", "Test User").New()
+	for range 100 {
+		key, err := keygen.GenerateKey()
+		if err != nil {
+			b.Fatal(err)
+		}
+		public, err := key.ToPublic()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := store.Import(public, KeyInfo{CreatedAt: time.Now()}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for range b.N {
+		stored, err := store.List(KeyQuery{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if parseAll {
+			for _, k := range stored {
+				if _, err := k.Key(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		printKeyChoices(io.Discard, stored)
+		if _, err := stored[len(stored)-1].Key(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkKeySelectionParseAll_100(b *testing.B) {
+	benchmarkKeySelection(b, true)
+}
+
+func BenchmarkKeySelectionLazy_100(b *testing.B) {
+	benchmarkKeySelection(b, false)
+}
+
+func TestPreserveHeaders(t *testing.T) {
+	useTestDB(t)
+	armored, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.asc")
+	withHeaders := strings.Replace(armored, "-----\n", "-----\nComment: generated by test\n", 1)
+	if err := os.WriteFile(path, []byte(withHeaders), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(ecKey.GetFingerprint())
+	if err != nil || stored.ArmorHeaders != "" {
+		t.Errorf("headers stored by default: %q (%v)", stored.ArmorHeaders, err)
+	}
+	if err := deleteKey([]string{ecKey.GetFingerprint()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{"--preserve-headers", path}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = store.Get(ecKey.GetFingerprint())
+	if err != nil || !strings.HasSuffix(stored.ArmorHeaders, "Comment: generated by test") {
+		t.Errorf("headers not preserved: %q (%v)", stored.ArmorHeaders, err)
+	}
+	// The stored key itself never has them
+	if bytes.Contains(stored.PubKey, []byte("generated by test")) {
+		t.Error("armor headers found in the stored key")
+	}
+}
+
+func TestImportStdin(t *testing.T) {
+	useTestDB(t)
+	armored, err := os.ReadFile(writeKeyFile(t, ecKey, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stdin key is read first, along with the other files
+	useTestStdin(t, string(armored))
+	if err := importKey([]string{"--stdin", writeKeyFile(t, rsa3072Key, false)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []*crypto.Key{ecKey, rsa3072Key} {
+		if _, err := store.Get(key.GetFingerprint()); err != nil {
+			t.Errorf("%s: %v", key.GetFingerprint(), err)
+		}
+	}
+	// Piped keys leave no input to prompt with
+	useTestStdin(t, string(armored))
+	if err := importKey([]string{"--stdin", "--verify-decrypt"}); err == nil {
+		t.Error("expected --verify-decrypt to refuse a piped --stdin key")
+	}
+	useTestStdin(t, strings.Repeat("x", maxKeySize+1))
+	if err := importKey([]string{"--stdin"}); !errors.Is(err, ErrOpenFailed) {
+		t.Errorf("oversized key: got %v, expected %v", err, ErrOpenFailed)
+	}
+}
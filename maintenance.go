@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// maintenanceReport is what a maintenance run did
+type maintenanceReport struct {
+	Pruned     int64 // expired unsolved challenges removed
+	SizeBefore int64 // database size in bytes
+	SizeAfter  int64
+}
+
+// databaseSize returns the size in bytes of the database behind conn
+func databaseSize(conn *sql.DB) (int64, error) {
+	var pages, pageSize int64
+	if err := conn.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, dbError("failed to read the page count: %w", err)
+	}
+	if err := conn.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, dbError("failed to read the page size: %w", err)
+	}
+	return pages * pageSize, nil
+}
+
+// maintainDB prunes the challenges that expired unsolved before now, of
+// every tenant, then compacts the database and refreshes the query planner
+// statistics. Solved challenges are kept.
+func maintainDB(conn *sql.DB, now time.Time) (maintenanceReport, error) {
+	var report maintenanceReport
+	var err error
+	if report.SizeBefore, err = databaseSize(conn); err != nil {
+		return report, err
+	}
+	res, err := conn.Exec(`DELETE FROM challenges WHERE solved_at IS NULL AND expires_at < ?`, now.UTC())
+	if err != nil {
+		return report, dbError("failed to prune expired challenges: %w", err)
+	}
+	report.Pruned, _ = res.RowsAffected()
+	// VACUUM cannot run in a transaction, conn is the pool itself
+	if _, err := conn.Exec(`VACUUM`); err != nil {
+		return report, dbError("failed to vacuum the database: %w", err)
+	}
+	if _, err := conn.Exec(`PRAGMA optimize`); err != nil {
+		return report, dbError("failed to optimize the database: %w", err)
+	}
+	report.SizeAfter, err = databaseSize(conn)
+	return report, err
+}
+
+// maintenance runs the database housekeeping of long running deployments
+func maintenance(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa maintenance")
+	}
+	report, err := maintainDB(db, time.Now())
+	if err != nil {
+		return err
+	}
+	slog.Info("database maintained", "event", "maintenance", "pruned_challenges", report.Pruned, "size_before", report.SizeBefore, "size_after", report.SizeAfter)
+	fmt.Printf("%d expired challenge(s) pruned\n", report.Pruned)
+	fmt.Printf("database size: %d bytes, was %d (%d reclaimed)\n", report.SizeAfter, report.SizeBefore, report.SizeBefore-report.SizeAfter)
+	return nil
+}
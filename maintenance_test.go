@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMaintainDB(t *testing.T) {
+	prevStore, prevDB := store, db
+	t.Cleanup(func() { store, db = prevStore, prevDB })
+	s, conn, err := openStore(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store, db = s, conn
+
+	fingerprint := ecKey.GetFingerprint()
+	now := time.Now()
+	issue := func(issuedAt time.Time) *IssuedChallenge {
+		issued, err := issueChallenge(ecKey, defaultChallengeConfig(16), issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recordChallenge(fingerprint, issued, 0); err != nil {
+			t.Fatal(err)
+		}
+		return issued
+	}
+	// Enough expired challenges to free pages once pruned
+	for range 500 {
+		issue(now.Add(-2 * ChallengeSolveTime))
+	}
+	solved := issue(now.Add(-2 * ChallengeSolveTime))
+	if err := markChallengeSolved(solved.ID, now.Add(-ChallengeSolveTime-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	pending := issue(now)
+
+	report, err := maintainDB(conn, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pruned != 500 {
+		t.Errorf("got %d pruned challenges, expected 500", report.Pruned)
+	}
+	if report.SizeAfter >= report.SizeBefore {
+		t.Errorf("nothing reclaimed: %d bytes, was %d", report.SizeAfter, report.SizeBefore)
+	}
+	records, err := listChallenges(fingerprint, true, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, c := range records {
+		kept = append(kept, c.ID)
+	}
+	if len(kept) != 2 || !slices.Contains(kept, pending.ID) || !slices.Contains(kept, solved.ID) {
+		t.Errorf("got %v, expected the solved and pending challenges kept", kept)
+	}
+	if err := commands["vacuum"](nil); err != nil {
+		t.Errorf("vacuum alias: %v", err)
+	}
+}
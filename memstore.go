@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// memStore is an ephemeral KeyStore, mostly useful for tests. Like
+// sqliteStore it only sees the keys of its tenant.
+type memStore struct {
+	data   *memKeys
+	tenant string
+}
+
+// memKeys are the keys of every tenant, shared by the memStores of a database
+type memKeys struct {
+	mu   sync.RWMutex
+	keys map[memKey]StoredKey
+}
+
+type memKey struct {
+	tenant, fingerprint string
+}
+
+func newMemStore(tenant string) *memStore {
+	return &memStore{data: &memKeys{keys: make(map[memKey]StoredKey)}, tenant: tenant}
+}
+
+func (m *memStore) key(fingerprint string) memKey {
+	return memKey{m.tenant, strings.ToLower(fingerprint)}
+}
+
+func (m *memStore) Import(key *crypto.Key, info KeyInfo) error {
+	pubKey, err := key.GetPublicKey()
+	if err != nil {
+		return parseError("%w: %w", ErrPubKeyFail, err)
+	}
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	fingerprint := key.GetFingerprint()
+	if _, ok := m.data.keys[m.key(fingerprint)]; ok {
+		return policyError("%w: %s", ErrAlreadyImported, fingerprint)
+	}
+	info.Trust = cmp.Or(info.Trust, defaultTrust)
+	m.data.keys[m.key(fingerprint)] = StoredKey{Fingerprint: fingerprint, PubKey: pubKey, UserID: primaryUserID(key), KeyInfo: info}
+	return nil
+}
+
+func (m *memStore) Get(fingerprint string) (StoredKey, error) {
+	m.data.mu.RLock()
+	defer m.data.mu.RUnlock()
+	stored, ok := m.data.keys[m.key(fingerprint)]
+	if !ok {
+		return stored, policyError("%w: %s", ErrKeyNotFound, fingerprint)
+	}
+	return stored, nil
+}
+
+func (m *memStore) List(q KeyQuery) ([]StoredKey, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	m.data.mu.RLock()
+	defer m.data.mu.RUnlock()
+	keys := make([]StoredKey, 0, len(m.data.keys))
+	for id, k := range m.data.keys {
+		if id.tenant == m.tenant && q.match(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		switch q.Sort {
+		case "fingerprint":
+			return keys[i].Fingerprint < keys[j].Fingerprint
+		case "userid":
+			if keys[i].UserID != keys[j].UserID {
+				return keys[i].UserID < keys[j].UserID
+			}
+			return keys[i].Fingerprint < keys[j].Fingerprint
+		default:
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+	})
+	keys = keys[min(q.Offset, len(keys)):]
+	if q.Limit > 0 {
+		keys = keys[:min(q.Limit, len(keys))]
+	}
+	return keys, nil
+}
+
+func (m *memStore) Count(q KeyQuery) (int, error) {
+	if err := q.validate(); err != nil {
+		return 0, err
+	}
+	m.data.mu.RLock()
+	defer m.data.mu.RUnlock()
+	n := 0
+	for id, k := range m.data.keys {
+		if id.tenant == m.tenant && q.match(k) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) Update(fingerprint string, update func(*KeyInfo)) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	stored, ok := m.data.keys[m.key(fingerprint)]
+	if !ok {
+		return policyError("%w: %s", ErrKeyNotFound, fingerprint)
+	}
+	update(&stored.KeyInfo)
+	stored.Trust = cmp.Or(stored.Trust, defaultTrust)
+	m.data.keys[m.key(fingerprint)] = stored
+	return nil
+}
+
+func (m *memStore) Replace(key *crypto.Key) error {
+	pubKey, err := key.GetPublicKey()
+	if err != nil {
+		return parseError("%w: %w", ErrPubKeyFail, err)
+	}
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	fingerprint := key.GetFingerprint()
+	stored, ok := m.data.keys[m.key(fingerprint)]
+	if !ok {
+		return policyError("%w: %s", ErrKeyNotFound, fingerprint)
+	}
+	stored.PubKey, stored.UserID = pubKey, primaryUserID(key)
+	m.data.keys[m.key(fingerprint)] = stored
+	return nil
+}
+
+func (m *memStore) Delete(fingerprint string) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	if _, ok := m.data.keys[m.key(fingerprint)]; !ok {
+		return policyError("%w: %s", ErrKeyNotFound, fingerprint)
+	}
+	delete(m.data.keys, m.key(fingerprint))
+	return nil
+}
+
+func (m *memStore) Atomic(fn func(KeyStore) error) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	clone := &memStore{data: &memKeys{keys: maps.Clone(m.data.keys)}, tenant: m.tenant}
+	if err := fn(clone); err != nil {
+		return err
+	}
+	m.data.keys = clone.data.keys
+	return nil
+}
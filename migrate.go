@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// migrations brings the schema from version i to i+1, they are applied in
+// order and exactly once: never edit or reorder them, append new ones
+var migrations = []string{
+	// 1: keys
+	`CREATE TABLE IF NOT EXISTS keys (
+		fingerprint VARCHAR(40) NOT NULL PRIMARY KEY,
+		pub_key BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	// 2: totp fallback secrets
+	`CREATE TABLE IF NOT EXISTS totp (
+		fingerprint VARCHAR(40) NOT NULL PRIMARY KEY REFERENCES keys(fingerprint),
+		secret BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	// 3: challenge issuance token buckets
+	`CREATE TABLE IF NOT EXISTS rate_limits (
+		fingerprint VARCHAR(40) NOT NULL PRIMARY KEY,
+		tokens REAL NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	// 4: free text key labels
+	`ALTER TABLE keys ADD COLUMN label TEXT NOT NULL DEFAULT ''`,
+	// 5: smartcard backed keys
+	`ALTER TABLE keys ADD COLUMN card BOOLEAN NOT NULL DEFAULT 0`,
+	// 6: keys imported past their expiry
+	`ALTER TABLE keys ADD COLUMN expired BOOLEAN NOT NULL DEFAULT 0`,
+	// 7: database wide settings
+	`CREATE TABLE IF NOT EXISTS settings (
+		name TEXT NOT NULL PRIMARY KEY,
+		value BLOB NOT NULL
+	)`,
+	// 8: primary user id, filled by openStore for existing keys
+	`ALTER TABLE keys ADD COLUMN user_id TEXT NOT NULL DEFAULT ''`,
+	// 9: owner trust levels
+	`ALTER TABLE keys ADD COLUMN trust TEXT NOT NULL DEFAULT 'unknown'`,
+	// 10: keys revoked by their designated revoker
+	`ALTER TABLE keys ADD COLUMN revoked BOOLEAN NOT NULL DEFAULT 0`,
+	// 11-13: per key challenge defaults, solve_time in nanoseconds
+	`ALTER TABLE keys ADD COLUMN challenge_length INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE keys ADD COLUMN solve_time INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE keys ADD COLUMN timeout_action TEXT NOT NULL DEFAULT ''`,
+	// 14-16: tenants, a fingerprint may be enrolled once per tenant
+	`CREATE TABLE keys_tenants (
+		tenant TEXT NOT NULL DEFAULT 'default',
+		fingerprint VARCHAR(40) NOT NULL,
+		pub_key BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		label TEXT NOT NULL DEFAULT '',
+		card BOOLEAN NOT NULL DEFAULT 0,
+		expired BOOLEAN NOT NULL DEFAULT 0,
+		user_id TEXT NOT NULL DEFAULT '',
+		trust TEXT NOT NULL DEFAULT 'unknown',
+		revoked BOOLEAN NOT NULL DEFAULT 0,
+		challenge_length INTEGER NOT NULL DEFAULT 0,
+		solve_time INTEGER NOT NULL DEFAULT 0,
+		timeout_action TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (tenant, fingerprint)
+	);
+	INSERT INTO keys_tenants (fingerprint, pub_key, created_at, label, card, expired, user_id, trust, revoked, challenge_length, solve_time, timeout_action)
+		SELECT fingerprint, pub_key, created_at, label, card, expired, user_id, trust, revoked, challenge_length, solve_time, timeout_action FROM keys;
+	DROP TABLE keys;
+	ALTER TABLE keys_tenants RENAME TO keys`,
+	`CREATE TABLE totp_tenants (
+		tenant TEXT NOT NULL DEFAULT 'default',
+		fingerprint VARCHAR(40) NOT NULL,
+		secret BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (tenant, fingerprint),
+		FOREIGN KEY (tenant, fingerprint) REFERENCES keys(tenant, fingerprint)
+	);
+	INSERT INTO totp_tenants (fingerprint, secret, created_at) SELECT fingerprint, secret, created_at FROM totp;
+	DROP TABLE totp;
+	ALTER TABLE totp_tenants RENAME TO totp`,
+	`CREATE TABLE rate_limits_tenants (
+		tenant TEXT NOT NULL DEFAULT 'default',
+		fingerprint VARCHAR(40) NOT NULL,
+		tokens REAL NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (tenant, fingerprint)
+	);
+	INSERT INTO rate_limits_tenants (fingerprint, tokens, updated_at) SELECT fingerprint, tokens, updated_at FROM rate_limits;
+	DROP TABLE rate_limits;
+	ALTER TABLE rate_limits_tenants RENAME TO rate_limits`,
+	// 17: issued challenges, never their solution, times are stored in UTC
+	`CREATE TABLE challenges (
+		tenant TEXT NOT NULL DEFAULT 'default',
+		id TEXT NOT NULL,
+		fingerprint VARCHAR(40) NOT NULL,
+		issued_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		solved_at TIMESTAMP,
+		PRIMARY KEY (tenant, id)
+	);
+	CREATE INDEX challenges_fingerprint ON challenges (tenant, fingerprint, issued_at)`,
+	// 18: armor headers kept with import --preserve-headers
+	`ALTER TABLE keys ADD COLUMN armor_headers TEXT NOT NULL DEFAULT ''`,
+	// 19: url keys were downloaded from, for refresh
+	`ALTER TABLE keys ADD COLUMN origin TEXT NOT NULL DEFAULT ''`,
+	// 20: time step of the last accepted totp code, codes are used once
+	`ALTER TABLE totp ADD COLUMN last_counter INTEGER NOT NULL DEFAULT 0`,
+}
+
+func schemaVersion(conn *sql.DB) (int, error) {
+	var version int
+	err := conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, dbError("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate applies the pending migrations and returns the resulting version.
+// Databases created before versioning are at version 0, the first migrations
+// use IF NOT EXISTS so they are safe to run on them.
+func migrate(conn *sql.DB) (int, error) {
+	_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER NOT NULL PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return 0, dbError("failed to create schema_version table: %w", err)
+	}
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return 0, err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := conn.Begin()
+		if err != nil {
+			return version, dbError("failed to start migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return version, dbError("migration %d failed: %w", version+1, err)
+		}
+		_, err = tx.Exec(`INSERT INTO schema_version (version, applied_at) VALUES (?, ?)`, version+1, time.Now())
+		if err != nil {
+			tx.Rollback()
+			return version, dbError("failed to record migration %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return version, dbError("failed to commit migration %d: %w", version+1, err)
+		}
+	}
+	return version, nil
+}
+
+// resetSchema drops every table, migrate recreates them afterwards
+func resetSchema(conn *sql.DB) error {
+	rows, err := conn.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return dbError("failed to list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return dbError("failed to scan row: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	for _, table := range tables {
+		if _, err := conn.Exec(`DROP TABLE IF EXISTS "` + table + `"`); err != nil {
+			return dbError("failed to drop table %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func initDB(args []string) error {
+	fs := flag.NewFlagSet("init-db", flag.ContinueOnError)
+	force := fs.Bool("force", false, "drop every table and recreate the schema, deleting all data")
+	hashFingerprints := fs.Bool("hash-fingerprints", false, "store salted hashes of the fingerprints and no user ids, only on an empty sqlite database. The public keys are still stored: this keeps fingerprints out of the key index and queries, it does not hide them from anyone holding the database")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *force {
+		ok, err := confirm("this will permanently delete every stored key and secret, continue?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("aborted")
+		}
+		if err := resetSchema(db); err != nil {
+			return err
+		}
+		fingerprintSalt = nil
+		slog.Warn("database reset", "event", "init_db")
+	}
+	version, err := migrate(db)
+	if err != nil {
+		return err
+	}
+	if *hashFingerprints {
+		if _, ok := store.(*sqliteStore); !ok {
+			return policyError("%w", ErrHashingUnsupported)
+		}
+		if fingerprintSalt == nil {
+			if err := enableFingerprintHashing(db); err != nil {
+				return err
+			}
+		}
+		fmt.Println("fingerprints are stored hashed")
+	}
+	fmt.Println("schema version:", version)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	conn, err := sqliteOpen("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Schema as created before versioning was introduced
+	_, err = conn.Exec(`CREATE TABLE keys (
+		fingerprint VARCHAR(40) NOT NULL PRIMARY KEY,
+		pub_key BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`INSERT INTO keys (fingerprint, pub_key) VALUES ('abcd', 'key')`); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := migrate(conn)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("got version %d, expected %d", version, len(migrations))
+	}
+	var count int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM keys`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("existing keys lost: count %d, err %v", count, err)
+	}
+	if _, err := conn.Exec(`SELECT fingerprint, secret FROM totp`); err != nil {
+		t.Errorf("totp table missing: %v", err)
+	}
+
+	// Running again is a no-op
+	version, err = migrate(conn)
+	if err != nil || version != len(migrations) {
+		t.Errorf("second run: version %d, err %v", version, err)
+	}
+}
+
+func TestInitDBForce(t *testing.T) {
+	useTestDB(t)
+	if _, err := db.Exec(`INSERT INTO rate_limits (fingerprint, tokens, updated_at) VALUES ('abcd', 1, CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	countRows := func() int {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM rate_limits`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	useTestStdin(t, "no\n")
+	if err := initDB([]string{"--force"}); err == nil {
+		t.Error("expected refusing the confirmation to abort")
+	}
+	if countRows() != 1 {
+		t.Fatal("data deleted without confirmation")
+	}
+
+	useTestStdin(t, "yes\n")
+	if err := initDB([]string{"--force"}); err != nil {
+		t.Fatal(err)
+	}
+	if countRows() != 0 {
+		t.Error("data kept after forced init")
+	}
+	if version, err := schemaVersion(db); err != nil || version != len(migrations) {
+		t.Errorf("got version %d (%v), expected %d", version, err, len(migrations))
+	}
+}
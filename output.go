@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+)
+
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+)
+
+var (
+	ErrOutputFormat = errors.New("output format must be one of table, csv, json")
+	ErrOutputExists = errors.New("output file already exists, pass --allow-overwrite to replace it")
+)
+
+// createOutputFile creates a private output file, an existing file is only
+// replaced when overwrite is set
+func createOutputFile(path string, overwrite bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, policyError("%w: %s", ErrOutputExists, path)
+	}
+	return file, err
+}
+
+// outputFormat validates format, an empty one defaults to table on a
+// terminal and json otherwise
+func outputFormat(format string) (string, error) {
+	switch format {
+	case "":
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			return formatTable, nil
+		}
+		return formatJSON, nil
+	case formatTable, formatCSV, formatJSON:
+		return format, nil
+	default:
+		return "", parseError("%w: %s", ErrOutputFormat, format)
+	}
+}
+
+// writeRecords renders rows under the given column headers, json output is
+// an array of objects keyed by header
+func writeRecords(w io.Writer, format string, headers []string, rows [][]string) error {
+	switch format {
+	case formatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(headers, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(headers)
+		cw.WriteAll(rows)
+		return cw.Error()
+	case formatJSON:
+		records := make([]map[string]string, 0, len(rows))
+		for _, row := range rows {
+			record := make(map[string]string, len(headers))
+			for i, header := range headers {
+				record[header] = row[i]
+			}
+			records = append(records, record)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	default:
+		return parseError("%w: %s", ErrOutputFormat, format)
+	}
+}
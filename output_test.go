@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestWriteRecords(t *testing.T) {
+	headers := []string{"fingerprint", "created_at"}
+	rows := [][]string{
+		{"abcd", "2024-01-01T00:00:00Z"},
+		{"ef01,x", "2024-01-02T00:00:00Z"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeRecords(&buf, formatTable, headers, rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := "FINGERPRINT  CREATED_AT\nabcd         2024-01-01T00:00:00Z\nef01,x       2024-01-02T00:00:00Z\n"
+	if buf.String() != expected {
+		t.Errorf("table: got %q, expected %q", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := writeRecords(&buf, formatCSV, headers, rows); err != nil {
+		t.Fatal(err)
+	}
+	expected = "fingerprint,created_at\nabcd,2024-01-01T00:00:00Z\n\"ef01,x\",2024-01-02T00:00:00Z\n"
+	if buf.String() != expected {
+		t.Errorf("csv: got %q, expected %q", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := writeRecords(&buf, formatJSON, headers, rows); err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1]["fingerprint"] != "ef01,x" {
+		t.Errorf("json: got %+v", records)
+	}
+
+	if _, err := outputFormat("xml"); !errors.Is(err, ErrOutputFormat) || !errors.Is(err, ErrParse) {
+		t.Errorf("got %v, expected %v", err, ErrOutputFormat)
+	}
+	if err := writeRecords(&buf, "xml", nil, nil); !errors.Is(err, ErrOutputFormat) || !errors.Is(err, ErrParse) {
+		t.Errorf("got %v, expected %v", err, ErrOutputFormat)
+	}
+}
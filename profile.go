@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultProfile = "default"
+
+	// legacyDBPath is the database in the working directory used before
+	// profiles, moved to the default profile on first use
+	legacyDBPath = "pgp-mfa.db"
+)
+
+var (
+	ErrProfileName = errors.New("invalid profile name, use letters, digits, '-' and '_'")
+
+	profileName   = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	activeProfile = defaultProfile
+)
+
+// profileDir is where the profile databases live: $XDG_DATA_HOME/pgp-mfa,
+// ~/.local/share/pgp-mfa by default
+func profileDir() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the profile directory: %w", err)
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "pgp-mfa"), nil
+}
+
+// profilePath returns the database of a profile, creating the profile
+// directory on demand. The database itself is created when opened.
+func profilePath(name string) (string, error) {
+	if !profileName.MatchString(name) {
+		return "", parseError("%w: '%s'", ErrProfileName, name)
+	}
+	dir, err := profileDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create profile directory: %w", err)
+	}
+	return filepath.Join(dir, name+".db"), nil
+}
+
+// migrateLegacyDB moves a ./pgp-mfa.db left by versions before profiles to
+// path, the default profile database, along with its journal files. It is
+// left alone when the profile already has a database, and used in place
+// when it cannot be moved.
+func migrateLegacyDB(path string) string {
+	if _, err := os.Stat(legacyDBPath); err != nil {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		slog.Warn("ignoring the database in the working directory, the default profile already has one", "event", "legacy_db_ignored", "legacy", legacyDBPath, "db", path)
+		return path
+	}
+	if err := os.Rename(legacyDBPath, path); err != nil {
+		slog.Warn("failed to move the database in the working directory to the default profile, using it in place", "event", "legacy_db_in_place", "legacy", legacyDBPath, "db", path, "error", err)
+		return legacyDBPath
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Rename(legacyDBPath+suffix, path+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to move database journal", "event", "legacy_db_journal", "file", legacyDBPath+suffix, "error", err)
+		}
+	}
+	slog.Info("moved the database in the working directory to the default profile", "event", "legacy_db_moved", "legacy", legacyDBPath, "db", path)
+	return path
+}
+
+// listProfileNames returns the existing profiles in alphabetical order
+func listProfileNames() ([]string, error) {
+	dir, err := profileDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".db")
+		if ok && entry.Type().IsRegular() && profileName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// listProfiles prints the existing profiles, the active one marked with *
+func listProfiles(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa profiles")
+	}
+	names, err := listProfileNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		marker := " "
+		if name == activeProfile {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, name)
+	}
+	return nil
+}
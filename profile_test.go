@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProfilePath(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataDir)
+
+	path, err := profilePath("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dataDir, "pgp-mfa", "work.db"); path != expected {
+		t.Errorf("got %s, expected %s", path, expected)
+	}
+	for _, name := range []string{"", "../work", "a/b", "work.db"} {
+		if _, err := profilePath(name); !errors.Is(err, ErrProfileName) {
+			t.Errorf("%q: got %v, expected %v", name, err, ErrProfileName)
+		}
+	}
+}
+
+func TestListProfileNames(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	// No profile directory yet
+	if names, err := listProfileNames(); err != nil || len(names) != 0 {
+		t.Fatalf("got %v, %v, expected no profiles", names, err)
+	}
+	for _, name := range []string{"personal", "work"} {
+		path, err := profilePath(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, conn, err := openStore("sqlite:" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	dir, _ := profileDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	names, err := listProfileNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"personal", "work"}) {
+		t.Errorf("got %v, expected [personal work]", names)
+	}
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	path, err := profilePath(defaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to move
+	if got := migrateLegacyDB(path); got != path {
+		t.Errorf("got %s, expected %s", got, path)
+	}
+	if err := os.WriteFile(legacyDBPath, []byte("keys"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := migrateLegacyDB(path); got != path {
+		t.Errorf("got %s, expected %s", got, path)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "keys" {
+		t.Errorf("legacy database not moved: %q, %v", data, err)
+	}
+	if _, err := os.Stat(legacyDBPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("legacy database left behind: %v", err)
+	}
+
+	// The profile database wins over a new legacy one
+	if err := os.WriteFile(legacyDBPath, []byte("other"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := migrateLegacyDB(path); got != path {
+		t.Errorf("got %s, expected %s", got, path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keys" {
+		t.Errorf("profile database overwritten: %q", data)
+	}
+}
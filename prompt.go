@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var (
+	ErrInputRequired = errors.New("input required in batch mode")
+	ErrCancelled     = errors.New("cancelled")
+
+	// stdin is shared by every prompt so that input buffered by one of them
+	// is not lost for the next
+	stdin = bufio.NewReader(os.Stdin)
+
+	// batchMode makes prompts fail instead of waiting for input, set by the
+	// global --batch flag
+	batchMode bool
+
+	// passphraseFD, when not negative, is the file descriptor the database
+	// passphrase is read from (global --passphrase-fd)
+	passphraseFD = -1
+)
+
+// dbPassphraseEnv holds the database passphrase when neither --passphrase-fd
+// nor a terminal can provide it
+const dbPassphraseEnv = "PGP_MFA_DB_PASSPHRASE"
+
+// promptLine prints prompt and reads a trimmed line of input
+func promptLine(prompt string) (string, error) {
+	if batchMode {
+		return "", policyError("%w: %s", ErrInputRequired, strings.TrimSpace(prompt))
+	}
+	fmt.Print(prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// dbPassphrase returns the passphrase of the database, taken from the first
+// of: the first line of the --passphrase-fd descriptor, $PGP_MFA_DB_PASSPHRASE
+// and a terminal prompt. The descriptor is closed once read. The environment
+// is inherited by child processes, prefer the descriptor in services
+func dbPassphrase() ([]byte, error) {
+	if passphraseFD >= 0 {
+		file := os.NewFile(uintptr(passphraseFD), "passphrase-fd")
+		if file == nil {
+			return nil, parseError("invalid --passphrase-fd %d", passphraseFD)
+		}
+		defer file.Close()
+		line, err := bufio.NewReader(file).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return nil, fmt.Errorf("failed to read the database passphrase from fd %d: %w", passphraseFD, err)
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+	if passphrase, ok := os.LookupEnv(dbPassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+	return readPassphrase("database passphrase")
+}
+
+// readPassphrase prompts for prompt on the terminal without echoing it
+func readPassphrase(prompt string) ([]byte, error) {
+	if batchMode {
+		return nil, policyError("%w: %s", ErrInputRequired, prompt)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, policyError("no terminal is available to prompt for the %s", prompt)
+	}
+	fmt.Fprint(os.Stderr, prompt+": ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", prompt, err)
+	}
+	return passphrase, nil
+}
+
+// promptNewPassword reads a password on the terminal, twice to catch typos
+func promptNewPassword(prompt string) ([]byte, error) {
+	password, err := readPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, policyError("the %s cannot be empty", prompt)
+	}
+	repeated, err := readPassphrase("repeat " + prompt)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, repeated) {
+		return nil, policyError("the %ss do not match", prompt)
+	}
+	return password, nil
+}
+
+// confirm asks a yes / no question, anything but yes is a no
+func confirm(prompt string) (bool, error) {
+	answer, err := promptLine(prompt + " [y/N]: ")
+	if errors.Is(err, ErrInputRequired) {
+		return false, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
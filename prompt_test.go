@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func useTestStdin(t *testing.T, input string) {
+	t.Helper()
+	prev := stdin
+	stdin = bufio.NewReader(strings.NewReader(input))
+	t.Cleanup(func() { stdin = prev })
+}
+
+func TestPromptChoice(t *testing.T) {
+	useTestStdin(t, "abc\n7\n-1\n 1 \n")
+	if choice, err := promptChoice("select: ", 2); err != nil || choice != 1 {
+		t.Errorf("got %d (%v), expected 1 after re-prompts", choice, err)
+	}
+
+	// Cancellations
+	for _, input := range []string{"abc\n", "\n", "q\n", "abc\n  \n"} {
+		useTestStdin(t, input)
+		if _, err := promptChoice("select: ", 2); !errors.Is(err, ErrCancelled) {
+			t.Errorf("%q: got %v, expected %v", input, err, ErrCancelled)
+		}
+	}
+
+	// The last line may miss its newline
+	useTestStdin(t, "0")
+	if choice, err := promptChoice("select: ", 2); err != nil || choice != 0 {
+		t.Errorf("got %d (%v), expected 0", choice, err)
+	}
+}
+
+func TestGetKeyInteractive(t *testing.T) {
+	useTestDB(t)
+	if _, _, err := getKey("", KeyQuery{}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("empty store: got %v, expected %v", err, ErrKeyNotFound)
+	}
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	useTestStdin(t, "q\n")
+	if _, _, err := getKey("", KeyQuery{}); !errors.Is(err, ErrCancelled) {
+		t.Errorf("got %v, expected %v", err, ErrCancelled)
+	}
+	useTestStdin(t, "x\n5\n0\n")
+	_, key, err := getKey("", KeyQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.GetFingerprint() != ecKey.GetFingerprint() {
+		t.Errorf("got %s, expected %s", key.GetFingerprint(), ecKey.GetFingerprint())
+	}
+}
+
+func TestBatchMode(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	useTestStdin(t, "0\nyes\n")
+	batchMode = true
+	t.Cleanup(func() { batchMode = false })
+
+	if _, _, err := getKey("", KeyQuery{}); !errors.Is(err, ErrInputRequired) || !errors.Is(err, ErrPolicy) {
+		t.Errorf("key selection: got %v, expected %v", err, ErrInputRequired)
+	}
+	if err := initDB([]string{"--force"}); !errors.Is(err, ErrInputRequired) {
+		t.Errorf("confirmation: got %v, expected %v", err, ErrInputRequired)
+	}
+	if _, err := store.Get(ecKey.GetFingerprint()); err != nil {
+		t.Errorf("batch mode confirmation went through: %v", err)
+	}
+}
+
+func TestDBPassphrase(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dbPassphrase closes the descriptor it reads from, hand it a copy so
+	// that it is only closed once
+	fd, err := syscall.Dup(int(r.Fd()))
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString("from fd\r\nsecond line\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	t.Setenv(dbPassphraseEnv, "from env")
+	batchMode = true
+	t.Cleanup(func() { passphraseFD, batchMode = -1, false })
+
+	// --passphrase-fd, then the environment, then the prompt
+	passphraseFD = fd
+	if passphrase, err := dbPassphrase(); err != nil || string(passphrase) != "from fd" {
+		t.Errorf("fd: got %q (%v), expected the first line", passphrase, err)
+	}
+	passphraseFD = -1
+	if passphrase, err := dbPassphrase(); err != nil || string(passphrase) != "from env" {
+		t.Errorf("env: got %q (%v), expected %q", passphrase, err, "from env")
+	}
+	os.Unsetenv(dbPassphraseEnv)
+	if _, err := dbPassphrase(); !errors.Is(err, ErrInputRequired) {
+		t.Errorf("prompt: got %v, expected %v", err, ErrInputRequired)
+	}
+}
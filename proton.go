@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/ProtonMail/gopenpgp/v3/armor"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+var (
+	ErrProtonAddress = errors.New("invalid email address for the Proton key lookup")
+	ErrProtonNoKey   = errors.New("Proton has no public key for this address")
+
+	// protonAPI serves the public keys of Proton addresses, looked up by
+	// email address with HKP
+	protonAPI = "https://api.protonmail.ch"
+)
+
+// protonKeyPrefix marks the addresses given to import --proton among the
+// key files, these are also stored as the origin of the imported keys
+const protonKeyPrefix = "proton:"
+
+// protonLookupURL is where the public key of a Proton address is served
+func protonLookupURL(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Name != "" {
+		return "", parseError("%w: '%s'", ErrProtonAddress, address)
+	}
+	return protonAPI + "/pks/lookup?op=get&search=" + url.QueryEscape(parsed.Address), nil
+}
+
+// fetchProtonKey downloads the public key of a Proton address. Addresses
+// may have several keys, the first one is the primary key and the only one
+// returned.
+func fetchProtonKey(address string) (io.ReadCloser, error) {
+	u, err := protonLookupURL(address)
+	if err != nil {
+		return nil, err
+	}
+	body, err := fetchKey(u)
+	if errors.Is(err, ErrFetchNotFound) {
+		return nil, policyError("%w: %s", ErrProtonNoKey, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	binary, err := armor.Unarmor(string(data))
+	if err != nil {
+		return nil, parseError("%w: the Proton key api answered with no armored key for %s: %w", ErrFetchFailed, address, err)
+	}
+	keyRing, err := crypto.NewKeyRingFromBinary(binary)
+	if err != nil {
+		return nil, parseError("%w: %w", ErrFailedRead, err)
+	}
+	if keyRing.CountEntities() == 0 {
+		return nil, policyError("%w: %s", ErrProtonNoKey, address)
+	}
+	if n := keyRing.CountEntities(); n > 1 {
+		slog.Info("the Proton address has several keys, importing its primary key", "event", "proton_keys", "address", address, "keys", n)
+	}
+	key, err := keyRing.GetKey(0)
+	if err != nil {
+		return nil, parseError("%w: %w", ErrFailedRead, err)
+	}
+	primary, err := key.GetPublicKey()
+	if err != nil {
+		return nil, parseError("%w: %w", ErrPubKeyFail, err)
+	}
+	return io.NopCloser(bytes.NewReader(primary)), nil
+}
+
+// fetchOrigin downloads a key again from the origin it was imported from,
+// an https url or a Proton address
+func fetchOrigin(origin string) (io.ReadCloser, error) {
+	if address, ok := strings.CutPrefix(origin, protonKeyPrefix); ok {
+		return fetchProtonKey(address)
+	}
+	return fetchKey(origin)
+}
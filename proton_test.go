@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v3/armor"
+)
+
+func TestImportProton(t *testing.T) {
+	primary, err := ecKey.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary, err := rsa3072Key.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both keys of the address, the primary one first
+	keys, err := armor.ArmorKey(append(primary, secondary...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pks/lookup" || r.URL.Query().Get("op") != "get" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("search") {
+		case "alice@proton.me":
+			w.Write([]byte(keys))
+		case "garbage@proton.me":
+			w.Write([]byte("<html>maintenance</html>"))
+		case "down@proton.me":
+			http.Error(w, `{"Code": 500, "Error": "down"}`, http.StatusInternalServerError)
+		default:
+			http.Error(w, "No key found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	prevClient, prevAPI, prevAttempts := keyFetchClient, protonAPI, KeyFetchAttempts
+	keyFetchClient, protonAPI, KeyFetchAttempts = server.Client(), server.URL, 1
+	defer func() { keyFetchClient, protonAPI, KeyFetchAttempts = prevClient, prevAPI, prevAttempts }()
+
+	useTestDB(t)
+	if err := importKey([]string{"--proton", "alice@proton.me"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(ecKey.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Origin != protonKeyPrefix+"alice@proton.me" {
+		t.Errorf("got origin %q", stored.Origin)
+	}
+	if _, err := store.Get(rsa3072Key.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("secondary key imported: %v", err)
+	}
+	// The Proton address is the origin refresh downloads the key from again
+	if changed, err := refreshKey(stored); err != nil || changed {
+		t.Errorf("refresh: got %v (%v), expected no change", changed, err)
+	}
+
+	for address, expected := range map[string]error{
+		"bob@proton.me":     ErrProtonNoKey,
+		"garbage@proton.me": ErrFetchFailed,
+		"down@proton.me":    ErrFetchFailed,
+		"not an address":    ErrProtonAddress,
+		"Bob <b@proton.me>": ErrProtonAddress,
+	} {
+		if err := importKey([]string{"--proton", address}); !errors.Is(err, expected) {
+			t.Errorf("%s: got %v, expected %v", address, err, expected)
+		}
+	}
+	if err := importKey([]string{"--proton", "bob@proton.me"}); !errors.Is(err, ErrPolicy) {
+		t.Errorf("missing key: got %v, expected a policy error", err)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var (
+	ErrNoQREncoder = errors.New("qrencode is not installed, cannot render qr codes")
+
+	// qrCommand renders its stdin as a qr code made of unicode half blocks
+	qrCommand = []string{"qrencode", "-t", "ANSIUTF8", "-l", "L"}
+)
+
+// renderQR returns data rendered as a terminal qr code
+func renderQR(data string) (string, error) {
+	path, err := exec.LookPath(qrCommand[0])
+	if err != nil {
+		return "", ErrNoQREncoder
+	}
+	cmd := exec.Command(path, qrCommand[1:]...)
+	cmd.Stdin = strings.NewReader(data)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// e.g. data too large for a qr code
+		return "", fmt.Errorf("%s: %w: %s", qrCommand[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
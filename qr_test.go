@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderQR(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	if _, err := renderQR("data"); !errors.Is(err, ErrNoQREncoder) {
+		t.Errorf("got %v, expected %v", err, ErrNoQREncoder)
+	}
+
+	// Stand-in encoder echoing its input
+	script := "#!/bin/sh\nread -r line; printf %s \"$line\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "qrencode"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := renderQR("data"); err != nil || got != "data" {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	script = "#!/bin/sh\necho 'input data is too large' >&2\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "qrencode"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderQR("data"); err == nil {
+		t.Error("expected the encoder failure to be reported")
+	}
+}
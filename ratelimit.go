@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// Default issuance limit: a burst of ChallengeRateBurst challenges per
+	// key, refilled over ChallengeRateWindow
+	ChallengeRateBurst  = 5
+	ChallengeRateWindow = 10 * time.Minute
+
+	ErrRateLimited = errors.New("too many requests, challenge issuance is rate limited for this key")
+
+	// readOnlyBuckets hold the rate limits when the database cannot be
+	// written, they last as long as the process
+	readOnlyBuckets   = map[[2]string]rateBucket{}
+	readOnlyBucketsMu sync.Mutex
+)
+
+type rateBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// refill returns the tokens of the bucket at now, capped at burst
+func (b rateBucket) refill(burst int, window time.Duration, now time.Time) float64 {
+	refill := now.Sub(b.updatedAt).Seconds() * float64(burst) / window.Seconds()
+	return min(b.tokens+max(refill, 0), float64(burst))
+}
+
+// takeToken consumes one token from the fingerprint's bucket, refilling it
+// proportionally to the time elapsed since the last issuance. A burst lower
+// than 1 disables the limit. On a read-only database the buckets are kept
+// in memory.
+func takeToken(fingerprint string, burst int, window time.Duration, now time.Time) error {
+	if burst < 1 {
+		return nil
+	}
+	if window <= 0 {
+		return parseError("the rate limit window must be positive")
+	}
+	fingerprint = fingerprintID(fingerprint)
+	if readOnlyDB != "" {
+		return takeReadOnlyToken(fingerprint, burst, window, now)
+	}
+	tx, err := db.BeginTx(commandCtx, nil)
+	if err != nil {
+		return dbError("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var bucket rateBucket
+	err = tx.QueryRow(`SELECT tokens, updated_at FROM rate_limits WHERE tenant = ? AND fingerprint = ?`, activeTenant, fingerprint).Scan(&bucket.tokens, &bucket.updatedAt)
+	tokens := float64(burst)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return dbError("failed to query rate limit: %w", err)
+	default:
+		tokens = bucket.refill(burst, window, now)
+	}
+	if tokens < 1 {
+		return policyError("%w", ErrRateLimited)
+	}
+	_, err = tx.Exec(`INSERT INTO rate_limits (tenant, fingerprint, tokens, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(tenant, fingerprint) DO UPDATE SET tokens = excluded.tokens, updated_at = excluded.updated_at`,
+		activeTenant,
+		fingerprint,
+		tokens-1,
+		now,
+	)
+	if err != nil {
+		return dbError("failed to update rate limit: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return dbError("failed to commit rate limit: %w", err)
+	}
+	return nil
+}
+
+func takeReadOnlyToken(fingerprint string, burst int, window time.Duration, now time.Time) error {
+	readOnlyBucketsMu.Lock()
+	defer readOnlyBucketsMu.Unlock()
+	key := [2]string{activeTenant, fingerprint}
+	tokens := float64(burst)
+	if bucket, ok := readOnlyBuckets[key]; ok {
+		tokens = bucket.refill(burst, window, now)
+	}
+	if tokens < 1 {
+		return policyError("%w", ErrRateLimited)
+	}
+	readOnlyBuckets[key] = rateBucket{tokens: tokens - 1, updatedAt: now}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimitExhaustion(t *testing.T) {
+	useTestDB(t)
+	const burst = 3
+	window := 3 * time.Minute
+	now := time.Now()
+
+	for i := 0; i < burst; i++ {
+		if err := takeToken("ABCD", burst, window, now); err != nil {
+			t.Fatalf("issuance %d rejected: %v", i, err)
+		}
+	}
+	if err := takeToken("abcd", burst, window, now); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got %v, expected %v", err, ErrRateLimited)
+	}
+	// Other keys have their own bucket
+	if err := takeToken("ef01", burst, window, now); err != nil {
+		t.Errorf("unrelated key rejected: %v", err)
+	}
+
+	// One token is refilled every window / burst
+	now = now.Add(time.Minute)
+	if err := takeToken("abcd", burst, window, now); err != nil {
+		t.Errorf("refilled token rejected: %v", err)
+	}
+	if err := takeToken("abcd", burst, window, now); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, expected %v", err, ErrRateLimited)
+	}
+
+	if err := takeToken("abcd", 0, window, now); err != nil {
+		t.Errorf("disabled limit rejected issuance: %v", err)
+	}
+}
+
+func TestRateLimitWindow(t *testing.T) {
+	useTestDB(t)
+	now := time.Now()
+	for _, window := range []time.Duration{0, -time.Minute} {
+		if err := takeToken("abcd", 3, window, now); !errors.Is(err, ErrParse) {
+			t.Errorf("window %v: got %v, expected %v", window, err, ErrParse)
+		}
+		if err := challenge([]string{"--rate-window", window.String(), "16"}); !errors.Is(err, ErrParse) {
+			t.Errorf("--rate-window %v: got %v, expected %v", window, err, ErrParse)
+		}
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM rate_limits`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("rejected windows stored %d buckets", count)
+	}
+}
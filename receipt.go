@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// receipt attests that a challenge was solved, it is cleartext signed so that
+// downstream systems can check it with any OpenPGP implementation
+type receipt struct {
+	ChallengeID string    `json:"challenge_id"`
+	Fingerprint string    `json:"fingerprint"`
+	UserID      string    `json:"user_id,omitempty"`
+	IssuedAt    time.Time `json:"issued_at"`
+	SolvedAt    time.Time `json:"solved_at"`
+}
+
+// signReceipt returns the armored cleartext signed json form of r, key is
+// left to the caller to clear
+func signReceipt(r receipt, key *crypto.Key) (string, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode receipt: %w", err)
+	}
+	signer, err := crypto.PGP().Sign().SigningKey(key).New()
+	if err != nil {
+		return "", fmt.Errorf("failed to create signing context: %w", err)
+	}
+	signed, err := signer.SignCleartext(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign receipt: %w", err)
+	}
+	return string(signed), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+func TestSignReceipt(t *testing.T) {
+	r := receipt{
+		ChallengeID: "0123456789abcdef",
+		Fingerprint: rsa3072Key.GetFingerprint(),
+		IssuedAt:    time.Now().Add(-time.Minute).UTC().Truncate(time.Second),
+		SolvedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+	signed, err := signReceipt(r, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ecKey.ToPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := crypto.PGP().Verify().VerificationKey(signer).New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := verifier.VerifyCleartext([]byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := result.SignatureError(); err != nil {
+		t.Fatalf("receipt signature invalid: %v", err)
+	}
+	var got receipt
+	if err := json.Unmarshal(result.Cleartext(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != r {
+		t.Errorf("got %+v, expected %+v", got, r)
+	}
+}
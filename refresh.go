@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+var (
+	ErrNoOrigin = errors.New("key was not downloaded, it has no origin to refresh from")
+	ErrStaleKey = errors.New("the fetched key is older than the stored one")
+)
+
+// latestSelfSignature returns the creation time of the newest signature the
+// key made over itself: user ids, subkey bindings, direct key signatures and
+// revocations. A key re-signed to add a subkey or extend its expiry is newer.
+func latestSelfSignature(key *crypto.Key) time.Time {
+	var latest time.Time
+	consider := func(sigs []*packet.VerifiableSignature) {
+		for _, sig := range sigs {
+			if sig.Packet.CreationTime.After(latest) {
+				latest = sig.Packet.CreationTime
+			}
+		}
+	}
+	entity := key.GetEntity()
+	consider(entity.DirectSignatures)
+	consider(entity.Revocations)
+	for _, identity := range entity.Identities {
+		consider(identity.SelfCertifications)
+		consider(identity.Revocations)
+	}
+	for _, subkey := range entity.Subkeys {
+		consider(subkey.Bindings)
+		consider(subkey.Revocations)
+	}
+	return latest
+}
+
+// refreshKey downloads a stored key again from its origin and stores the
+// fetched version when it is newer, the key's metadata is kept. Revocations
+// by a designated revoker found in the store mark the key revoked, as on
+// import. It tells whether the stored key changed.
+func refreshKey(stored StoredKey) (bool, error) {
+	if stored.Origin == "" {
+		return false, policyError("%w: %s", ErrNoOrigin, stored.Fingerprint)
+	}
+	current, err := stored.Key()
+	if err != nil {
+		return false, err
+	}
+	body, err := fetchOrigin(stored.Origin)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+	key, err := parseKey(body)
+	if err != nil {
+		return false, err
+	}
+	if key.GetFingerprint() != current.GetFingerprint() {
+		return false, policyError("%w: expected %s, got %s", ErrFetchedKeyMismatch, current.GetFingerprint(), key.GetFingerprint())
+	}
+	// A key stored with --allow-expired may still be expired
+	if err := validateKey(key); err != nil && !(errors.Is(err, ErrKeyExp) && stored.Expired) {
+		return false, err
+	}
+	revokers, err := revokerCandidates(store, key, nil)
+	if err != nil {
+		return false, err
+	}
+	// A revocation verified on import with a --revoker file stays
+	revoked := stored.Revoked || designatedRevocation(key, revokers)
+	if revoked && !stored.Revoked {
+		slog.Warn("key has been revoked by its designated revoker, challenges will refuse it", "event", "refresh", "fingerprint", key.GetFingerprint())
+	}
+	fetched, err := key.GetPublicKey()
+	if err != nil {
+		return false, parseError("%w: %w", ErrPubKeyFail, err)
+	}
+	if bytes.Equal(fetched, stored.PubKey) {
+		if revoked == stored.Revoked {
+			return false, nil
+		}
+		return true, store.Update(stored.Fingerprint, func(info *KeyInfo) {
+			info.Revoked = revoked
+		})
+	}
+	if latestSelfSignature(key).Before(latestSelfSignature(current)) {
+		return false, policyError("%w: %s", ErrStaleKey, stored.Origin)
+	}
+	expired := key.IsExpired(time.Now().Unix())
+	return true, store.Atomic(func(tx KeyStore) error {
+		if err := tx.Replace(key); err != nil {
+			return err
+		}
+		return tx.Update(stored.Fingerprint, func(info *KeyInfo) {
+			info.Expired, info.Revoked = expired, revoked
+		})
+	})
+}
+
+// refresh updates a stored key from the url or Proton address it was
+// imported from, e.g. to get a new subkey or an extended expiry
+func refresh(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa refresh <fingerprint>")
+	}
+	stored, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+	changed, err := refreshKey(stored)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Printf("key %s is up to date with %s\n", stored.Fingerprint, stored.Origin)
+		return nil
+	}
+	slog.Info("key refreshed", "event", "key_refreshed", "fingerprint", stored.Fingerprint, "origin", stored.Origin)
+	fmt.Printf("key %s updated from %s\n", stored.Fingerprint, stored.Origin)
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+func TestRefresh(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	entity, err := openpgp.NewEntity("Refreshed User", "", "refreshed@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEd25519,
+		Time:      func() time.Time { return created },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	armored := func() string {
+		t.Helper()
+		key, err := crypto.NewKeyFromEntity(entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		armored, err := key.GetArmoredPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return armored
+	}
+	original := armored()
+	// The same key along with a subkey bound later
+	if err := entity.AddEncryptionSubkey(&packet.Config{Algorithm: packet.PubKeyAlgoEd25519}); err != nil {
+		t.Fatal(err)
+	}
+	updated := armored()
+	other, err := ecKey.GetArmoredPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	served := original
+	serve := func(key string) {
+		mu.Lock()
+		defer mu.Unlock()
+		served = key
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(served))
+	}))
+	defer server.Close()
+	prevClient := keyFetchClient
+	keyFetchClient = server.Client()
+	defer func() { keyFetchClient = prevClient }()
+
+	useTestDB(t)
+	origin := server.URL + "/key.asc"
+	if err := importKey([]string{"--label", "laptop", origin}); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := hex.EncodeToString(entity.PrimaryKey.Fingerprint)
+	before, err := store.Get(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Origin != origin {
+		t.Errorf("got origin %q, expected %q", before.Origin, origin)
+	}
+	if changed, err := refreshKey(before); err != nil || changed {
+		t.Errorf("unchanged key: got %v (%v), expected no change", changed, err)
+	}
+
+	serve(updated)
+	if err := refresh([]string{before.Fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := store.Get(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := after.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key.GetEntity().Subkeys) != 2 {
+		t.Errorf("got %d subkeys, expected the added one too", len(key.GetEntity().Subkeys))
+	}
+	if !after.CreatedAt.Equal(before.CreatedAt) || after.Label != "laptop" || after.Origin != origin {
+		t.Errorf("metadata not kept: %+v, expected %+v", after.KeyInfo, before.KeyInfo)
+	}
+
+	// Older versions and other keys are refused
+	serve(original)
+	if _, err := refreshKey(after); !errors.Is(err, ErrStaleKey) {
+		t.Errorf("older key: got %v, expected %v", err, ErrStaleKey)
+	}
+	serve(other)
+	if _, err := refreshKey(after); !errors.Is(err, ErrFetchedKeyMismatch) {
+		t.Errorf("other key: got %v, expected %v", err, ErrFetchedKeyMismatch)
+	}
+
+	if err := importKey([]string{writeKeyFile(t, rsa3072Key, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := refresh([]string{rsa3072Key.GetFingerprint()}); !errors.Is(err, ErrNoOrigin) {
+		t.Errorf("key file: got %v, expected %v", err, ErrNoOrigin)
+	}
+}
+
+func TestRefreshRevoked(t *testing.T) {
+	revoked, err := crypto.NewKeyFromArmored(revokedKeyArmored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The key as published before its revoker revoked it
+	entity := revoked.GetEntity()
+	revocations := entity.Revocations
+	entity.Revocations = nil
+	var unrevoked bytes.Buffer
+	if err := entity.Serialize(&unrevoked); err != nil {
+		t.Fatal(err)
+	}
+	entity.Revocations = revocations
+
+	var mu sync.Mutex
+	served := unrevoked.Bytes()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(served)
+	}))
+	defer server.Close()
+	prevClient := keyFetchClient
+	keyFetchClient = server.Client()
+	defer func() { keyFetchClient = prevClient }()
+
+	useTestDB(t)
+	revokerFile := filepath.Join(t.TempDir(), "revoker.asc")
+	if err := os.WriteFile(revokerFile, []byte(revokerKeyArmored), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{revokerFile, server.URL + "/key.asc"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := store.Get(revokedFingerprint); err != nil || stored.Revoked {
+		t.Fatalf("got revoked %v (%v), expected a valid key", stored.Revoked, err)
+	}
+
+	mu.Lock()
+	served = []byte(revokedKeyArmored)
+	mu.Unlock()
+	if err := refresh([]string{revokedFingerprint}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(revokedFingerprint)
+	if err != nil || !stored.Revoked {
+		t.Errorf("got revoked %v (%v), expected the revocation to be recorded", stored.Revoked, err)
+	}
+	if changed, err := refreshKey(stored); err != nil || changed {
+		t.Errorf("revoked key: got %v (%v), expected no change", changed, err)
+	}
+}
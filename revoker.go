@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// revocationKeySubpacket designates a key allowed to revoke the signer's key
+// (RFC 4880 5.2.3.15), go-crypto keeps it but does not expose it
+const revocationKeySubpacket = 12
+
+var ErrKeyRevoked = errors.New("key has been revoked by its designated revoker, cannot issue a challenge")
+
+// hashedSubpackets returns the raw hashed subpacket area of a parsed
+// signature, found in its hash suffix after the fixed fields
+func hashedSubpackets(sig *packet.Signature) []byte {
+	suffix := sig.HashSuffix
+	lengthSize := 2
+	if sig.Version == 6 {
+		lengthSize = 4
+	}
+	if len(suffix) < 4+lengthSize {
+		return nil
+	}
+	var length int
+	for _, b := range suffix[4 : 4+lengthSize] {
+		length = length<<8 | int(b)
+	}
+	area := suffix[4+lengthSize:]
+	if length > len(area) {
+		return nil
+	}
+	return area[:length]
+}
+
+// subpacketsOfType splits a subpacket area and returns the bodies of the
+// subpackets of type t
+func subpacketsOfType(area []byte, t byte) [][]byte {
+	var bodies [][]byte
+	for len(area) > 0 {
+		var length int
+		switch {
+		case area[0] < 192:
+			length, area = int(area[0]), area[1:]
+		case area[0] < 255 && len(area) >= 2:
+			length, area = (int(area[0])-192)<<8+int(area[1])+192, area[2:]
+		case area[0] == 255 && len(area) >= 5:
+			length, area = int(binary.BigEndian.Uint32(area[1:5])), area[5:]
+		default:
+			return bodies
+		}
+		if length == 0 || length > len(area) {
+			return bodies
+		}
+		if area[0]&0x7f == t {
+			bodies = append(bodies, area[1:length])
+		}
+		area = area[length:]
+	}
+	return bodies
+}
+
+// designatedRevokers returns the fingerprints of the keys the valid self
+// signatures of key designate as revokers
+func designatedRevokers(key *crypto.Key) []string {
+	entity := key.GetEntity()
+	var sigs []*packet.Signature
+	for _, direct := range entity.DirectSignatures {
+		if entity.PrimaryKey.VerifyDirectKeySignature(direct.Packet) == nil {
+			sigs = append(sigs, direct.Packet)
+		}
+	}
+	for _, identity := range entity.Identities {
+		for _, cert := range identity.SelfCertifications {
+			if entity.PrimaryKey.VerifyUserIdSignature(identity.Name, entity.PrimaryKey, cert.Packet) == nil {
+				sigs = append(sigs, cert.Packet)
+			}
+		}
+	}
+	var revokers []string
+	for _, sig := range sigs {
+		for _, body := range subpacketsOfType(hashedSubpackets(sig), revocationKeySubpacket) {
+			// class, public key algorithm, then the fingerprint
+			if len(body) < 3 || body[0]&0x80 == 0 {
+				continue
+			}
+			fingerprint := hex.EncodeToString(body[2:])
+			if !slices.Contains(revokers, fingerprint) {
+				revokers = append(revokers, fingerprint)
+			}
+		}
+	}
+	return revokers
+}
+
+// designatedRevocation reports whether key carries a valid revocation signed
+// by one of its designated revokers. candidates are the revoker public keys
+// at hand, revocations by revokers missing from them cannot be verified.
+func designatedRevocation(key *crypto.Key, candidates []*crypto.Key) bool {
+	entity := key.GetEntity()
+	authorized := designatedRevokers(key)
+	for _, revocation := range entity.Revocations {
+		sig := revocation.Packet
+		if entity.PrimaryKey.VerifyRevocationSignature(sig) == nil {
+			continue // self revocation
+		}
+		verified := false
+		for _, candidate := range candidates {
+			revoker := candidate.GetEntity().PrimaryKey
+			if !slices.Contains(authorized, strings.ToLower(candidate.GetFingerprint())) ||
+				(sig.IssuerKeyId != nil && *sig.IssuerKeyId != revoker.KeyId) {
+				continue
+			}
+			hash, err := sig.PrepareVerify()
+			if err != nil {
+				continue
+			}
+			// a key revocation signs the revoked primary key
+			if entity.PrimaryKey.SerializeForHash(hash) == nil && revoker.VerifySignature(hash, sig) == nil {
+				verified = true
+				break
+			}
+		}
+		if verified {
+			return true
+		}
+		slog.Warn("key carries a revocation that could not be verified, pass its designated revoker with --revoker", "event", "import", "fingerprint", key.GetFingerprint(), "revokers", strings.Join(authorized, ","))
+	}
+	return false
+}
+
+// revokerCandidates gathers the public keys that may have revoked key: the
+// given revoker key files and the designated revokers found in the store
+func revokerCandidates(s KeyStore, key *crypto.Key, paths []string) ([]*crypto.Key, error) {
+	var candidates []*crypto.Key
+	for _, path := range paths {
+		revoker, _, err := readKeyFile(path, true, true)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, revoker)
+	}
+	for _, fingerprint := range designatedRevokers(key) {
+		stored, err := s.Get(fingerprint)
+		if err != nil {
+			continue
+		}
+		if revoker, err := stored.Key(); err == nil {
+			candidates = append(candidates, revoker)
+		}
+	}
+	return candidates, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// revokedKeyArmored designates revokerKeyArmored as its revoker, which
+// revoked it (made with gpg --desig-revoke)
+const (
+	revokedKeyArmored = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatCRqRYJKwYBBAHaRw8BAQdACKFzmLVNEi38X3OkLMD8f5dPydOwD4TEMpYr
+RPbKebWIgAQgFggAKBYhBL4/nDPen+hi8vERZ3VtqOT8ZwVIBQJq0JHhCh0Ca2V5
+IGxvc3QACgkQdW2o5PxnBUiiMQEAid0yImZmQTOzEVxM1mxfkO4Sj3uFcskK6FWO
+3pn5z3EBAK257zS+0SrqMev7RZkXxBRGrxrSetH7IasIDc6k9oUBiJAEHxYIADgW
+IQTd+WNuZTgK1JAKHuk0fJTaaidMWgUCatCRqRcMgBa+P5wz3p/oYvLxEWd1bajk
+/GcFSAIHAAAKCRA0fJTaaidMWodJAQDXRFR8fIFDx9/ZnCJmbvQLzqpqP5fLsdV0
++IeqLjk/yQEAmFsObVe7WM/YAj/OFpXf3RBHBvS45CAuYqB3rfPt1Q20IlJldm9r
+ZWQgVXNlciA8cmV2b2tlZEBleGFtcGxlLmNvbT6IkAQTFggAOBYhBN35Y25lOArU
+kAoe6TR8lNpqJ0xaBQJq0JGpAhsDBQsJCAcCBhUKCQgLAgQWAgMBAh4BAheAAAoJ
+EDR8lNpqJ0xapmQA/2hC080wgOWYZB9QZlfw+SyRtXCTtLoCEwQZY8JJE0t/AP9v
+hogBhuZCUUhJgKJBTWxRm189MlFhbO3rhH0JL26cDLg4BGrQkakSCisGAQQBl1UB
+BQEBB0DpHpW3Me5lERRZ+ACfOyR0at3v9V+MrgdBxSKypixhKwMBCAeIeAQYFggA
+IBYhBN35Y25lOArUkAoe6TR8lNpqJ0xaBQJq0JGpAhsMAAoJEDR8lNpqJ0xapNIB
+AJwtQUEzWsL9YSOhn+7NbROZdD3sYPJqnJML+iJ5lf8SAQDuaExpSzIdlMHyV6Zk
+QUB3DzLhIrYg7sAV82hSPds3AQ==
+=GGRj
+-----END PGP PUBLIC KEY BLOCK-----`
+	revokerKeyArmored = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatCRqRYJKwYBBAHaRw8BAQdAHmNT52aqdAHzepxIhPviBrbOeusB+/G5/U0G
+ybLnE+G0HVJldm9rZXIgPHJldm9rZXJAZXhhbXBsZS5jb20+iJAEExYIADgWIQS+
+P5wz3p/oYvLxEWd1bajk/GcFSAUCatCRqQIbAQULCQgHAgYVCgkICwIEFgIDAQIe
+AQIXgAAKCRB1bajk/GcFSPO/AP9AwKpwnObvIpSlF4MaVWqDSctPdzuraKNEu6CF
+kJwYpwEA/4OYa5GXgy4NZe9gcb4sUzkNFocbupT7OWiNZJ7OAwY=
+=Sh/z
+-----END PGP PUBLIC KEY BLOCK-----`
+
+	revokedFingerprint = "ddf9636e65380ad4900a1ee9347c94da6a274c5a"
+	revokerFingerprint = "be3f9c33de9fe862f2f11167756da8e4fc670548"
+)
+
+func TestDesignatedRevocation(t *testing.T) {
+	revoked, err := crypto.NewKeyFromArmored(revokedKeyArmored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoker, err := crypto.NewKeyFromArmored(revokerKeyArmored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revokers := designatedRevokers(revoked); !slices.Equal(revokers, []string{revokerFingerprint}) {
+		t.Errorf("got revokers %v, expected [%s]", revokers, revokerFingerprint)
+	}
+	if revokers := designatedRevokers(ecKey); len(revokers) != 0 {
+		t.Errorf("got revokers %v for a key without any", revokers)
+	}
+	if !designatedRevocation(revoked, []*crypto.Key{revoker}) {
+		t.Error("designated revocation not detected")
+	}
+	// Without the revoker, or with a key that is not its designated revoker
+	if designatedRevocation(revoked, nil) || designatedRevocation(revoked, []*crypto.Key{ecKey}) {
+		t.Error("unverified revocation accepted")
+	}
+
+	useTestDB(t)
+	revokerFile := filepath.Join(t.TempDir(), "revoker.asc")
+	if err := os.WriteFile(revokerFile, []byte(revokerKeyArmored), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	revokedFile := filepath.Join(t.TempDir(), "revoked.asc")
+	if err := os.WriteFile(revokedFile, []byte(revokedKeyArmored), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{"--revoker", revokerFile, revokedFile}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(revokedFingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Revoked {
+		t.Error("revoked key stored as not revoked")
+	}
+	err = challenge([]string{"--tmpdir", t.TempDir(), "16", revokedFingerprint})
+	if !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("challenge: got %v, expected %v", err, ErrKeyRevoked)
+	}
+
+	// The revoker is also looked up in the store
+	if err := store.Delete(revokedFingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{revokerFile, revokedFile}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.Get(strings.ToUpper(revokedFingerprint)); !stored.Revoked {
+		t.Error("revocation by a stored revoker not detected")
+	}
+}
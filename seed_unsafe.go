@@ -0,0 +1,23 @@
+//go:build unsafe_seed
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand/v2"
+	"os"
+)
+
+// Builds with the unsafe_seed tag draw challenges from a generator seeded
+// with $PGP_MFA_UNSAFE_SEED, so that test harnesses get the same challenges
+// on every run. Anyone knowing the seed can solve them: NEVER ship such a
+// build. Only the challenges are reproducible, their encryption is not.
+func init() {
+	seed := os.Getenv("PGP_MFA_UNSAFE_SEED")
+	if seed == "" {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "WARNING: challenges are derived from PGP_MFA_UNSAFE_SEED, they are predictable, testing only")
+	challengeRand = rand.NewChaCha8(sha256.Sum256([]byte(seed)))
+}
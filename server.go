@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRequestSize bounds the json bodies accepted by the http api
+const maxRequestSize = 4 << 10
+
+var ErrChallengeNotFound = errors.New("unknown or already verified challenge")
+
+type challengeRequest struct {
+	Fingerprint string `json:"fingerprint"`
+	Length      int    `json:"length"`
+}
+
+type challengeResponse struct {
+	ID        string    `json:"id"`
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type verifyRequest struct {
+	ID       string `json:"id"`
+	Solution string `json:"solution"`
+}
+
+type verifyResponse struct {
+	Solved bool   `json:"solved"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type pendingChallenge struct {
+	fingerprint string
+	solution    []byte
+	expiresAt   time.Time
+}
+
+// challengeServer issues and verifies challenges over http, pending
+// challenges only live in memory
+type challengeServer struct {
+	mu         sync.Mutex
+	pending    map[string]pendingChallenge
+	now        func() time.Time
+	encryptors *encryptorCache
+	keep       int // challenges kept per key, see recordChallenge
+}
+
+func newChallengeServer() *challengeServer {
+	return &challengeServer{
+		pending:    make(map[string]pendingChallenge),
+		now:        time.Now,
+		encryptors: newEncryptorCache(),
+	}
+}
+
+func (s *challengeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /challenge", s.issue)
+	mux.HandleFunc("POST /verify", s.verify)
+	return mux
+}
+
+// decodeRequest reads a size limited json body without unknown fields
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return parseError("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps the error categories to http statuses
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrChallengeNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrRateLimited):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, ErrParse):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrPolicy):
+		status = http.StatusUnprocessableEntity
+	}
+	if status == http.StatusInternalServerError {
+		slog.Error(err.Error(), "event", "error", "command", "serve-http")
+		err = errors.New(http.StatusText(status))
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// sweep forgets the expired challenges, s.mu must be held
+func (s *challengeServer) sweep(now time.Time) {
+	for id, pending := range s.pending {
+		if pending.expiresAt.Before(now) {
+			delete(s.pending, id)
+		}
+	}
+}
+
+func (s *challengeServer) issue(w http.ResponseWriter, r *http.Request) {
+	var req challengeRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Fingerprint == "" {
+		writeError(w, parseError("missing fingerprint"))
+		return
+	}
+	cfg := defaultChallengeConfig(req.Length)
+	cfg.Encryptors = s.encryptors
+	if err := cfg.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	stored, err := store.Get(req.Fingerprint)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	key, err := stored.Key()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	now := s.now()
+	if stored.Expired || key.IsExpired(now.Unix()) {
+		writeError(w, policyError("%w: %s", ErrChallengeKeyExp, stored.Fingerprint))
+		return
+	}
+	if stored.Revoked {
+		writeError(w, policyError("%w: %s", ErrKeyRevoked, stored.Fingerprint))
+		return
+	}
+	if err := takeToken(key.GetFingerprint(), ChallengeRateBurst, ChallengeRateWindow, now); err != nil {
+		writeError(w, err)
+		return
+	}
+	issued, err := issueChallenge(key, cfg, now)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := recordChallenge(key.GetFingerprint(), issued, s.keep); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	s.sweep(now)
+	s.pending[issued.ID] = pendingChallenge{
+		fingerprint: key.GetFingerprint(),
+		solution:    issued.Solution,
+		expiresAt:   issued.ExpiresAt,
+	}
+	s.mu.Unlock()
+	slog.Info("challenge issued", "event", "challenge_issued", "fingerprint", key.GetFingerprint(), "challenge_id", issued.ID, "expires_at", issued.ExpiresAt)
+	writeJSON(w, http.StatusCreated, challengeResponse{ID: issued.ID, Challenge: issued.Armored, ExpiresAt: issued.ExpiresAt})
+}
+
+// verify checks a solution, every challenge can only be verified once so
+// that solutions cannot be guessed
+func (s *challengeServer) verify(w http.ResponseWriter, r *http.Request) {
+	var req verifyRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	now := s.now()
+	s.mu.Lock()
+	pending, ok := s.pending[req.ID]
+	delete(s.pending, req.ID)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, policyError("%w: %s", ErrChallengeNotFound, req.ID))
+		return
+	}
+	switch {
+	case pending.expiresAt.Before(now):
+		slog.Warn("challenge failed", "event", "challenge_failed", "fingerprint", pending.fingerprint, "challenge_id", req.ID, "error", "expired")
+		writeJSON(w, http.StatusOK, verifyResponse{Reason: "expired"})
+	case subtle.ConstantTimeCompare([]byte(req.Solution), pending.solution) == 1:
+		if err := markChallengeSolved(req.ID, now); errors.Is(err, ErrChallengeSuperseded) {
+			slog.Warn("challenge failed", "event", "challenge_failed", "fingerprint", pending.fingerprint, "challenge_id", req.ID, "error", err)
+			writeJSON(w, http.StatusOK, verifyResponse{Reason: "superseded"})
+			return
+		} else if err != nil {
+			writeError(w, err)
+			return
+		}
+		slog.Info("challenge solved", "event", "challenge_solved", "fingerprint", pending.fingerprint, "challenge_id", req.ID)
+		writeJSON(w, http.StatusOK, verifyResponse{Solved: true})
+	default:
+		slog.Warn("challenge failed", "event", "challenge_failed", "fingerprint", pending.fingerprint, "challenge_id", req.ID, "error", ErrIncorrectSolution)
+		writeJSON(w, http.StatusOK, verifyResponse{Reason: "incorrect"})
+	}
+}
+
+// serveHTTP runs the challenge http api until it fails
+func serveHTTP(args []string) error {
+	fs := flag.NewFlagSet("serve-http", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	certFile := fs.String("tls-cert", "", "certificate file, serves https along with --tls-key")
+	keyFile := fs.String("tls-key", "", "private key file of the certificate")
+	keep := fs.Int("keep", 0, "only keep the n most recent unsolved challenges of each key, 0 keeps them all")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa serve-http [--listen <addr>] [--tls-cert <file> --tls-key <file>] [--keep <n>]")
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		return errors.New("--tls-cert and --tls-key must be used together")
+	}
+	if *keep < 0 {
+		return parseError("--keep cannot be negative")
+	}
+	cs := newChallengeServer()
+	cs.keep = *keep
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           cs.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("serving challenges", "event", "serve_http", "address", *listen, "tls", *certFile != "")
+	if *certFile != "" {
+		err = server.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	return fmt.Errorf("http server stopped: %w", err)
+}
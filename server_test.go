@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+func postJSON(t *testing.T, url, body string, v any) int {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("invalid json response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServeHTTP(t *testing.T) {
+	useTestDB(t)
+	if err := store.Import(ecKey, KeyInfo{CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	cs := newChallengeServer()
+	server := httptest.NewServer(cs.handler())
+	defer server.Close()
+
+	issue := func() challengeResponse {
+		var issued challengeResponse
+		body := `{"fingerprint": "` + ecKey.GetFingerprint() + `", "length": 16}`
+		if status := postJSON(t, server.URL+"/challenge", body, &issued); status != http.StatusCreated {
+			t.Fatalf("got status %d, expected %d", status, http.StatusCreated)
+		}
+		return issued
+	}
+	issued := issue()
+	decHandle, err := crypto.PGP().Decryption().DecryptionKey(ecKey).New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decHandle.Decrypt([]byte(issued.Challenge), crypto.Armor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result verifyResponse
+	// solutions may contain quotes and backslashes
+	body, err := json.Marshal(verifyRequest{ID: issued.ID, Solution: string(decrypted.Bytes())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postJSON(t, server.URL+"/verify", string(body), &result)
+	if !result.Solved {
+		t.Errorf("correct solution rejected: %+v", result)
+	}
+	if records, err := listChallenges(ecKey.GetFingerprint(), true, time.Now()); err != nil || len(records) != 1 || !records[0].SolvedAt.Valid {
+		t.Errorf("solved challenge not recorded: %+v (%v)", records, err)
+	}
+	// Challenges are single use
+	if status := postJSON(t, server.URL+"/verify", `{"id": "`+issued.ID+`", "solution": "x"}`, nil); status != http.StatusNotFound {
+		t.Errorf("reused challenge: got status %d, expected %d", status, http.StatusNotFound)
+	}
+
+	issued = issue()
+	postJSON(t, server.URL+"/verify", `{"id": "`+issued.ID+`", "solution": "wrong"}`, &result)
+	if result.Solved || result.Reason != "incorrect" {
+		t.Errorf("wrong solution: got %+v", result)
+	}
+
+	issued = issue()
+	cs.now = func() time.Time { return time.Now().Add(2 * ChallengeSolveTime) }
+	postJSON(t, server.URL+"/verify", `{"id": "`+issued.ID+`", "solution": "whatever"}`, &result)
+	if result.Solved || result.Reason != "expired" {
+		t.Errorf("expired challenge: got %+v", result)
+	}
+	cs.now = time.Now
+
+	// Only the newest challenge is kept, the older one cannot be solved
+	cs.keep = 1
+	older := issue()
+	issue()
+	decrypted, err = decHandle.Decrypt([]byte(older.Challenge), crypto.Armor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = json.Marshal(verifyRequest{ID: older.ID, Solution: string(decrypted.Bytes())})
+	postJSON(t, server.URL+"/verify", string(body), &result)
+	if result.Solved || result.Reason != "superseded" {
+		t.Errorf("superseded challenge: got %+v", result)
+	}
+	cs.keep = 0
+
+	for body, expected := range map[string]int{
+		`not json`:                              http.StatusBadRequest,
+		`{"fingerprint": "abcd", "extra": 1}`:   http.StatusBadRequest,
+		`{"length": 16}`:                        http.StatusBadRequest,
+		`{"fingerprint": "abcd", "length": 16}`: http.StatusNotFound,
+		`{"fingerprint": "abcd", "length": 15}`: http.StatusUnprocessableEntity,
+	} {
+		if status := postJSON(t, server.URL+"/challenge", body, nil); status != expected {
+			t.Errorf("%s: got status %d, expected %d", body, status, expected)
+		}
+	}
+	resp, err := http.Get(server.URL + "/challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, expected %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// getSetting reads a database wide setting, nil when it is not set
+func getSetting(conn *sql.DB, name string) ([]byte, error) {
+	var value []byte
+	err := conn.QueryRow(`SELECT value FROM settings WHERE name = ?`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, dbError("failed to query setting %s: %w", name, err)
+	}
+	return value, nil
+}
+
+func setSetting(conn *sql.DB, name string, value []byte) error {
+	_, err := conn.Exec(`INSERT INTO settings (name, value) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value`,
+		name,
+		value,
+	)
+	if err != nil {
+		return dbError("failed to store setting %s: %w", name, err)
+	}
+	return nil
+}
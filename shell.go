@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var ErrUnclosedQuote = errors.New("unclosed quote")
+
+// shell dispatches through commands, registering it there directly would be
+// an initialization cycle
+func init() {
+	commands["shell"] = shell
+}
+
+// splitCommandLine splits a shell command line into arguments, single and
+// double quotes group words
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, parseError("%w", ErrUnclosedQuote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// shell runs commands read from stdin against the already opened store until
+// exit or end of input. Failing commands are reported without leaving.
+func shell(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa shell")
+	}
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	var history []string
+	for {
+		if interactive {
+			fmt.Print("pgp-mfa> ")
+		}
+		line, err := stdin.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read command: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		// !n reruns the nth history entry
+		if n, ok := strings.CutPrefix(line, "!"); ok {
+			i, err := strconv.Atoi(n)
+			if err != nil || i < 1 || i > len(history) {
+				fmt.Printf("no history entry '%s'\n", n)
+				continue
+			}
+			line = history[i-1]
+			fmt.Println(line)
+		}
+		words, err := splitCommandLine(line)
+		if err != nil {
+			logError("shell", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		history = append(history, line)
+		switch cmd := words[0]; cmd {
+		case "exit", "quit":
+			return nil
+		case "history":
+			for i, entry := range history {
+				fmt.Printf("%4d  %s\n", i+1, entry)
+			}
+		case "shell":
+			fmt.Println("already in a shell")
+		default:
+			fn, ok := commands[cmd]
+			if !ok {
+				fmt.Printf("unknown command '%s', use 'help' for more info\n", cmd)
+				continue
+			}
+			if err := fn(words[1:]); err != nil {
+				logError(canonicalCommand(cmd), err)
+			}
+		}
+	}
+}
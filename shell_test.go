@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	for line, expected := range map[string][]string{
+		"":                             nil,
+		"  list  --sort userid ":       {"list", "--sort", "userid"},
+		`label abcd "Alice's laptop"`:  {"label", "abcd", "Alice's laptop"},
+		`label abcd 'say "hi"' ''`:     {"label", "abcd", `say "hi"`, ""},
+		`import --label=a" "b key.asc`: {"import", "--label=a b", "key.asc"},
+	} {
+		got, err := splitCommandLine(line)
+		if err != nil || !slices.Equal(got, expected) {
+			t.Errorf("%q: got %q, %v, expected %q", line, got, err, expected)
+		}
+	}
+	if _, err := splitCommandLine(`label abcd "laptop`); !errors.Is(err, ErrUnclosedQuote) {
+		t.Errorf("got %v, expected %v", err, ErrUnclosedQuote)
+	}
+}
+
+func TestShell(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	key := ecKey.GetFingerprint()
+	// failing and unknown commands don't end the session, exit does
+	useTestStdin(t, "label nope x\nfrobnicate\nlabel "+key+" 'work laptop'\nhistory\n!3\nexit\nlabel "+key+" ignored\n")
+	if err := shell(nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Label != "work laptop" {
+		t.Errorf("got label %q, expected %q", stored.Label, "work laptop")
+	}
+}
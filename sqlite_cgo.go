@@ -0,0 +1,26 @@
+//go:build cgo && !purego
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteAvailable reports why the sqlite driver cannot open databases,
+// cgo builds always can
+func sqliteAvailable() error {
+	return nil
+}
+
+// sqliteOpen opens a "file:" dsn with the sqlite driver of the build
+func sqliteOpen(dsn string) (*sql.DB, error) {
+	return sql.Open("sqlite3", dsn)
+}
+
+func sqliteReadOnly(err error) bool {
+	sqliteErr := sqlite3.Error{}
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrReadonly
+}
@@ -0,0 +1,20 @@
+//go:build !cgo && !purego
+
+package main
+
+import "database/sql"
+
+// Without cgo github.com/mattn/go-sqlite3 only registers a stub driver whose
+// connections all fail with a generic message, opening a database reports
+// how to get a working build instead.
+func sqliteAvailable() error {
+	return dbError("%w: this binary was built with CGO_ENABLED=0 and github.com/mattn/go-sqlite3 needs cgo, rebuild with CGO_ENABLED=1 and a C compiler (gcc or clang) installed, or with -tags purego", ErrNoSQLiteDriver)
+}
+
+func sqliteOpen(string) (*sql.DB, error) {
+	return nil, sqliteAvailable()
+}
+
+func sqliteReadOnly(error) bool {
+	return false
+}
@@ -0,0 +1,35 @@
+//go:build purego
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Builds with the purego tag use modernc.org/sqlite, a translation of sqlite
+// to go, so that static binaries cross-compile without cgo. Both drivers
+// read the same database files.
+func sqliteAvailable() error {
+	return nil
+}
+
+// sqliteOpen opens a "file:" dsn, times are written in the format of
+// github.com/mattn/go-sqlite3 as the time columns are compared as text
+func sqliteOpen(dsn string) (*sql.DB, error) {
+	if strings.Contains(dsn, "?") {
+		dsn += "&_time_format=sqlite"
+	} else {
+		dsn += "?_time_format=sqlite"
+	}
+	return sql.Open("sqlite", dsn)
+}
+
+func sqliteReadOnly(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_READONLY
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+var (
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrUnsupportedScheme = errors.New("unsupported database scheme")
+)
+
+// KeyStore persists the enrolled public keys, fingerprints are matched case
+// insensitively
+type KeyStore interface {
+	Import(key *crypto.Key, info KeyInfo) error
+	Get(fingerprint string) (StoredKey, error)
+	// List returns the stored keys matching q, newest first
+	List(q KeyQuery) ([]StoredKey, error)
+	// Count returns the number of stored keys matching the filters of q,
+	// without loading them
+	Count(q KeyQuery) (int, error)
+	// Update applies update to the metadata of a stored key
+	Update(fingerprint string, update func(*KeyInfo)) error
+	// Replace stores a new version of a stored key (new subkeys, extended
+	// expiry...), its metadata is kept
+	Replace(key *crypto.Key) error
+	Delete(fingerprint string) error
+	// Atomic runs fn against a transactional view of the store, nothing
+	// fn did is kept when it returns an error
+	Atomic(fn func(KeyStore) error) error
+}
+
+// KeyInfo is the metadata stored along with a key
+type KeyInfo struct {
+	CreatedAt time.Time
+	Label     string
+	Card      bool   // the private key lives on an OpenPGP smartcard
+	Expired   bool   // imported past its expiry with --allow-expired
+	Trust     string // one of trustLevels, empty is the default
+	Revoked   bool   // revoked by its designated revoker
+	Defaults  ChallengeDefaults
+	// ArmorHeaders are the armor header lines of the imported key, one
+	// "Name: value" per line, only kept with import --preserve-headers
+	ArmorHeaders string
+	// Origin is the url the key was downloaded from, refresh fetches it again
+	Origin string
+}
+
+var ErrSortColumn = errors.New("unknown sort column")
+
+// keySortOrders maps the sort options to ORDER BY clauses, only these reach
+// the query
+var keySortOrders = map[string]string{
+	"created":     "created_at DESC",
+	"fingerprint": "fingerprint",
+	"userid":      "user_id, fingerprint",
+}
+
+// KeyQuery filters and pages List, zero fields match everything
+type KeyQuery struct {
+	Since    time.Time // imported at or after
+	Before   time.Time // imported strictly before
+	Sort     string    // a keySortOrders key, created (newest first) by default
+	MinTrust string    // only keys trusted at least this much
+	Limit    int       // no limit when 0
+	Offset   int
+}
+
+func (q KeyQuery) validate() error {
+	if _, ok := keySortOrders[q.Sort]; !ok && q.Sort != "" {
+		return parseError("%w '%s', expected created, fingerprint or userid", ErrSortColumn, q.Sort)
+	}
+	if q.MinTrust != "" && !slices.Contains(trustLevels, q.MinTrust) {
+		return parseError("%w: '%s'", ErrTrustLevel, q.MinTrust)
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return parseError("limit and offset cannot be negative")
+	}
+	return nil
+}
+
+func (q KeyQuery) match(k StoredKey) bool {
+	return (q.Since.IsZero() || !k.CreatedAt.Before(q.Since)) &&
+		(q.Before.IsZero() || k.CreatedAt.Before(q.Before)) &&
+		trustAtLeast(k.Trust, q.MinTrust)
+}
+
+type StoredKey struct {
+	Fingerprint string
+	PubKey      []byte
+	UserID      string // primary user id, derived from the key
+	KeyInfo
+}
+
+// primaryUserID returns the primary user id of key, or the first one in
+// alphabetical order when none is valid (e.g. expired keys)
+func primaryUserID(key *crypto.Key) string {
+	entity := key.GetEntity()
+	if _, identity := entity.PrimaryIdentity(time.Now(), nil); identity != nil {
+		return identity.Name
+	}
+	var names []string
+	for _, identity := range entity.Identities {
+		names = append(names, identity.Name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// storedUserID is the user id written to the user_id column, none when
+// fingerprints are hashed as it would identify the key
+func storedUserID(key *crypto.Key) string {
+	if fingerprintSalt != nil {
+		return ""
+	}
+	return primaryUserID(key)
+}
+
+// Key parses the stored public key
+func (k StoredKey) Key() (*crypto.Key, error) {
+	key, err := crypto.NewKeyFromReader(bytes.NewReader(k.PubKey))
+	if err != nil {
+		return nil, parseError("failed to parse key: %w", err)
+	}
+	return key, nil
+}
+
+// openStore selects the key store backend from the dsn scheme:
+//
+//	sqlite:<path> or <path>  sqlite database file
+//	memory:                  ephemeral in-memory store
+//
+// It also returns the sql database holding the other tables (totp, rate
+// limits...), in memory for the memory backend.
+func openStore(dsn string) (KeyStore, *sql.DB, error) {
+	scheme, rest, found := strings.Cut(dsn, ":")
+	if !found || len(scheme) < 2 { // no scheme or a windows drive letter
+		scheme, rest = "sqlite", dsn
+	}
+	switch scheme {
+	case "sqlite":
+		conn, err := openDB(strings.TrimPrefix(rest, "//"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if fingerprintSalt, err = getSetting(conn, fingerprintSaltSetting); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if readOnlyDB != "" {
+			slog.Debug("skipping user id backfill on read-only database", "event", "read_only_db")
+		} else if err := backfillUserIDs(conn); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return &sqliteStore{db: conn, tenant: activeTenant}, conn, nil
+	case "memory":
+		conn, err := openDB(":memory:")
+		if err != nil {
+			return nil, nil, err
+		}
+		// every connection to :memory: is a distinct database
+		conn.SetMaxOpenConns(1)
+		fingerprintSalt = nil
+		return newMemStore(activeTenant), conn, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, scheme)
+	}
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// sqliteStore only sees the keys of its tenant
+type sqliteStore struct {
+	db     dbtx
+	tenant string
+}
+
+// inTx runs fn in a transaction, joining the current one if any
+func (s *sqliteStore) inTx(fn func(tx dbtx) error) error {
+	conn, ok := s.db.(*sql.DB)
+	if !ok {
+		return fn(s.db)
+	}
+	tx, err := conn.BeginTx(commandCtx, nil)
+	if err != nil {
+		return dbError("failed to start transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return dbError("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) Atomic(fn func(KeyStore) error) error {
+	return s.inTx(func(tx dbtx) error {
+		return fn(&sqliteStore{db: tx, tenant: s.tenant})
+	})
+}
+
+const keyColumns = `fingerprint, pub_key, created_at, label, card, expired, user_id, trust, revoked,
+	challenge_length, solve_time, timeout_action, armor_headers, origin`
+
+func scanKey(row interface{ Scan(...any) error }) (StoredKey, error) {
+	var k StoredKey
+	err := row.Scan(&k.Fingerprint, &k.PubKey, &k.CreatedAt, &k.Label, &k.Card, &k.Expired, &k.UserID, &k.Trust, &k.Revoked,
+		&k.Defaults.Length, &k.Defaults.SolveTime, &k.Defaults.TimeoutAction, &k.ArmorHeaders, &k.Origin)
+	return k, err
+}
+
+func (s *sqliteStore) Import(key *crypto.Key, info KeyInfo) error {
+	pubKey, err := key.GetPublicKey()
+	if err != nil {
+		return parseError("%w: %w", ErrPubKeyFail, err)
+	}
+	res, err := s.db.Exec(`INSERT INTO keys (tenant, `+keyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tenant, fingerprint) DO NOTHING`,
+		s.tenant,
+		fingerprintID(key.GetFingerprint()),
+		pubKey,
+		info.CreatedAt,
+		info.Label,
+		info.Card,
+		info.Expired,
+		storedUserID(key),
+		cmp.Or(info.Trust, defaultTrust),
+		info.Revoked,
+		info.Defaults.Length,
+		info.Defaults.SolveTime,
+		info.Defaults.TimeoutAction,
+		info.ArmorHeaders,
+		info.Origin,
+	)
+	if err != nil {
+		return dbError("key import error: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return policyError("%w: %s", ErrAlreadyImported, key.GetFingerprint())
+	}
+	return nil
+}
+
+func (s *sqliteStore) Get(fingerprint string) (StoredKey, error) {
+	row := s.db.QueryRow(`SELECT `+keyColumns+` FROM keys WHERE tenant = ? AND fingerprint IN (?, ?)`, s.tenantIDs(fingerprint)...)
+	k, err := scanKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return k, policyError("%w: %s", ErrKeyNotFound, fingerprint)
+	}
+	if err != nil {
+		return k, dbError("failed to query key: %w", err)
+	}
+	return k, nil
+}
+
+// where builds the WHERE clause and arguments of the filters of q
+func (s *sqliteStore) where(q KeyQuery) (string, []any) {
+	where := []string{`tenant = ?`}
+	args := []any{s.tenant}
+	if !q.Since.IsZero() {
+		// created_at is compared as text, bind in the zone keys were stored in
+		where = append(where, `created_at >= ?`)
+		args = append(args, q.Since.Local())
+	}
+	if !q.Before.IsZero() {
+		where = append(where, `created_at < ?`)
+		args = append(args, q.Before.Local())
+	}
+	if q.MinTrust != "" {
+		levels := trustedLevels(q.MinTrust)
+		where = append(where, `trust IN (?`+strings.Repeat(`, ?`, len(levels)-1)+`)`)
+		for _, level := range levels {
+			args = append(args, level)
+		}
+	}
+	return strings.Join(where, ` AND `), args
+}
+
+func (s *sqliteStore) List(q KeyQuery) ([]StoredKey, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	where, args := s.where(q)
+	query := `SELECT ` + keyColumns + ` FROM keys WHERE ` + where
+	query += ` ORDER BY ` + keySortOrders[cmp.Or(q.Sort, "created")]
+	if q.Limit > 0 || q.Offset > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, cmp.Or(q.Limit, -1), q.Offset) // -1 is no limit
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, dbError("failed to query keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []StoredKey
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, dbError("failed to scan row: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("failed to iterate keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *sqliteStore) Count(q KeyQuery) (int, error) {
+	if err := q.validate(); err != nil {
+		return 0, err
+	}
+	where, args := s.where(q)
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM keys WHERE `+where, args...).Scan(&n); err != nil {
+		return 0, dbError("failed to count keys: %w", err)
+	}
+	return n, nil
+}
+
+func (s *sqliteStore) Update(fingerprint string, update func(*KeyInfo)) error {
+	return s.inTx(func(tx dbtx) error {
+		k, err := scanKey(tx.QueryRow(`SELECT `+keyColumns+` FROM keys WHERE tenant = ? AND fingerprint IN (?, ?)`, s.tenantIDs(fingerprint)...))
+		if errors.Is(err, sql.ErrNoRows) {
+			return policyError("%w: %s", ErrKeyNotFound, fingerprint)
+		}
+		if err != nil {
+			return dbError("failed to query key: %w", err)
+		}
+		update(&k.KeyInfo)
+		_, err = tx.Exec(`UPDATE keys SET label = ?, card = ?, expired = ?, trust = ?, revoked = ?,
+			challenge_length = ?, solve_time = ?, timeout_action = ?, armor_headers = ?, origin = ? WHERE tenant = ? AND fingerprint = ?`,
+			k.Label,
+			k.Card,
+			k.Expired,
+			cmp.Or(k.Trust, defaultTrust),
+			k.Revoked,
+			k.Defaults.Length,
+			k.Defaults.SolveTime,
+			k.Defaults.TimeoutAction,
+			k.ArmorHeaders,
+			k.Origin,
+			s.tenant,
+			k.Fingerprint,
+		)
+		if err != nil {
+			return dbError("key update error: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) Replace(key *crypto.Key) error {
+	pubKey, err := key.GetPublicKey()
+	if err != nil {
+		return parseError("%w: %w", ErrPubKeyFail, err)
+	}
+	res, err := s.db.Exec(`UPDATE keys SET pub_key = ?, user_id = ? WHERE tenant = ? AND fingerprint IN (?, ?)`,
+		append([]any{pubKey, storedUserID(key)}, s.tenantIDs(key.GetFingerprint())...)...)
+	if err != nil {
+		return dbError("key update error: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return policyError("%w: %s", ErrKeyNotFound, key.GetFingerprint())
+	}
+	return nil
+}
+
+// tenantIDs are the arguments of a `tenant = ? AND fingerprint IN (?, ?)`
+// clause
+func (s *sqliteStore) tenantIDs(fingerprint string) []any {
+	return append([]any{s.tenant}, lookupIDs(fingerprint)...)
+}
+
+func (s *sqliteStore) Delete(fingerprint string) error {
+	res, err := s.db.Exec(`DELETE FROM keys WHERE tenant = ? AND fingerprint IN (?, ?)`, s.tenantIDs(fingerprint)...)
+	if err != nil {
+		return dbError("key delete error: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return policyError("%w: %s", ErrKeyNotFound, fingerprint)
+	}
+	return nil
+}
+
+// backfillUserIDs fills the user_id column of keys imported before it
+// existed, unless fingerprints are hashed
+func backfillUserIDs(conn *sql.DB) error {
+	if fingerprintSalt != nil {
+		return nil
+	}
+	rows, err := conn.Query(`SELECT fingerprint, pub_key FROM keys WHERE user_id = ''`)
+	if err != nil {
+		return dbError("failed to query keys: %w", err)
+	}
+	userIDs := make(map[string]string)
+	for rows.Next() {
+		var k StoredKey
+		if err := rows.Scan(&k.Fingerprint, &k.PubKey); err != nil {
+			rows.Close()
+			return dbError("failed to scan row: %w", err)
+		}
+		if key, err := k.Key(); err == nil {
+			userIDs[k.Fingerprint] = primaryUserID(key)
+		}
+	}
+	rows.Close()
+	for fingerprint, userID := range userIDs {
+		if _, err := conn.Exec(`UPDATE keys SET user_id = ? WHERE fingerprint = ?`, userID, fingerprint); err != nil {
+			return dbError("failed to update user id: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeyStore(t *testing.T, s KeyStore) {
+	now := time.Now()
+	if n, err := s.Count(KeyQuery{}); err != nil || n != 0 {
+		t.Errorf("empty store: got %d keys (%v)", n, err)
+	}
+	if err := s.Import(ecKey, KeyInfo{CreatedAt: now.Add(-time.Hour), Label: "ec", Card: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(rsa3072Key, KeyInfo{CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(ecKey, KeyInfo{CreatedAt: now}); !errors.Is(err, ErrAlreadyImported) {
+		t.Errorf("duplicate import: got %v, expected %v", err, ErrAlreadyImported)
+	}
+
+	stored, err := s.Get(strings.ToUpper(ecKey.GetFingerprint()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Label != "ec" || !stored.Card {
+		t.Errorf("got %+v, expected label %q on a smartcard", stored.KeyInfo, "ec")
+	}
+	key, err := stored.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.GetFingerprint() != ecKey.GetFingerprint() || key.IsPrivate() {
+		t.Errorf("got %s (private: %v), expected public %s", key.GetFingerprint(), key.IsPrivate(), ecKey.GetFingerprint())
+	}
+
+	keys, err := s.List(KeyQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Fingerprint != rsa3072Key.GetFingerprint() {
+		t.Errorf("expected 2 keys newest first, got %+v", keys)
+	}
+	if keys, err = s.List(KeyQuery{Before: now.Add(-time.Minute)}); err != nil || len(keys) != 1 || keys[0].Label != "ec" {
+		t.Errorf("before filter: got %+v (%v), expected the ec key only", keys, err)
+	}
+	if keys, err = s.List(KeyQuery{Since: now.Add(-time.Minute)}); err != nil || len(keys) != 1 || keys[0].Fingerprint != rsa3072Key.GetFingerprint() {
+		t.Fatalf("since filter: got %+v (%v), expected the rsa key only", keys, err)
+	}
+	if !strings.Contains(keys[0].UserID, "test@example.com") {
+		t.Errorf("got user id %q", keys[0].UserID)
+	}
+	first, second := ecKey.GetFingerprint(), rsa3072Key.GetFingerprint()
+	if first > second {
+		first, second = second, first
+	}
+	if keys, err = s.List(KeyQuery{Sort: "fingerprint"}); err != nil || len(keys) != 2 || keys[0].Fingerprint != first {
+		t.Errorf("fingerprint sort: got %+v (%v), expected %s first", keys, err, first)
+	}
+	if keys, err = s.List(KeyQuery{Sort: "userid", Limit: 1, Offset: 1}); err != nil || len(keys) != 1 || keys[0].Fingerprint != second {
+		t.Errorf("paging: got %+v (%v), expected %s only", keys, err, second)
+	}
+	if keys, err = s.List(KeyQuery{Offset: 1}); err != nil || len(keys) != 1 || keys[0].Label != "ec" {
+		t.Errorf("offset without limit: got %+v (%v), expected the oldest key only", keys, err)
+	}
+	if n, err := s.Count(KeyQuery{Since: now.Add(-time.Minute)}); err != nil || n != 1 {
+		t.Errorf("count since: got %d (%v), expected 1", n, err)
+	}
+	if n, err := s.Count(KeyQuery{}); err != nil || n != 2 {
+		t.Errorf("count: got %d (%v), expected 2", n, err)
+	}
+	if keys, err = s.List(KeyQuery{MinTrust: "unknown"}); err != nil || len(keys) != 2 {
+		t.Errorf("default trust: got %d keys (%v), expected 2", len(keys), err)
+	}
+	if keys, err = s.List(KeyQuery{MinTrust: "marginal"}); err != nil || len(keys) != 0 {
+		t.Errorf("min trust: got %d keys (%v), expected none", len(keys), err)
+	}
+	if _, err = s.List(KeyQuery{Sort: "pub_key; DROP TABLE keys"}); !errors.Is(err, ErrSortColumn) {
+		t.Errorf("got %v, expected %v", err, ErrSortColumn)
+	}
+
+	err = s.Update(ecKey.GetFingerprint(), func(info *KeyInfo) {
+		info.Label = "updated"
+		info.ArmorHeaders = "Comment: updated"
+		info.Origin = "https://example.com/key.asc"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = s.Get(ecKey.GetFingerprint()); stored.Label != "updated" || stored.ArmorHeaders != "Comment: updated" || stored.Origin != "https://example.com/key.asc" {
+		t.Errorf("got label %q, headers %q and origin %q after update", stored.Label, stored.ArmorHeaders, stored.Origin)
+	}
+
+	// Replacing the key keeps its metadata
+	if err := s.Replace(ecKey); err != nil {
+		t.Fatal(err)
+	}
+	if replaced, _ := s.Get(ecKey.GetFingerprint()); replaced.KeyInfo != stored.KeyInfo || !bytes.Equal(replaced.PubKey, stored.PubKey) {
+		t.Errorf("got %+v after replace, expected %+v", replaced.KeyInfo, stored.KeyInfo)
+	}
+	if err := s.Replace(rsa4092Key); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown key: got %v, expected %v", err, ErrKeyNotFound)
+	}
+
+	// A failing atomic block leaves the store untouched
+	boom := errors.New("boom")
+	err = s.Atomic(func(tx KeyStore) error {
+		if err := tx.Import(rsa4092Key, KeyInfo{CreatedAt: now}); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Errorf("got %v, expected %v", err, boom)
+	}
+	if _, err := s.Get(rsa4092Key.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("rolled back import still visible: %v", err)
+	}
+	err = s.Atomic(func(tx KeyStore) error {
+		return tx.Import(rsa4092Key, KeyInfo{CreatedAt: now.Add(-2 * time.Hour)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(rsa4092Key.GetFingerprint()); err != nil {
+		t.Errorf("committed import missing: %v", err)
+	}
+
+	if err := s.Delete(ecKey.GetFingerprint()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("deleted key: got %v, expected %v", err, ErrKeyNotFound)
+	}
+	if err := s.Delete(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("double delete: got %v, expected %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestSqliteStore(t *testing.T) {
+	s, conn, err := openStore("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testKeyStore(t, s)
+}
+
+func TestMemStore(t *testing.T) {
+	testKeyStore(t, newMemStore(defaultTenant))
+}
+
+func TestParseDate(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Time{
+		"":                     {},
+		"2024-06-01T08:00:00Z": time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC),
+		"2024-06-01":           time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local),
+		"7d":                   now.Add(-7 * 24 * time.Hour),
+		"2w":                   now.Add(-14 * 24 * time.Hour),
+		"90m":                  now.Add(-90 * time.Minute),
+	} {
+		got, err := parseDate(value, now)
+		if err != nil {
+			t.Errorf("%q: %v", value, err)
+		} else if !got.Equal(expected) {
+			t.Errorf("%q: got %v, expected %v", value, got, expected)
+		}
+	}
+	for _, value := range []string{"yesterday", "-3d", "d", "2024-13-01"} {
+		if _, err := parseDate(value, now); !errors.Is(err, ErrParse) {
+			t.Errorf("%q: got %v, expected a parse error", value, err)
+		}
+	}
+}
+
+func TestOpenStoreScheme(t *testing.T) {
+	if _, _, err := openStore("postgres://localhost/pgp-mfa"); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("got %v, expected %v", err, ErrUnsupportedScheme)
+	}
+}
+
+func TestBackfillUserIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	s, conn, err := openStore("sqlite:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(ecKey, KeyInfo{CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// As imported before the column existed
+	if _, err := conn.Exec(`UPDATE keys SET user_id = ''`); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	s, conn, err = openStore("sqlite:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stored, err := s.Get(ecKey.GetFingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserID != primaryUserID(ecKey) || stored.UserID == "" {
+		t.Errorf("got user id %q, expected %q", stored.UserID, primaryUserID(ecKey))
+	}
+}
+
+func TestReadOnlyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	s, conn, err := openStore("sqlite:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(ecKey, KeyInfo{CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := os.Chmod(path, 0o444); err != nil {
+		t.Fatal(err)
+	}
+	dsn := "sqlite:" + path
+	if dbWritable(path) {
+		// root ignores file permissions, ask sqlite for read-only explicitly
+		dsn += "?mode=ro"
+	}
+
+	s, conn, err = openStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if keys, err := s.List(KeyQuery{}); err != nil || len(keys) != 1 {
+		t.Fatalf("got %d keys, %v, expected reads to work", len(keys), err)
+	}
+	err = s.Import(rsa3072Key, KeyInfo{CreatedAt: time.Now()})
+	if !errors.Is(err, ErrReadOnlyDB) || !errors.Is(err, ErrDatabase) {
+		t.Fatalf("got %v, expected %v", err, ErrReadOnlyDB)
+	}
+	if !strings.Contains(err.Error(), path) {
+		t.Errorf("error %q does not name the database", err)
+	}
+
+	// Challenges are still issued, rate limited in memory
+	prevStore, prevDB := store, db
+	store, db = s, conn
+	defer func() { store, db, readOnlyBuckets = prevStore, prevDB, map[[2]string]rateBucket{} }()
+	args := []string{"--tmpdir", t.TempDir(), "--self-solve", writeKeyFile(t, ecKey, true), "--rate-limit", "1", "16", ecKey.GetFingerprint()}
+	if err := challenge(args); err != nil {
+		t.Fatalf("challenge on a read-only database: %v", err)
+	}
+	if err := challenge(args); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, expected %v", err, ErrRateLimited)
+	}
+}
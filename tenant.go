@@ -0,0 +1,21 @@
+package main
+
+import "errors"
+
+const defaultTenant = "default"
+
+var (
+	ErrTenantName = errors.New("invalid tenant name, use letters, digits, '-' and '_'")
+
+	// activeTenant scopes every key, totp secret and rate limit of the
+	// sqlite store, set by the global --tenant flag. Tenants share the
+	// database but never see each other's keys.
+	activeTenant = defaultTenant
+)
+
+func validateTenant(name string) error {
+	if !profileName.MatchString(name) {
+		return parseError("%w: '%s'", ErrTenantName, name)
+	}
+	return nil
+}
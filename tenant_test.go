@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTenants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.db")
+	t.Cleanup(func() { activeTenant = defaultTenant })
+	testTenants(t, func(tenant string) KeyStore {
+		t.Helper()
+		activeTenant = tenant
+		s, conn, err := openStore("sqlite:" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return s
+	})
+	mem := newMemStore(defaultTenant)
+	testTenants(t, func(tenant string) KeyStore {
+		return &memStore{data: mem.data, tenant: tenant}
+	})
+
+	if err := validateTenant("../acme"); !errors.Is(err, ErrTenantName) {
+		t.Errorf("got %v, expected %v", err, ErrTenantName)
+	}
+}
+
+// testTenants checks that the stores returned by open only see the keys of
+// their tenant
+func testTenants(t *testing.T, open func(tenant string) KeyStore) {
+	now := time.Now()
+	acme, globex := open("acme"), open("globex")
+	if err := acme.Import(ecKey, KeyInfo{CreatedAt: now, Label: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := globex.Get(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("other tenant's key: got %v, expected %v", err, ErrKeyNotFound)
+	}
+	if keys, err := globex.List(KeyQuery{}); err != nil || len(keys) != 0 {
+		t.Errorf("other tenant's keys listed: %d, %v", len(keys), err)
+	}
+	if n, err := globex.Count(KeyQuery{}); err != nil || n != 0 {
+		t.Errorf("other tenant's keys counted: %d, %v", n, err)
+	}
+	if err := globex.Delete(ecKey.GetFingerprint()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("other tenant's key deleted: %v", err)
+	}
+	// The same key can be enrolled by both tenants
+	if err := globex.Import(ecKey, KeyInfo{CreatedAt: now, Label: "globex"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := globex.Update(ecKey.GetFingerprint(), func(info *KeyInfo) { info.Trust = "full" }); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := acme.Get(ecKey.GetFingerprint())
+	if err != nil || stored.Label != "acme" || stored.Trust != defaultTrust {
+		t.Errorf("acme key changed by globex: %+v, %v", stored.KeyInfo, err)
+	}
+	if err := globex.Delete(ecKey.GetFingerprint()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.Get(ecKey.GetFingerprint()); err != nil {
+		t.Errorf("acme key deleted by globex: %v", err)
+	}
+}
+
+func TestTenantCommands(t *testing.T) {
+	useTestDB(t)
+	t.Cleanup(func() { activeTenant = defaultTenant })
+	fingerprint := ecKey.GetFingerprint()
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := totpEnroll([]string{fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another tenant of the same database
+	prevStore := store
+	store, activeTenant = &memStore{data: store.(*memStore).data, tenant: "globex"}, "globex"
+	defer func() { store = prevStore }()
+	commands := map[string]func([]string) error{
+		"show":   showKey,
+		"delete": deleteKey,
+		"export": func(args []string) error {
+			return exportKeys(append([]string{"--output", filepath.Join(t.TempDir(), "key.asc")}, args...))
+		},
+		"challenge": func(args []string) error { return challenge(append([]string{"16"}, args...)) },
+		"refresh":   refresh,
+	}
+	for name, command := range commands {
+		if err := command([]string{fingerprint}); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: got %v, expected %v", name, err, ErrKeyNotFound)
+		}
+	}
+	if err := totpVerify([]string{fingerprint, "000000"}); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Errorf("totp verify: got %v, expected %v", err, ErrTOTPNotEnrolled)
+	}
+	if n, err := store.Count(KeyQuery{}); err != nil || n != 0 {
+		t.Errorf("other tenant's keys counted: %d, %v", n, err)
+	}
+
+	store, activeTenant = prevStore, defaultTenant
+	if _, err := store.Get(fingerprint); err != nil {
+		t.Errorf("key gone from its tenant: %v", err)
+	}
+}
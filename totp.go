@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpDigits     = 6
+	totpPeriod     = 30 * time.Second
+	totpSkew       = 1 // number of periods accepted before / after the current one
+	totpSecretSize = 20
+
+	// totpMaxAttempts invalid codes disable the totp fallback of a challenge
+	totpMaxAttempts = 3
+)
+
+var (
+	ErrTOTPInvalid     = errors.New("invalid totp code")
+	ErrTOTPNotEnrolled = errors.New("no totp secret enrolled for this key")
+	ErrTOTPReplayed    = errors.New("totp code already used")
+
+	// readOnlyTOTPCounters hold the last accepted time steps when the
+	// database cannot be written, they last as long as the process
+	readOnlyTOTPCounters   = map[[2]string]int64{}
+	readOnlyTOTPCountersMu sync.Mutex
+)
+
+// totpCounter is the RFC 6238 time step of t
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode computes the RFC 6238 code (HMAC-SHA1, 6 digits) for time t
+func totpCode(secret []byte, t time.Time) string {
+	return totpCounterCode(secret, totpCounter(t))
+}
+
+func totpCounterCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP checks code against the periods surrounding t and returns the
+// time step it belongs to
+func matchTOTP(secret []byte, code string, t time.Time) (int64, bool) {
+	var counter int64
+	valid := false
+	for i := -totpSkew; i <= totpSkew; i++ {
+		c := totpCounter(t) + int64(i)
+		if subtle.ConstantTimeCompare([]byte(code), []byte(totpCounterCode(secret, c))) == 1 {
+			counter, valid = c, true
+		}
+	}
+	return counter, valid
+}
+
+// totpFallback accepts the totp codes of a key, each once, until
+// totpMaxAttempts invalid ones were entered
+type totpFallback struct {
+	fingerprint string
+	secret      []byte
+	failures    int
+}
+
+func newTOTPFallback(fingerprint string) (*totpFallback, error) {
+	secret, err := getTOTPSecret(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	return &totpFallback{fingerprint: fingerprint, secret: secret}, nil
+}
+
+// disabled tells whether too many invalid codes were entered
+func (f *totpFallback) disabled() bool {
+	return f.failures >= totpMaxAttempts
+}
+
+// use accepts code if valid at now and newer than the last accepted one,
+// invalid and replayed codes count as failures
+func (f *totpFallback) use(code string, now time.Time) error {
+	counter, ok := matchTOTP(f.secret, code, now)
+	if !ok {
+		f.failures++
+		return policyError("%w", ErrTOTPInvalid)
+	}
+	err := consumeTOTPCounter(f.fingerprint, counter)
+	if errors.Is(err, ErrTOTPReplayed) {
+		f.failures++
+	}
+	return err
+}
+
+// consumeTOTPCounter records counter as the last accepted time step of the
+// key, failing with ErrTOTPReplayed unless it is newer than the recorded one
+func consumeTOTPCounter(fingerprint string, counter int64) error {
+	if readOnlyDB != "" {
+		readOnlyTOTPCountersMu.Lock()
+		defer readOnlyTOTPCountersMu.Unlock()
+		key := [2]string{activeTenant, fingerprintID(fingerprint)}
+		if counter <= readOnlyTOTPCounters[key] {
+			return policyError("%w", ErrTOTPReplayed)
+		}
+		readOnlyTOTPCounters[key] = counter
+		return nil
+	}
+	args := append([]any{counter}, activeTenantIDs(fingerprint)...)
+	res, err := db.ExecContext(commandCtx, `UPDATE totp SET last_counter = ? WHERE tenant = ? AND fingerprint IN (?, ?) AND last_counter < ?`, append(args, counter)...)
+	if err != nil {
+		return dbError("failed to record totp code use: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return dbError("failed to record totp code use: %w", err)
+	} else if n == 0 {
+		return policyError("%w", ErrTOTPReplayed)
+	}
+	return nil
+}
+
+// isTOTPCode tells whether input is shaped like a totp code, challenges are
+// never 6 characters long as their length is a power of two
+func isTOTPCode(input string) bool {
+	if len(input) != totpDigits {
+		return false
+	}
+	for _, c := range input {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func getTOTPSecret(fingerprint string) ([]byte, error) {
+	var secret []byte
+	err := db.QueryRow(`SELECT secret FROM totp WHERE tenant = ? AND fingerprint IN (?, ?)`, activeTenantIDs(fingerprint)...).Scan(&secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, policyError("%w: %s", ErrTOTPNotEnrolled, fingerprint)
+	}
+	if err != nil {
+		return nil, dbError("failed to query totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+func totp(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: pgp-mfa totp <enroll|verify> <fingerprint> [code]")
+	}
+	switch args[0] {
+	case "enroll":
+		return totpEnroll(args[1:])
+	case "verify":
+		return totpVerify(args[1:])
+	default:
+		return fmt.Errorf("unknown totp command '%s'", args[0])
+	}
+}
+
+func totpEnroll(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: pgp-mfa totp enroll <fingerprint>")
+	}
+	_, key, err := getKey(args[0], KeyQuery{})
+	if err != nil {
+		return err
+	}
+	fingerprint := key.GetFingerprint()
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+	_, err = db.Exec(`INSERT INTO totp (tenant, fingerprint, secret, created_at) VALUES (?, ?, ?, ?)`,
+		activeTenant,
+		fingerprintID(fingerprint),
+		secret,
+		time.Now(),
+	)
+	if err != nil {
+		return dbError("totp enroll error: %w", err)
+	}
+
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/pgp-mfa:" + fingerprint,
+		RawQuery: url.Values{"secret": {encoded}, "issuer": {"pgp-mfa"}}.Encode(),
+	}
+	fmt.Println("totp secret:", encoded)
+	fmt.Println("provisioning uri:", uri.String())
+	return nil
+}
+
+func totpVerify(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: pgp-mfa totp verify <fingerprint> <code>")
+	}
+	fallback, err := newTOTPFallback(args[0])
+	if err != nil {
+		return err
+	}
+	if err := fallback.use(strings.TrimSpace(args[1]), time.Now()); err != nil {
+		return err
+	}
+	fmt.Println("totp code valid!")
+	return nil
+}
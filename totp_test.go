@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B SHA1 vectors, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, expected := range vectors {
+		if code := totpCode(secret, time.Unix(ts, 0)); code != expected {
+			t.Errorf("t=%d: got %s, expected %s", ts, code, expected)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	if counter, ok := matchTOTP(secret, totpCode(secret, now.Add(-totpPeriod)), now); !ok || counter != totpCounter(now)-1 {
+		t.Errorf("previous period code: got %d, %v, expected %d", counter, ok, totpCounter(now)-1)
+	}
+	if _, ok := matchTOTP(secret, totpCode(secret, now.Add(-3*totpPeriod)), now); ok {
+		t.Error("stale code should be rejected")
+	}
+}
+
+func TestTOTPEnrollVerify(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := ecKey.GetFingerprint()
+
+	if err := totpVerify([]string{fingerprint, "000000"}); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Errorf("got %v, expected %v", err, ErrTOTPNotEnrolled)
+	}
+	if err := totpEnroll([]string{fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+	if err := totpEnroll([]string{fingerprint}); !errors.Is(err, ErrDatabase) {
+		t.Errorf("double enrollment: got %v, expected database error", err)
+	}
+
+	secret, err := getTOTPSecret(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := totpVerify([]string{fingerprint, totpCode(secret, time.Now())}); err != nil {
+		t.Errorf("valid code rejected: %v", err)
+	}
+	// Codes are used once, older ones are refused too
+	for _, at := range []time.Time{time.Now(), time.Now().Add(-totpPeriod)} {
+		if err := totpVerify([]string{fingerprint, totpCode(secret, at)}); !errors.Is(err, ErrTOTPReplayed) {
+			t.Errorf("replayed code: got %v, expected %v", err, ErrTOTPReplayed)
+		}
+	}
+	wrong := totpCode(secret, time.Now().Add(time.Hour))
+	if err := totpVerify([]string{fingerprint, wrong}); !errors.Is(err, ErrTOTPInvalid) {
+		t.Errorf("got %v, expected %v", err, ErrTOTPInvalid)
+	}
+}
+
+func TestTOTPFallbackAttempts(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := ecKey.GetFingerprint()
+	if err := totpEnroll([]string{fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+	solution := []byte("s3cr3t")
+	exp := time.Now().Add(time.Minute)
+
+	fallback, err := newTOTPFallback(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := totpCode(fallback.secret, time.Now())
+	wrong := totpCode(fallback.secret, time.Now().Add(time.Hour))
+	input := strings.Repeat(wrong+"\n", totpMaxAttempts) + valid + "\n"
+	err = solveChallenge(strings.NewReader(input), io.Discard, true, solution, fallback, exp, ChallengeConfig{}, nil, nil, nil)
+	if err == nil || !fallback.disabled() {
+		t.Fatalf("got %v, expected the totp fallback to be disabled after %d invalid codes", err, totpMaxAttempts)
+	}
+	// The challenge itself is still accepted
+	if err := solveChallenge(strings.NewReader(valid+"\ns3cr3t\n"), io.Discard, true, solution, fallback, exp, ChallengeConfig{}, nil, nil, nil); err != nil {
+		t.Errorf("challenge solution rejected: %v", err)
+	}
+
+	fallback, err = newTOTPFallback(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := solveChallenge(strings.NewReader(valid+"\n"), io.Discard, false, solution, fallback, exp, ChallengeConfig{}, nil, nil, nil); err != nil {
+		t.Errorf("valid code rejected: %v", err)
+	}
+	fallback, err = newTOTPFallback(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := solveChallenge(strings.NewReader(valid+"\n"), io.Discard, false, solution, fallback, exp, ChallengeConfig{}, nil, nil, nil); err == nil {
+		t.Error("replayed code accepted")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// trustLevels are gpg's owner trust levels from lowest to highest, unknown
+// is the default and only matters once a minimum trust is required
+var trustLevels = []string{"none", "unknown", "marginal", "full", "ultimate"}
+
+const defaultTrust = "unknown"
+
+var (
+	ErrTrustLevel        = errors.New("invalid trust level, expected none, unknown, marginal, full or ultimate")
+	ErrInsufficientTrust = errors.New("key trust is below the required minimum")
+)
+
+// parseTrust normalizes a trust level, empty is the default level
+func parseTrust(level string) (string, error) {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "" {
+		return defaultTrust, nil
+	}
+	if !slices.Contains(trustLevels, level) {
+		return "", parseError("%w: '%s'", ErrTrustLevel, level)
+	}
+	return level, nil
+}
+
+// trustedLevels lists the levels at or above minTrust
+func trustedLevels(minTrust string) []string {
+	return trustLevels[max(slices.Index(trustLevels, minTrust), 0):]
+}
+
+// trustAtLeast tells whether level meets minTrust, anything does when
+// minTrust is empty
+func trustAtLeast(level, minTrust string) bool {
+	if minTrust == "" {
+		return true
+	}
+	return slices.Contains(trustedLevels(minTrust), cmp.Or(level, defaultTrust))
+}
+
+func trustKey(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: pgp-mfa trust <key-id> <none|unknown|marginal|full|ultimate>")
+	}
+	level, err := parseTrust(args[1])
+	if err != nil {
+		return err
+	}
+	return store.Update(args[0], func(info *KeyInfo) {
+		info.Trust = level
+	})
+}
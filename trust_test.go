@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTrustLevels(t *testing.T) {
+	useTestDB(t)
+	if err := importKey([]string{"--trust", "full", writeKeyFile(t, ecKey, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{writeKeyFile(t, rsa3072Key, false)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importKey([]string{"--trust", "absolute", writeKeyFile(t, rsa4092Key, false)}); !errors.Is(err, ErrTrustLevel) {
+		t.Errorf("got %v, expected %v", err, ErrTrustLevel)
+	}
+	if stored, _ := store.Get(rsa3072Key.GetFingerprint()); stored.Trust != defaultTrust {
+		t.Errorf("got trust %q, expected %q", stored.Trust, defaultTrust)
+	}
+
+	keys, err := store.List(KeyQuery{MinTrust: "marginal"})
+	if err != nil || len(keys) != 1 || keys[0].Fingerprint != ecKey.GetFingerprint() {
+		t.Errorf("min trust filter: got %+v (%v), expected the fully trusted key", keys, err)
+	}
+	if keys, _ = store.List(KeyQuery{MinTrust: "none"}); len(keys) != 2 {
+		t.Errorf("got %d keys trusted at least none, expected 2", len(keys))
+	}
+
+	err = challenge([]string{"--min-trust", "marginal", "16", rsa3072Key.GetFingerprint()})
+	if !errors.Is(err, ErrInsufficientTrust) || !errors.Is(err, ErrPolicy) {
+		t.Errorf("challenge: got %v, expected %v", err, ErrInsufficientTrust)
+	}
+
+	if err := trustKey([]string{rsa3072Key.GetFingerprint(), "Ultimate"}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ = store.List(KeyQuery{MinTrust: "ultimate"}); len(keys) != 1 || keys[0].Fingerprint != rsa3072Key.GetFingerprint() {
+		t.Errorf("after trust update: got %+v", keys)
+	}
+	if err := trustKey([]string{rsa3072Key.GetFingerprint(), "blind"}); !errors.Is(err, ErrParse) {
+		t.Errorf("got %v, expected a parse error", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Setting sources, from the highest precedence
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceProfile = "profile"
+	sourceDefault = "default"
+)
+
+// resolvedSetting is an effective setting along with where it came from
+type resolvedSetting struct {
+	name, value, source string
+}
+
+// resolvedSettings are the global settings as resolved by main, in order
+var resolvedSettings []resolvedSetting
+
+// resolveGlobals records the parsed global flags and resolves the key store
+// location: --db, then $PGP_MFA_DB, then the database of the profile
+func resolveGlobals(global *flag.FlagSet) (string, error) {
+	set := setFlags(global)
+	resolvedSettings = nil
+	global.VisitAll(func(f *flag.Flag) {
+		if f.Name == "db" {
+			return
+		}
+		source := sourceDefault
+		if set[f.Name] {
+			source = sourceFlag
+		}
+		resolvedSettings = append(resolvedSettings, resolvedSetting{f.Name, f.Value.String(), source})
+	})
+	dsn, source := global.Lookup("db").Value.String(), sourceFlag
+	switch {
+	case set["db"]:
+	case os.Getenv("PGP_MFA_DB") != "":
+		source = sourceEnv
+	default:
+		var err error
+		if dsn, err = profilePath(activeProfile); err != nil {
+			return "", err
+		}
+		if activeProfile == defaultProfile {
+			dsn = migrateLegacyDB(dsn)
+		}
+		source = sourceProfile
+	}
+	resolvedSettings = append(resolvedSettings, resolvedSetting{"db", dsn, source})
+	return dsn, nil
+}
+
+// whoami prints the effective configuration and where each setting comes
+// from, to debug settings that do not seem to apply
+func whoami(args []string) error {
+	fs := flag.NewFlagSet("whoami", flag.ContinueOnError)
+	format := fs.String("output-format", "", "table, csv or json")
+	asJSON := fs.Bool("json", false, "shorthand for --output-format json")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: pgp-mfa whoami [--output-format table|csv|json] [--json]")
+	}
+	if *asJSON {
+		*format = formatJSON
+	}
+	f, err := outputFormat(*format)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, s := range resolvedSettings {
+		rows = append(rows, []string{s.name, s.value, s.source})
+	}
+	readOnly := []string{"read-only", "false", sourceDefault}
+	if readOnlyDB != "" {
+		readOnly = []string{"read-only", "true", "detected"}
+	}
+	rows = append(rows, readOnly,
+		[]string{"challenge-charset", challengeCharset, sourceDefault},
+		[]string{"challenge-timeout", ChallengeSolveTime.String(), sourceDefault},
+		[]string{"min-entropy", fmt.Sprint(MinChallengeEntropy), sourceDefault},
+		[]string{"rate-limit", strconv.Itoa(ChallengeRateBurst), sourceDefault},
+		[]string{"rate-window", ChallengeRateWindow.String(), sourceDefault},
+	)
+	return writeRecords(os.Stdout, f, []string{"setting", "value", "source"}, rows)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveGlobals(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataDir)
+	prevProfile := activeProfile
+	t.Cleanup(func() { activeProfile, resolvedSettings = prevProfile, nil })
+
+	resolve := func(env string, args ...string) map[string]resolvedSetting {
+		t.Helper()
+		t.Setenv("PGP_MFA_DB", env)
+		global := flag.NewFlagSet("pgp-mfa", flag.ContinueOnError)
+		global.String("db", env, "")
+		global.StringVar(&activeProfile, "profile", defaultProfile, "")
+		global.Bool("batch", false, "")
+		if err := global.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resolveGlobals(global); err != nil {
+			t.Fatal(err)
+		}
+		settings := make(map[string]resolvedSetting)
+		for _, s := range resolvedSettings {
+			settings[s.name] = s
+		}
+		return settings
+	}
+
+	settings := resolve("", "--profile", "work")
+	if s := settings["db"]; s.source != sourceProfile || s.value != filepath.Join(dataDir, "pgp-mfa", "work.db") {
+		t.Errorf("profile db: got %+v", s)
+	}
+	if s := settings["profile"]; s.source != sourceFlag || s.value != "work" {
+		t.Errorf("profile: got %+v", s)
+	}
+	if s := settings["batch"]; s.source != sourceDefault || s.value != "false" {
+		t.Errorf("batch: got %+v", s)
+	}
+	if s := resolve("env.db")["db"]; s.source != sourceEnv || s.value != "env.db" {
+		t.Errorf("env db: got %+v", s)
+	}
+	if s := resolve("env.db", "--db", "flag.db")["db"]; s.source != sourceFlag || s.value != "flag.db" {
+		t.Errorf("flag db: got %+v", s)
+	}
+}